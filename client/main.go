@@ -2,12 +2,15 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/url"
 	"os"
 	"os/signal"
+	"sort"
+	"sync"
 	"time"
 
 	"arhm/ecg-monitoring/pkg/ecg"
@@ -17,6 +20,9 @@ import (
 var addr = flag.String("addr", "localhost:8080", "http service address")
 var minSeverity = flag.String("minseverity", "warning", "minimum severity for beep alerts (normal, warning, critical)")
 var noColor = flag.Bool("no-color", false, "disable colored output")
+var patientID = flag.String("patient", "", "patient ID to monitor (default: all patients)")
+var detectQRS = flag.Bool("qrs", false, "detect beats in the lead II waveform instead of using the server's heart rate and RR interval (needs a server with -waveform)")
+var thresholdsFile = flag.String("thresholds", "", "JSON threshold profile with the ward's alarm limits (default: reference ranges)")
 
 const (
 	colorReset  = "\033[0m"
@@ -30,9 +36,13 @@ const (
 )
 
 const (
+	patientWidth    = 12
 	timestampWidth  = 19 // YYYY-MM-DD HH:MM:SS
 	heartRateWidth  = 10
 	rrIntervalWidth = 11
+	spo2Width       = 5
+	respWidth       = 5
+	bpWidth         = 9
 	statusWidth     = 25
 )
 
@@ -59,6 +69,10 @@ func formatWithColor(text string, condition ecg.HeartCondition) string {
 		}
 	case ecg.ConditionArrhythmia:
 		color = colorPurple
+	case ecg.ConditionVentricularTachycardia, ecg.ConditionVentricularFibrillation, ecg.ConditionAsystole:
+		color = colorRed
+	case ecg.ConditionSensorFault:
+		color = colorCyan
 	default:
 		color = colorWhite
 	}
@@ -100,10 +114,90 @@ func (n *ConsoleNotifier) Notify(condition ecg.HeartCondition) {
 	}
 }
 
+// agreement tallies how often the analysis matches the ground truth a server
+// in validation mode sends, per expected condition.
+type agreement struct {
+	mu      sync.Mutex
+	total   map[string]int
+	matched map[string]int
+}
+
+func newAgreement() *agreement {
+	return &agreement{total: make(map[string]int), matched: make(map[string]int)}
+}
+
+func (a *agreement) add(expected, analyzed string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.total[expected]++
+	if analyzed == expected {
+		a.matched[expected]++
+	}
+}
+
+func (a *agreement) print() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.total) == 0 {
+		return
+	}
+
+	expected := make([]string, 0, len(a.total))
+	for condition := range a.total {
+		expected = append(expected, condition)
+	}
+	sort.Strings(expected)
+
+	fmt.Println("Agreement with ground truth:")
+	for _, condition := range expected {
+		fmt.Printf("  %-12s %d/%d (%.1f%%)\n", condition, a.matched[condition], a.total[condition],
+			100*float64(a.matched[condition])/float64(a.total[condition]))
+	}
+}
+
+// detectBeats replaces the reading's heart rate, RR interval and beats with
+// those the patient's QRS detector finds in its waveform. Readings it cannot
+// use are returned unchanged, with ecg.ErrSignalLoss when the waveform shows
+// the signal was lost.
+func detectBeats(detectors map[string]*ecg.QRSDetector, reading ecg.ECGReading) (ecg.ECGReading, error) {
+	detector, ok := detectors[reading.PatientID]
+	if !ok {
+		var err error
+		if detector, err = ecg.NewQRSDetector(reading.SampleRate); err != nil {
+			log.Printf("[%s] QRS detection unavailable: %v", reading.PatientID, err)
+		}
+		// Stays nil after an error, so that it is only reported once.
+		detectors[reading.PatientID] = detector
+	}
+	if detector == nil {
+		return reading, nil
+	}
+
+	detected, err := detector.Detect(reading)
+	if errors.Is(err, ecg.ErrSignalLoss) {
+		return reading, err
+	}
+	if err != nil {
+		log.Printf("[%s] QRS detection failed: %v", reading.PatientID, err)
+		return reading, nil
+	}
+	return detected, nil
+}
+
 func main() {
 	flag.Parse()
 	log.SetFlags(0)
 
+	analyzer := ecg.NewAnalyzer(ecg.Thresholds{})
+	if *thresholdsFile != "" {
+		var err error
+		if analyzer, err = ecg.LoadAnalyzer(*thresholdsFile); err != nil {
+			log.Fatalf("Failed to load thresholds: %v", err)
+		}
+	}
+
 	fmt.Println("╔═══════════════════════════════════════════════════════════════════════════╗")
 	fmt.Println("║                                                                           ║")
 	fmt.Println("║                      ECG Monitoring Tool - Client                         ║")
@@ -113,7 +207,15 @@ func main() {
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt)
 
-	u := url.URL{Scheme: "ws", Host: *addr, Path: "/ecg"}
+	path := "/ecg"
+	if *patientID != "" {
+		path += "/" + url.PathEscape(*patientID)
+	}
+
+	u := url.URL{Scheme: "ws", Host: *addr, Path: path}
+	if *detectQRS {
+		u.RawQuery = url.Values{"leads": {string(ecg.LeadII)}}.Encode()
+	}
 	log.Printf("Connecting to %s", u.String())
 
 	c, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
@@ -128,10 +230,11 @@ func main() {
 
 	done := make(chan struct{})
 	readingCh := make(chan ecg.ECGReading)
+	truth := newAgreement()
 
 	fmt.Println(string(colorCyan) + "\nMonitoring started.\n" + string(colorReset))
 
-	tableWidth := timestampWidth + heartRateWidth + rrIntervalWidth + statusWidth + 11
+	tableWidth := patientWidth + timestampWidth + heartRateWidth + rrIntervalWidth + spo2Width + respWidth + bpWidth + statusWidth + 23
 	headerBorder := "╔"
 	for i := 0; i < tableWidth; i++ {
 		headerBorder += "═"
@@ -139,13 +242,21 @@ func main() {
 	headerBorder += "╗"
 
 	fmt.Println(headerBorder)
-	fmt.Printf("║ %-*s │ %-*s │ %-*s │ %-*s ║\n",
+	fmt.Printf("║ %-*s │ %-*s │ %-*s │ %-*s │ %-*s │ %-*s │ %-*s │ %-*s ║\n",
+		patientWidth, "Patient",
 		timestampWidth, "Timestamp",
 		heartRateWidth, "Heart Rate",
 		rrIntervalWidth, "RR Interval",
+		spo2Width, "SpO2",
+		respWidth, "Resp",
+		bpWidth, "BP",
 		statusWidth, "Status")
 
 	separatorRow := "╟"
+	for i := 0; i < patientWidth+2; i++ {
+		separatorRow += "─"
+	}
+	separatorRow += "┼"
 	for i := 0; i < timestampWidth+2; i++ {
 		separatorRow += "─"
 	}
@@ -158,6 +269,12 @@ func main() {
 		separatorRow += "─"
 	}
 	separatorRow += "┼"
+	for _, width := range []int{spo2Width, respWidth, bpWidth} {
+		for i := 0; i < width+2; i++ {
+			separatorRow += "─"
+		}
+		separatorRow += "┼"
+	}
 	for i := 0; i < statusWidth+2; i++ {
 		separatorRow += "─"
 	}
@@ -186,8 +303,47 @@ func main() {
 	}()
 
 	go func() {
+		// NIBP is intermittent, so the last measurement is shown until the
+		// next one arrives.
+		lastNIBP := make(map[string]*ecg.BloodPressure)
+		detectors := make(map[string]*ecg.FaultDetector)
+		rhythms := make(map[string]*ecg.RhythmAnalyzer)
+		qrsDetectors := make(map[string]*ecg.QRSDetector)
+
 		for reading := range readingCh {
-			condition := ecg.AnalyzeReading(reading)
+			var signalLoss error
+			if *detectQRS {
+				reading, signalLoss = detectBeats(qrsDetectors, reading)
+			}
+
+			detector, ok := detectors[reading.PatientID]
+			if !ok {
+				detector = ecg.NewFaultDetector(0)
+				detectors[reading.PatientID] = detector
+			}
+			rhythm, ok := rhythms[reading.PatientID]
+			if !ok {
+				rhythm = ecg.NewRhythmAnalyzer(analyzer)
+				rhythms[reading.PatientID] = rhythm
+			}
+
+			// Arrhythmia is decided over the last beats rather than one
+			// reading. Faulty readings would distort the window, so it
+			// starts over after one.
+			condition := rhythm.Analyze(reading)
+			err := detector.Check(reading)
+			if err == nil {
+				err = signalLoss
+			}
+			if err != nil {
+				condition = ecg.TechnicalAlert(reading, err)
+				rhythm.Reset()
+			}
+			// Readings altered by injected faults or artifacts have no
+			// clinical truth.
+			if reading.Truth != nil && len(reading.Truth.Faults) == 0 && reading.SignalQuality == ecg.SignalGood {
+				truth.add(reading.Truth.Expected, condition.Type)
+			}
 
 			timestamp := reading.Timestamp.Format("2006-01-02 15:04:05")
 			status := condition.Type
@@ -195,10 +351,28 @@ func main() {
 				status = fmt.Sprintf("%s (%s)", condition.Type, condition.Severity)
 			}
 
-			statusText := fmt.Sprintf("║ %-*s │ %*d │ %*.2f │ %-*s ║",
+			spo2, resp, bp := "--", "--", "--"
+			if vitals := reading.Vitals; vitals != nil {
+				spo2 = fmt.Sprintf("%d%%", vitals.SpO2)
+				resp = fmt.Sprintf("%d", vitals.RespirationRate)
+				if vitals.NIBP != nil {
+					lastNIBP[reading.PatientID] = vitals.NIBP
+				}
+				if vitals.ABP != nil {
+					bp = vitals.ABP.String()
+				} else if nibp := lastNIBP[reading.PatientID]; nibp != nil {
+					bp = nibp.String()
+				}
+			}
+
+			statusText := fmt.Sprintf("║ %-*s │ %-*s │ %*d │ %*.2f │ %*s │ %*s │ %*s │ %-*s ║",
+				patientWidth, reading.PatientID,
 				timestampWidth, timestamp,
 				heartRateWidth, reading.HeartRate,
 				rrIntervalWidth, reading.RRInterval,
+				spo2Width, spo2,
+				respWidth, resp,
+				bpWidth, bp,
 				statusWidth, status)
 
 			fmt.Println(formatWithColor(statusText, condition))
@@ -217,10 +391,12 @@ func main() {
 		select {
 		case <-done:
 			fmt.Println(footerBorder)
+			truth.print()
 			fmt.Println("Connection closed")
 			return
 		case <-interrupt:
 			fmt.Println(footerBorder)
+			truth.print()
 			log.Println("Interrupt received, closing connection...")
 
 			err := c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
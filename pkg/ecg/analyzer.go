@@ -0,0 +1,305 @@
+package ecg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Thresholds are alarm limits that replace those of a patient's reference
+// range. Zero fields keep the reference range's value. Heart rates are in
+// BPM, intervals and durations in seconds.
+type Thresholds struct {
+	MinHeartRate     int `json:"min_heart_rate,omitempty"`
+	MaxHeartRate     int `json:"max_heart_rate,omitempty"`
+	CriticalLowRate  int `json:"critical_low_rate,omitempty"`
+	CriticalHighRate int `json:"critical_high_rate,omitempty"`
+
+	MinRRInterval         float64 `json:"min_rr_interval,omitempty"`
+	MaxRRInterval         float64 `json:"max_rr_interval,omitempty"`
+	CriticalMinRRInterval float64 `json:"critical_min_rr_interval,omitempty"`
+	CriticalMaxRRInterval float64 `json:"critical_max_rr_interval,omitempty"`
+
+	WideQRSDuration         float64 `json:"wide_qrs_duration,omitempty"`
+	MinVentricularTachyRate int     `json:"min_ventricular_tachy_rate,omitempty"`
+	MinFibrillationRate     int     `json:"min_fibrillation_rate,omitempty"`
+}
+
+func (t Thresholds) Validate() error {
+	for _, f := range []struct {
+		name  string
+		value float64
+	}{
+		{"min_heart_rate", float64(t.MinHeartRate)},
+		{"max_heart_rate", float64(t.MaxHeartRate)},
+		{"critical_low_rate", float64(t.CriticalLowRate)},
+		{"critical_high_rate", float64(t.CriticalHighRate)},
+		{"min_rr_interval", t.MinRRInterval},
+		{"max_rr_interval", t.MaxRRInterval},
+		{"critical_min_rr_interval", t.CriticalMinRRInterval},
+		{"critical_max_rr_interval", t.CriticalMaxRRInterval},
+		{"wide_qrs_duration", t.WideQRSDuration},
+		{"min_ventricular_tachy_rate", float64(t.MinVentricularTachyRate)},
+		{"min_fibrillation_rate", float64(t.MinFibrillationRate)},
+	} {
+		if f.value < 0 {
+			return fmt.Errorf("%s must not be negative", f.name)
+		}
+	}
+
+	return t.ValidateFor(AgeAdult)
+}
+
+// ValidateFor checks the limits combined with the age group's reference
+// range, which supplies those left unset.
+func (t Thresholds) ValidateFor(group AgeGroup) error {
+	if err := t.Apply(referenceRanges[group]).Validate(); err != nil {
+		return fmt.Errorf("with the %s reference range: %w", group, err)
+	}
+	return nil
+}
+
+// Apply returns the reference range with the thresholds' limits in place of
+// its own.
+func (t Thresholds) Apply(r ReferenceRange) ReferenceRange {
+	r.MinHeartRate = override(r.MinHeartRate, t.MinHeartRate)
+	r.MaxHeartRate = override(r.MaxHeartRate, t.MaxHeartRate)
+	r.CriticalLowRate = override(r.CriticalLowRate, t.CriticalLowRate)
+	r.CriticalHighRate = override(r.CriticalHighRate, t.CriticalHighRate)
+	r.MinRRInterval = override(r.MinRRInterval, t.MinRRInterval)
+	r.MaxRRInterval = override(r.MaxRRInterval, t.MaxRRInterval)
+	r.CriticalMinRRInterval = override(r.CriticalMinRRInterval, t.CriticalMinRRInterval)
+	r.CriticalMaxRRInterval = override(r.CriticalMaxRRInterval, t.CriticalMaxRRInterval)
+	r.WideQRSDuration = override(r.WideQRSDuration, t.WideQRSDuration)
+	r.MinVentricularTachyRate = override(r.MinVentricularTachyRate, t.MinVentricularTachyRate)
+	r.MinFibrillationRate = override(r.MinFibrillationRate, t.MinFibrillationRate)
+	return r
+}
+
+// merge returns the thresholds with the other's set limits in place of its
+// own.
+func (t Thresholds) merge(other Thresholds) Thresholds {
+	t.MinHeartRate = override(t.MinHeartRate, other.MinHeartRate)
+	t.MaxHeartRate = override(t.MaxHeartRate, other.MaxHeartRate)
+	t.CriticalLowRate = override(t.CriticalLowRate, other.CriticalLowRate)
+	t.CriticalHighRate = override(t.CriticalHighRate, other.CriticalHighRate)
+	t.MinRRInterval = override(t.MinRRInterval, other.MinRRInterval)
+	t.MaxRRInterval = override(t.MaxRRInterval, other.MaxRRInterval)
+	t.CriticalMinRRInterval = override(t.CriticalMinRRInterval, other.CriticalMinRRInterval)
+	t.CriticalMaxRRInterval = override(t.CriticalMaxRRInterval, other.CriticalMaxRRInterval)
+	t.WideQRSDuration = override(t.WideQRSDuration, other.WideQRSDuration)
+	t.MinVentricularTachyRate = override(t.MinVentricularTachyRate, other.MinVentricularTachyRate)
+	t.MinFibrillationRate = override(t.MinFibrillationRate, other.MinFibrillationRate)
+	return t
+}
+
+func override[T int | float64](value, limit T) T {
+	if limit != 0 {
+		return limit
+	}
+	return value
+}
+
+// Validate checks that warning limits lie inside the critical ones, that a
+// regular rhythm within the rate limits passes the RR limits, and that the
+// ventricular rhythms are recognised in order.
+func (r ReferenceRange) Validate() error {
+	switch {
+	case r.MinHeartRate >= r.MaxHeartRate:
+		return fmt.Errorf("min_heart_rate %d must be below max_heart_rate %d", r.MinHeartRate, r.MaxHeartRate)
+	case r.CriticalLowRate > r.MinHeartRate:
+		return fmt.Errorf("critical_low_rate %d must not be above min_heart_rate %d", r.CriticalLowRate, r.MinHeartRate)
+	case r.CriticalHighRate < r.MaxHeartRate:
+		return fmt.Errorf("critical_high_rate %d must not be below max_heart_rate %d", r.CriticalHighRate, r.MaxHeartRate)
+	case r.MinRRInterval >= r.MaxRRInterval:
+		return fmt.Errorf("min_rr_interval %0.2f must be below max_rr_interval %0.2f", r.MinRRInterval, r.MaxRRInterval)
+	case r.CriticalMinRRInterval > r.MinRRInterval:
+		return fmt.Errorf("critical_min_rr_interval %0.2f must not be above min_rr_interval %0.2f", r.CriticalMinRRInterval, r.MinRRInterval)
+	case r.CriticalMaxRRInterval < r.MaxRRInterval:
+		return fmt.Errorf("critical_max_rr_interval %0.2f must not be below max_rr_interval %0.2f", r.CriticalMaxRRInterval, r.MaxRRInterval)
+	case 60/float64(r.MaxHeartRate) < r.MinRRInterval:
+		return fmt.Errorf("min_rr_interval %0.2f must not be above %0.2f, the RR interval at max_heart_rate %d", r.MinRRInterval, 60/float64(r.MaxHeartRate), r.MaxHeartRate)
+	case 60/float64(r.MinHeartRate) > r.MaxRRInterval:
+		return fmt.Errorf("max_rr_interval %0.2f must not be below %0.2f, the RR interval at min_heart_rate %d", r.MaxRRInterval, 60/float64(r.MinHeartRate), r.MinHeartRate)
+	case r.MinVentricularTachyRate >= r.MinFibrillationRate:
+		return fmt.Errorf("min_ventricular_tachy_rate %d must be below min_fibrillation_rate %d", r.MinVentricularTachyRate, r.MinFibrillationRate)
+	}
+	return nil
+}
+
+// Analyzer classifies readings against a threshold profile, such as a ward's
+// alarm limits. Thresholds apply to adult patients, while children keep the
+// limits of their age group, and Patients overrides both for individual
+// patients by ID; limits neither sets come from each patient's reference
+// range. The zero value applies the reference ranges unchanged.
+type Analyzer struct {
+	Name       string                `json:"name,omitempty"`
+	Thresholds Thresholds            `json:"thresholds"`
+	Patients   map[string]Thresholds `json:"patients,omitempty"`
+}
+
+var defaultAnalyzer = &Analyzer{}
+
+func NewAnalyzer(thresholds Thresholds) *Analyzer {
+	return &Analyzer{Thresholds: thresholds}
+}
+
+func LoadAnalyzer(path string) (*Analyzer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	analyzer, err := ParseAnalyzer(data)
+	if err != nil {
+		return nil, fmt.Errorf("thresholds %s: %w", path, err)
+	}
+
+	return analyzer, nil
+}
+
+func ParseAnalyzer(data []byte) (*Analyzer, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var analyzer Analyzer
+	if err := decoder.Decode(&analyzer); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	if err := analyzer.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &analyzer, nil
+}
+
+func (a *Analyzer) Validate() error {
+	if err := a.Thresholds.Validate(); err != nil {
+		return fmt.Errorf("thresholds: %w", err)
+	}
+	// A patient's age group is only known from the readings, so an override
+	// must suit at least one: an adult's together with the ward's limits, or
+	// a child's on its own.
+	for patientID, thresholds := range a.Patients {
+		err := a.Thresholds.merge(thresholds).Validate()
+		for _, group := range []AgeGroup{AgeNeonate, AgeInfant, AgeToddler, AgeChild, AgeAdolescent} {
+			if err == nil {
+				break
+			}
+			if thresholds.ValidateFor(group) == nil {
+				err = nil
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("patients[%s]: %w", patientID, err)
+		}
+	}
+	return nil
+}
+
+// ReferenceRange returns the limits the analyzer applies to the patient.
+func (a *Analyzer) ReferenceRange(patientID string, demographics Demographics) ReferenceRange {
+	// Like the reference range, unknown age groups are taken as adults.
+	var thresholds Thresholds
+	if _, ok := referenceRanges[demographics.AgeGroup]; !ok || demographics.AgeGroup == AgeAdult {
+		thresholds = a.Thresholds
+	}
+	if patient, ok := a.Patients[patientID]; ok {
+		thresholds = thresholds.merge(patient)
+	}
+	return thresholds.Apply(demographics.ReferenceRange())
+}
+
+// Analyze classifies the reading against the limits for its patient and
+// demographics, or the adult range without demographics. Readings with
+// impossible values raise a technical alert instead, see CheckReading.
+func (a *Analyzer) Analyze(reading ECGReading) HeartCondition {
+	var demographics Demographics
+	if reading.Demographics != nil {
+		demographics = *reading.Demographics
+	}
+	return a.AnalyzeFor(reading, demographics)
+}
+
+func (a *Analyzer) AnalyzeFor(reading ECGReading, demographics Demographics) HeartCondition {
+	if err := CheckReading(reading); err != nil {
+		return TechnicalAlert(reading, err)
+	}
+
+	r := a.ReferenceRange(reading.PatientID, demographics)
+
+	condition := HeartCondition{
+		Type:        ConditionNormal,
+		Description: "Normal heart activity",
+		Reading:     reading,
+		Severity:    "normal",
+	}
+
+	if reading.HeartRate <= 0 {
+		return HeartCondition{
+			Type:        ConditionAsystole,
+			Description: "No ventricular activity",
+			Reading:     reading,
+			Severity:    "critical",
+		}
+	} else if reading.HeartRate >= r.MinFibrillationRate {
+		return HeartCondition{
+			Type:        ConditionVentricularFibrillation,
+			Description: fmt.Sprintf("Chaotic ventricular activity: %d BPM", reading.HeartRate),
+			Reading:     reading,
+			Severity:    "critical",
+		}
+	} else if reading.QRSDuration >= r.WideQRSDuration && reading.HeartRate > r.MinVentricularTachyRate {
+		return HeartCondition{
+			Type:        ConditionVentricularTachycardia,
+			Description: fmt.Sprintf("Wide complex tachycardia: %d BPM, QRS %0.2f s", reading.HeartRate, reading.QRSDuration),
+			Reading:     reading,
+			Severity:    "critical",
+		}
+	}
+
+	if reading.HeartRate > r.MaxHeartRate {
+		condition = HeartCondition{
+			Type:        ConditionTachycardia,
+			Description: fmt.Sprintf("High heart rate: %d BPM", reading.HeartRate),
+			Reading:     reading,
+			Severity:    "warning",
+		}
+
+		if reading.HeartRate > r.CriticalHighRate {
+			condition.Severity = "critical"
+		}
+
+		return condition
+	} else if reading.HeartRate < r.MinHeartRate {
+		condition = HeartCondition{
+			Type:        ConditionBradycardia,
+			Description: fmt.Sprintf("Low heart rate: %d BPM", reading.HeartRate),
+			Reading:     reading,
+			Severity:    "warning",
+		}
+
+		if reading.HeartRate < r.CriticalLowRate {
+			condition.Severity = "critical"
+		}
+
+		return condition
+	}
+
+	if reading.RRInterval < r.MinRRInterval || reading.RRInterval > r.MaxRRInterval {
+		condition = HeartCondition{
+			Type:        ConditionArrhythmia,
+			Description: fmt.Sprintf("Irregular heartbeat: RR interval %0.2f s", reading.RRInterval),
+			Reading:     reading,
+			Severity:    "warning",
+		}
+
+		if reading.RRInterval > r.CriticalMaxRRInterval || reading.RRInterval < r.CriticalMinRRInterval {
+			condition.Severity = "critical"
+		}
+	}
+
+	return condition
+}
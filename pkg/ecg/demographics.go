@@ -0,0 +1,112 @@
+package ecg
+
+import "fmt"
+
+type AgeGroup string
+
+const (
+	AgeNeonate    AgeGroup = "neonate"    // First 28 days
+	AgeInfant     AgeGroup = "infant"     // 1-12 months
+	AgeToddler    AgeGroup = "toddler"    // 1-3 years
+	AgeChild      AgeGroup = "child"      // 4-11 years
+	AgeAdolescent AgeGroup = "adolescent" // 12-17 years
+	AgeAdult      AgeGroup = "adult"
+)
+
+type Sex string
+
+const (
+	SexFemale Sex = "female"
+	SexMale   Sex = "male"
+)
+
+// Demographics describe the patient a reading belongs to. The zero value is
+// an adult, for whom the package's global thresholds apply.
+type Demographics struct {
+	AgeGroup AgeGroup `json:"age_group,omitempty"`
+	Sex      Sex      `json:"sex,omitempty"`
+	Athlete  bool     `json:"athlete,omitempty"`
+
+	// Lower rate limit of an implanted pacemaker in BPM; 0 without one.
+	PacemakerRate int `json:"pacemaker_rate,omitempty"`
+}
+
+func (d Demographics) Validate() error {
+	if _, ok := referenceRanges[d.AgeGroup]; !ok && d.AgeGroup != "" {
+		return fmt.Errorf("unknown age group %q (expected neonate, infant, toddler, child, adolescent or adult)", d.AgeGroup)
+	}
+	switch d.Sex {
+	case "", SexFemale, SexMale:
+	default:
+		return fmt.Errorf("unknown sex %q (expected female or male)", d.Sex)
+	}
+	if d.PacemakerRate != 0 && (d.PacemakerRate < 30 || d.PacemakerRate > 120) {
+		return fmt.Errorf("pacemaker_rate %d out of range [30, 120]", d.PacemakerRate)
+	}
+	return nil
+}
+
+// ReferenceRange holds the thresholds AnalyzeReading applies to one patient.
+// Heart rates are in BPM, intervals and durations in seconds.
+type ReferenceRange struct {
+	MinHeartRate     int // Bradycardia below
+	MaxHeartRate     int // Tachycardia above
+	CriticalLowRate  int
+	CriticalHighRate int
+
+	MinRRInterval         float64
+	MaxRRInterval         float64
+	CriticalMinRRInterval float64
+	CriticalMaxRRInterval float64
+
+	WideQRSDuration         float64
+	MinVentricularTachyRate int
+	MinFibrillationRate     int
+
+	RestingHeartRate int // Typical awake resting rate
+}
+
+// Awake resting reference values by age group, after the PALS tables.
+// Children's QRS complexes are narrower, so a wide complex starts earlier.
+// Fibrillation is recognised at the same rate at every age.
+var referenceRanges = map[AgeGroup]ReferenceRange{
+	AgeNeonate:    {100, 180, 80, 220, 0.33, 0.6, 0.25, 0.9, 0.08, 180, MinFibrillationRate, 140},
+	AgeInfant:     {100, 160, 80, 200, 0.375, 0.6, 0.28, 0.9, 0.08, 160, MinFibrillationRate, 130},
+	AgeToddler:    {90, 150, 60, 180, 0.4, 0.67, 0.3, 1.1, 0.09, 150, MinFibrillationRate, 115},
+	AgeChild:      {70, 120, 55, 160, 0.5, 0.86, 0.35, 1.2, 0.09, 130, MinFibrillationRate, 95},
+	AgeAdolescent: {60, 100, 45, 130, 0.6, 1.0, 0.4, 1.5, 0.10, 120, MinFibrillationRate, 75},
+	AgeAdult:      {MinNormalHeartRate, MaxNormalHeartRate, 45, 120, MinNormalRRInterval, MaxNormalRRInterval, 0.4, 1.5, WideQRSDuration, MinVentricularTachyRate, MinFibrillationRate, 75},
+}
+
+// ReferenceRange returns the age group's thresholds adjusted for training and
+// pacing. Trained athletes have a resting sinus bradycardia that is not
+// pathological, and a paced patient's rate should not fall below the
+// pacemaker's lower rate limit.
+func (d Demographics) ReferenceRange() ReferenceRange {
+	r, ok := referenceRanges[d.AgeGroup]
+	if !ok {
+		r = referenceRanges[AgeAdult]
+	}
+
+	if d.Sex == SexFemale {
+		r.RestingHeartRate += 3
+	}
+
+	if d.Athlete {
+		r.MinHeartRate = r.MinHeartRate * 2 / 3
+		r.CriticalLowRate = r.CriticalLowRate * 2 / 3
+		r.MaxRRInterval = 60.0 / float64(r.MinHeartRate)
+		r.CriticalMaxRRInterval = 60.0 / float64(r.CriticalLowRate)
+		r.RestingHeartRate = r.MinHeartRate + 12
+	}
+
+	if d.PacemakerRate > 0 {
+		r.MinHeartRate = d.PacemakerRate
+		r.CriticalLowRate = d.PacemakerRate - 10
+		r.MaxRRInterval = 60.0 / float64(r.MinHeartRate)
+		r.CriticalMaxRRInterval = 60.0 / float64(r.CriticalLowRate)
+		r.RestingHeartRate = max(r.RestingHeartRate, d.PacemakerRate)
+	}
+
+	return r
+}
@@ -1,14 +1,183 @@
 package ecg
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
 	"time"
 )
 
+type BeatType string
+
+const (
+	BeatNormal BeatType = "normal"
+	BeatPVC    BeatType = "pvc" // Premature ventricular contraction
+	BeatPAC    BeatType = "pac" // Premature atrial contraction
+
+	BeatVentricular BeatType = "ventricular" // Beat of a sustained ventricular rhythm
+)
+
+// SignalQuality marks a reading whose waveform does not show the patient's
+// rhythm, so that neither it nor the values derived from it can be trusted.
+type SignalQuality string
+
+const (
+	SignalGood    SignalQuality = ""
+	SignalLeadOff SignalQuality = "lead_off" // An electrode is off
+	SignalNoisy   SignalQuality = "noisy"    // Motion artifact swamps the ECG
+)
+
 type ECGReading struct {
+	PatientID  string    `json:"patient_id,omitempty"`
 	Timestamp  time.Time `json:"timestamp"`
 	HeartRate  int       `json:"heart_rate"`
 	RRInterval float64   `json:"rr_interval"`
+	BeatType   BeatType  `json:"beat_type,omitempty"`
+
+	// QRS width in seconds when known; wide complexes indicate a ventricular origin.
+	QRSDuration float64 `json:"qrs_duration,omitempty"`
+
+	// Optional waveform covering the interval since the previous reading,
+	// either a single channel or one channel per lead.
+	SampleRate int              `json:"sample_rate,omitempty"`
+	Samples    Samples          `json:"samples,omitempty"`
+	Leads      map[Lead]Samples `json:"leads,omitempty"`
+
+	// The worst quality of the signal during the interval.
+	SignalQuality SignalQuality `json:"signal_quality,omitempty"`
+
+	// Other monitored parameters, when the source provides them.
+	Vitals *Vitals `json:"vitals,omitempty"`
+
+	// The patient's demographics when they differ from the adult defaults,
+	// selecting the thresholds AnalyzeReading applies.
+	Demographics *Demographics `json:"demographics,omitempty"`
+
+	// Every beat whose R peak fell in the interval since the previous
+	// reading, when the source tracks individual beats.
+	Beats []Beat `json:"beats,omitempty"`
+
+	// What a simulated source knows the reading to be, for validating
+	// detectors against it. Servers only send it in validation mode.
+	Truth *GroundTruth `json:"truth,omitempty"`
+}
+
+// GroundTruth labels a simulated reading. Condition is the simulator's own
+// name for the rhythm and Expected the condition AnalyzeReading should report
+// for it. Consecutive readings of the same rhythm share an EpisodeID, which
+// counts up from 1 per patient. Faults lists the injected sensor faults that
+// altered the reading, if any.
+type GroundTruth struct {
+	Condition string   `json:"condition"`
+	Expected  string   `json:"expected"`
+	EpisodeID int      `json:"episode_id"`
+	Faults    []string `json:"faults,omitempty"`
+}
+
+// Beat is a single heartbeat, stamped at its R peak. RRInterval is the time
+// since the previous R peak in seconds.
+type Beat struct {
+	PatientID  string          `json:"patient_id,omitempty"`
+	Timestamp  time.Time       `json:"timestamp"`
+	RRInterval float64         `json:"rr_interval"`
+	BeatType   BeatType        `json:"beat_type"`
+	Morphology *BeatMorphology `json:"morphology,omitempty"`
+}
+
+// BeatMorphology describes the shape of a beat when a waveform is available.
+type BeatMorphology struct {
+	QRSDuration float64 `json:"qrs_duration"` // Seconds
+	RAmplitude  float64 `json:"r_amplitude"`  // mV in lead II
+}
+
+// A faulty sensor can report an RR interval that is not a number, which is
+// sent as null.
+func (r ECGReading) MarshalJSON() ([]byte, error) {
+	type reading ECGReading
+	if !math.IsNaN(r.RRInterval) && !math.IsInf(r.RRInterval, 0) {
+		return json.Marshal(reading(r))
+	}
+	return json.Marshal(struct {
+		reading
+		RRInterval *float64 `json:"rr_interval"`
+	}{reading: reading(r)})
+}
+
+func (r *ECGReading) UnmarshalJSON(data []byte) error {
+	type reading ECGReading
+	wire := struct {
+		*reading
+		RRInterval json.RawMessage `json:"rr_interval"`
+	}{reading: (*reading)(r)}
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return err
+	}
+
+	switch string(wire.RRInterval) {
+	case "":
+		return nil
+	case "null":
+		r.RRInterval = math.NaN()
+		return nil
+	default:
+		return json.Unmarshal(wire.RRInterval, &r.RRInterval)
+	}
+}
+
+// Vitals are the non-ECG parameters of a bedside monitor frame. PulseRate is
+// derived from the pleth and is 0 when no pulse is detected.
+type Vitals struct {
+	SpO2            int `json:"spo2"`             // %
+	PulseRate       int `json:"pulse_rate"`       // BPM
+	RespirationRate int `json:"respiration_rate"` // Breaths per minute
+
+	// Non-invasive pressure is only present in the frame where a cuff
+	// measurement completed; arterial pressure is continuous when monitored.
+	NIBP *BloodPressure `json:"nibp,omitempty"`
+	ABP  *BloodPressure `json:"abp,omitempty"`
+}
+
+// BloodPressure is in mmHg.
+type BloodPressure struct {
+	Systolic  int `json:"systolic"`
+	Diastolic int `json:"diastolic"`
+	Mean      int `json:"mean"`
+}
+
+func (bp BloodPressure) String() string {
+	return fmt.Sprintf("%d/%d", bp.Systolic, bp.Diastolic)
+}
+
+// Samples is a waveform in millivolts. Missing samples are NaN in memory and
+// null on the wire.
+type Samples []float64
+
+func (s Samples) MarshalJSON() ([]byte, error) {
+	values := make([]*float64, len(s))
+	for i := range s {
+		if !math.IsNaN(s[i]) {
+			values[i] = &s[i]
+		}
+	}
+	return json.Marshal(values)
+}
+
+func (s *Samples) UnmarshalJSON(data []byte) error {
+	var values []*float64
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+
+	samples := make(Samples, len(values))
+	for i, v := range values {
+		if v == nil {
+			samples[i] = math.NaN()
+		} else {
+			samples[i] = *v
+		}
+	}
+	*s = samples
+	return nil
 }
 
 type HeartCondition struct {
@@ -25,62 +194,28 @@ const (
 	MinNormalRRInterval = 0.6 // 100 BPM
 	MaxNormalRRInterval = 1.0 // 60 BPM
 
-	ConditionNormal      = "NORMAL"
-	ConditionTachycardia = "TACHYCARDIA"
-	ConditionBradycardia = "BRADYCARDIA"
-	ConditionArrhythmia  = "ARRHYTHMIA"
+	WideQRSDuration         = 0.12 // Seconds
+	MinVentricularTachyRate = 120
+	MinFibrillationRate     = 300
+
+	ConditionNormal                  = "NORMAL"
+	ConditionTachycardia             = "TACHYCARDIA"
+	ConditionBradycardia             = "BRADYCARDIA"
+	ConditionArrhythmia              = "ARRHYTHMIA"
+	ConditionVentricularTachycardia  = "V-TACH"
+	ConditionVentricularFibrillation = "V-FIB"
+	ConditionAsystole                = "ASYSTOLE"
 )
 
+// AnalyzeReading classifies the reading against the reference range of the
+// reading's demographics, or the adult range without them, with no ward
+// thresholds. See Analyzer.
 func AnalyzeReading(reading ECGReading) HeartCondition {
-	condition := HeartCondition{
-		Type:        ConditionNormal,
-		Description: "Normal heart activity",
-		Reading:     reading,
-		Severity:    "normal",
-	}
-
-	if reading.HeartRate > MaxNormalHeartRate {
-		condition = HeartCondition{
-			Type:        ConditionTachycardia,
-			Description: fmt.Sprintf("High heart rate: %d BPM", reading.HeartRate),
-			Reading:     reading,
-			Severity:    "warning",
-		}
-
-		if reading.HeartRate > 120 {
-			condition.Severity = "critical"
-		}
-
-		return condition
-	} else if reading.HeartRate < MinNormalHeartRate {
-		condition = HeartCondition{
-			Type:        ConditionBradycardia,
-			Description: fmt.Sprintf("Low heart rate: %d BPM", reading.HeartRate),
-			Reading:     reading,
-			Severity:    "warning",
-		}
-
-		if reading.HeartRate < 45 {
-			condition.Severity = "critical"
-		}
-
-		return condition
-	}
-
-	if reading.RRInterval < MinNormalRRInterval || reading.RRInterval > MaxNormalRRInterval {
-		condition = HeartCondition{
-			Type:        ConditionArrhythmia,
-			Description: fmt.Sprintf("Irregular heartbeat: RR interval %0.2f s", reading.RRInterval),
-			Reading:     reading,
-			Severity:    "warning",
-		}
-
-		if reading.RRInterval > 1.5 || reading.RRInterval < 0.4 {
-			condition.Severity = "critical"
-		}
-	}
+	return defaultAnalyzer.Analyze(reading)
+}
 
-	return condition
+func AnalyzeReadingFor(reading ECGReading, demographics Demographics) HeartCondition {
+	return defaultAnalyzer.AnalyzeFor(reading, demographics)
 }
 
 func FormatAlert(condition HeartCondition) string {
@@ -0,0 +1,149 @@
+package ecg
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Technical alerts report a problem with the device rather than the patient.
+const (
+	ConditionSensorFault = "SENSOR FAULT"
+	SeverityTechnical    = "technical"
+)
+
+const (
+	MaxMeasurableHeartRate = 500
+
+	// Consecutive identical readings after which the values are taken to be
+	// stuck.
+	StuckReadingCount = 5
+)
+
+var (
+	ErrLeadOff     = errors.New("ECG lead off")
+	ErrNoisySignal = errors.New("ECG too noisy to analyze")
+)
+
+// CheckReading reports readings of poor signal quality, and values no sensor
+// attached to a patient can produce: a heart rate outside the measurable
+// range, an RR interval that is not a positive number, or a heart rate that
+// disagrees with the RR interval.
+func CheckReading(reading ECGReading) error {
+	switch {
+	case reading.SignalQuality == SignalLeadOff:
+		return ErrLeadOff
+	case reading.SignalQuality == SignalNoisy:
+		return ErrNoisySignal
+	case reading.HeartRate < 0 || reading.HeartRate > MaxMeasurableHeartRate:
+		return fmt.Errorf("heart rate %d BPM outside the measurable range", reading.HeartRate)
+	case math.IsNaN(reading.RRInterval) || math.IsInf(reading.RRInterval, 0):
+		return fmt.Errorf("RR interval is not a number")
+	case reading.RRInterval < 0:
+		return fmt.Errorf("negative RR interval %0.2f s", reading.RRInterval)
+	case reading.HeartRate == 0 && reading.RRInterval > 0:
+		return fmt.Errorf("heart rate 0 BPM with RR interval %0.2f s", reading.RRInterval)
+	case reading.HeartRate > 0 && reading.RRInterval == 0:
+		return fmt.Errorf("heart rate %d BPM without an RR interval", reading.HeartRate)
+	}
+
+	// Irregular rhythms spread the RR interval around 60/HR, but never by
+	// this much.
+	if reading.HeartRate > 0 {
+		if ratio := float64(reading.HeartRate) * reading.RRInterval / 60; ratio < 0.4 || ratio > 2.5 {
+			return fmt.Errorf("heart rate %d BPM inconsistent with RR interval %0.2f s", reading.HeartRate, reading.RRInterval)
+		}
+	}
+	return nil
+}
+
+// TechnicalAlert wraps a sensor problem as a HeartCondition, so that it can
+// go through the same notifiers as clinical alarms.
+func TechnicalAlert(reading ECGReading, err error) HeartCondition {
+	return HeartCondition{
+		Type:        ConditionSensorFault,
+		Description: err.Error(),
+		Reading:     reading,
+		Severity:    SeverityTechnical,
+	}
+}
+
+// FaultDetector checks one patient's stream of readings for faults that only
+// show across readings: timestamps going backwards, duplicated readings,
+// missing readings and stuck values. Interval is the expected time between
+// readings; when zero it is taken to be the shortest gap seen so far.
+type FaultDetector struct {
+	Interval time.Duration
+
+	last    *ECGReading
+	learned time.Duration
+	repeats int
+}
+
+func NewFaultDetector(interval time.Duration) *FaultDetector {
+	return &FaultDetector{Interval: interval}
+}
+
+// Check returns an error describing the first fault found in the reading,
+// given the readings checked before it.
+func (d *FaultDetector) Check(reading ECGReading) error {
+	if err := CheckReading(reading); err != nil {
+		// A reading with a poor signal still arrived in sequence.
+		if reading.SignalQuality != SignalGood {
+			d.last, d.repeats = &reading, 0
+		}
+		return err
+	}
+
+	last := d.last
+	d.last = &reading
+	if last == nil {
+		return nil
+	}
+
+	gap := reading.Timestamp.Sub(last.Timestamp)
+	switch {
+	case gap < 0:
+		return fmt.Errorf("timestamp went back by %v", -gap)
+	case gap == 0 && sameValues(reading, *last):
+		return fmt.Errorf("duplicate reading at %s", reading.Timestamp.Format("15:04:05"))
+	case gap == 0:
+		return fmt.Errorf("two readings stamped %s", reading.Timestamp.Format("15:04:05"))
+	}
+
+	interval := d.Interval
+	if interval == 0 {
+		if d.learned == 0 || gap < d.learned {
+			d.learned = gap
+		}
+		interval = d.learned
+	}
+	if missing := int(math.Round(float64(gap)/float64(interval))) - 1; missing > 0 {
+		return fmt.Errorf("%d readings missing before %s", missing, reading.Timestamp.Format("15:04:05"))
+	}
+
+	if sameValues(reading, *last) && !steadyRhythm(reading) {
+		d.repeats++
+	} else {
+		d.repeats = 0
+	}
+	if d.repeats+1 >= StuckReadingCount {
+		return fmt.Errorf("values stuck at HR=%d, RR=%0.2f for %d readings", reading.HeartRate, reading.RRInterval, d.repeats+1)
+	}
+
+	return nil
+}
+
+func sameValues(a, b ECGReading) bool {
+	return a.HeartRate == b.HeartRate && a.RRInterval == b.RRInterval && a.BeatType == b.BeatType && a.QRSDuration == b.QRSDuration
+}
+
+// steadyRhythm is true for readings that legitimately repeat exactly: no
+// ventricular activity, or a pacemaker pacing at its lower rate limit.
+func steadyRhythm(reading ECGReading) bool {
+	if reading.HeartRate == 0 {
+		return true
+	}
+	return reading.Demographics != nil && reading.Demographics.PacemakerRate == reading.HeartRate
+}
@@ -0,0 +1,65 @@
+package ecg
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Lead string
+
+const (
+	LeadI   Lead = "I"
+	LeadII  Lead = "II"
+	LeadIII Lead = "III"
+	LeadAVR Lead = "aVR"
+	LeadAVL Lead = "aVL"
+	LeadAVF Lead = "aVF"
+	LeadV1  Lead = "V1"
+	LeadV2  Lead = "V2"
+	LeadV3  Lead = "V3"
+	LeadV4  Lead = "V4"
+	LeadV5  Lead = "V5"
+	LeadV6  Lead = "V6"
+)
+
+// StandardLeads are the twelve leads in conventional display order.
+var StandardLeads = []Lead{
+	LeadI, LeadII, LeadIII, LeadAVR, LeadAVL, LeadAVF,
+	LeadV1, LeadV2, LeadV3, LeadV4, LeadV5, LeadV6,
+}
+
+// ParseLeads parses a comma-separated lead list such as "II,V1,V5", matching
+// names case-insensitively. "all" selects the twelve standard leads.
+func ParseLeads(s string) ([]Lead, error) {
+	if strings.EqualFold(strings.TrimSpace(s), "all") {
+		return append([]Lead(nil), StandardLeads...), nil
+	}
+
+	var leads []Lead
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		lead, ok := parseLead(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown lead %q (expected one of %v or all)", name, StandardLeads)
+		}
+		leads = append(leads, lead)
+	}
+
+	if len(leads) == 0 {
+		return nil, fmt.Errorf("no leads selected")
+	}
+	return leads, nil
+}
+
+func parseLead(name string) (Lead, bool) {
+	for _, lead := range StandardLeads {
+		if strings.EqualFold(string(lead), name) {
+			return lead, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,420 @@
+package ecg
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	MinQRSSampleRate = 100
+	MaxQRSSampleRate = 2000
+
+	// Time without a detected beat after which the detector reports no
+	// ventricular activity.
+	AsystoleDelay = 4 * time.Second
+)
+
+// Timing of the Pan–Tompkins detector, in seconds.
+const (
+	qrsLearningPeriod = 2.0
+	qrsWindow         = 0.15 // Moving window integration
+	qrsRefractory     = 0.2
+	qrsTWaveWindow    = 0.36 // A peak this soon after a QRS may be its T wave
+	qrsSearchWindow   = 0.1  // Filter delay allowed for when locating the R peak
+	qrsRPeakWindow    = 0.05
+	qrsHistory        = 1.0
+	qrsGapTolerance   = 0.1 // Timestamp error allowed between readings
+	qrsFlatline       = 0.2 // Unchanging signal taken as lost
+)
+
+// Beats averaged for the heart rate, as in Pan and Tompkins' RR average 1.
+const qrsAveragedBeats = 8
+
+var (
+	ErrNoWaveform = errors.New("reading has no waveform")
+	ErrSignalLoss = errors.New("ECG signal lost")
+)
+
+// QRSDetector finds QRS complexes in a sampled ECG in real time with the
+// Pan–Tompkins algorithm: a 5-15 Hz band-pass filter, derivative, squaring
+// and moving window integration, followed by adaptive signal and noise
+// thresholds with search-back for missed beats and T wave rejection.
+//
+// The first two seconds of samples only train the thresholds. Beats are
+// reported once the next beat gives them an RR interval, stamped at the R
+// peak of the raw signal.
+type QRSDetector struct {
+	SampleRate int
+
+	// Time of the first sample. Set from the first reading by Detect, and
+	// again when it restarts after a gap.
+	Start time.Time
+
+	highPass, lowPass biquad
+	filtered          [5]float64 // Last band-passed samples, newest first
+	window            []float64  // Squared derivative over the integration window
+	windowSum         float64
+
+	// Ring buffers of the last qrsHistory seconds.
+	raw, bandPassed, slope []float64
+
+	n int // Samples processed
+
+	learningMax, learningSum float64
+	signalPeak, noisePeak    float64
+	learned                  bool
+
+	// Current peak of the integrated signal, and whether it is falling.
+	peakValue float64
+	peakIndex int
+	falling   bool
+
+	lastQRS   int // Sample index of the last R peak, -1 before the first
+	lastPeak  int // Sample index of its integrated peak
+	lastSlope float64
+	rr        []float64 // Last RR intervals in seconds, newest last
+
+	// Largest peak between the two thresholds since the last QRS, for
+	// search-back.
+	candidate      float64
+	candidateIndex int
+	candidateR     int
+}
+
+func NewQRSDetector(sampleRate int) (*QRSDetector, error) {
+	if sampleRate < MinQRSSampleRate || sampleRate > MaxQRSSampleRate {
+		return nil, fmt.Errorf("sample rate %d Hz out of range [%d, %d]", sampleRate, MinQRSSampleRate, MaxQRSSampleRate)
+	}
+
+	d := &QRSDetector{SampleRate: sampleRate}
+	d.Reset()
+	return d, nil
+}
+
+// Reset discards the signal seen so far, so that the detector learns its
+// thresholds again from the next samples.
+func (d *QRSDetector) Reset() {
+	rate := float64(d.SampleRate)
+	history := int(qrsHistory * rate)
+	*d = QRSDetector{
+		SampleRate: d.SampleRate,
+		highPass:   newHighPass(5, rate),
+		lowPass:    newLowPass(15, rate),
+		window:     make([]float64, int(math.Round(qrsWindow*rate))),
+		raw:        make([]float64, history),
+		bandPassed: make([]float64, history),
+		slope:      make([]float64, history),
+		lastQRS:    -1,
+	}
+}
+
+// Process feeds samples to the detector and returns the beats whose R peaks
+// it confirmed. Missing (NaN) samples repeat the previous sample.
+func (d *QRSDetector) Process(samples Samples) []Beat {
+	var beats []Beat
+	for _, x := range samples {
+		if math.IsNaN(x) {
+			x = d.raw[d.ring(d.n-1)]
+		}
+		if beat, ok := d.step(x); ok {
+			beats = append(beats, beat)
+		}
+		if beat, ok := d.searchBack(); ok {
+			beats = append(beats, beat)
+		}
+	}
+	return beats
+}
+
+// Detect runs the detector over the reading's waveform, either its single
+// channel or lead II, and returns the reading with the beats found, the last
+// RR interval and the heart rate averaged over the last eight beats. The
+// reading's own rate and RR interval are kept until the detector has found
+// two beats, and both become 0 after AsystoleDelay without a beat.
+//
+// The waveform is taken to end at the reading's timestamp. When it does not
+// continue the previous reading's, as after a dropped reading, the detector
+// restarts rather than join the two across the gap. A reading of poor
+// SignalQuality, or a waveform that stays exactly flat as with a lead off, is
+// no evidence of the rhythm: Detect returns ErrSignalLoss and restarts once
+// the signal is back.
+func (d *QRSDetector) Detect(reading ECGReading) (ECGReading, error) {
+	samples := reading.Samples
+	if len(samples) == 0 {
+		samples = reading.Leads[LeadII]
+	}
+	if len(samples) == 0 {
+		return reading, ErrNoWaveform
+	}
+	if reading.SampleRate != d.SampleRate {
+		return reading, fmt.Errorf("waveform sampled at %d Hz, detector expects %d Hz", reading.SampleRate, d.SampleRate)
+	}
+
+	start := reading.Timestamp.Add(-d.duration(len(samples)))
+	if d.n > 0 {
+		gap := start.Sub(d.Start.Add(d.duration(d.n)))
+		if math.Abs(gap.Seconds()) > qrsGapTolerance {
+			d.Reset()
+		}
+	}
+	if reading.SignalQuality != SignalGood || d.flatline(samples) {
+		d.Reset()
+		return reading, ErrSignalLoss
+	}
+	if d.n == 0 && d.Start.IsZero() {
+		d.Start = start
+	}
+
+	reading.Beats = d.Process(samples)
+	for i := range reading.Beats {
+		reading.Beats[i].PatientID = reading.PatientID
+	}
+
+	switch {
+	case d.lastQRS >= 0 && d.duration(d.n-d.lastQRS) > AsystoleDelay:
+		reading.HeartRate, reading.RRInterval = 0, 0
+	case len(d.rr) > 0:
+		reading.HeartRate = d.HeartRate()
+		reading.RRInterval = d.rr[len(d.rr)-1]
+	}
+	return reading, nil
+}
+
+// HeartRate is the rate over the last eight RR intervals in BPM, or 0 before
+// the second beat.
+func (d *QRSDetector) HeartRate() int {
+	if len(d.rr) == 0 {
+		return 0
+	}
+	return int(math.Round(60 / d.averageRR()))
+}
+
+func (d *QRSDetector) averageRR() float64 {
+	sum := 0.0
+	for _, rr := range d.rr {
+		sum += rr
+	}
+	return sum / float64(len(d.rr))
+}
+
+// flatline reports whether the samples hold the same value for qrsFlatline
+// seconds. Missing samples neither extend nor end a run.
+func (d *QRSDetector) flatline(samples Samples) bool {
+	run, last := 0, math.NaN()
+	for _, x := range samples {
+		switch {
+		case math.IsNaN(x):
+			continue
+		case x == last:
+			run++
+		default:
+			run = 0
+		}
+		last = x
+		if d.seconds(run) >= qrsFlatline {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *QRSDetector) duration(samples int) time.Duration {
+	return time.Duration(float64(samples) / float64(d.SampleRate) * float64(time.Second))
+}
+
+func (d *QRSDetector) seconds(samples int) float64 {
+	return float64(samples) / float64(d.SampleRate)
+}
+
+func (d *QRSDetector) ring(i int) int {
+	return ((i % len(d.raw)) + len(d.raw)) % len(d.raw)
+}
+
+// step filters one sample and classifies a peak of the integrated signal
+// once it has fallen to half its height.
+func (d *QRSDetector) step(x float64) (Beat, bool) {
+	i := d.n
+	d.n++
+
+	bandPassed := d.lowPass.filter(d.highPass.filter(x))
+	copy(d.filtered[1:], d.filtered[:4])
+	d.filtered[0] = bandPassed
+	derivative := (2*d.filtered[0] + d.filtered[1] - d.filtered[3] - 2*d.filtered[4]) * float64(d.SampleRate) / 8
+
+	squared := derivative * derivative
+	slot := i % len(d.window)
+	d.windowSum += squared - d.window[slot]
+	d.window[slot] = squared
+	integrated := d.windowSum / float64(len(d.window))
+
+	d.raw[d.ring(i)] = x
+	d.bandPassed[d.ring(i)] = bandPassed
+	d.slope[d.ring(i)] = math.Abs(derivative)
+
+	if !d.learned {
+		d.learningMax = max(d.learningMax, integrated)
+		d.learningSum += integrated
+		if d.seconds(d.n) >= qrsLearningPeriod {
+			d.signalPeak = d.learningMax / 4
+			d.noisePeak = d.learningSum / float64(d.n) / 2
+			d.learned = true
+		}
+		return Beat{}, false
+	}
+
+	switch {
+	case d.falling && integrated > d.peakValue:
+		// Rising again after a confirmed peak.
+		d.falling = false
+		d.peakValue, d.peakIndex = integrated, i
+	case d.falling:
+		d.peakValue = integrated
+	case integrated > d.peakValue:
+		d.peakValue, d.peakIndex = integrated, i
+	case integrated < d.peakValue/2:
+		d.falling = true
+		peak, index := d.peakValue, d.peakIndex
+		d.peakValue = integrated
+		return d.classify(peak, index)
+	}
+	return Beat{}, false
+}
+
+func (d *QRSDetector) thresholds() (float64, float64) {
+	threshold := d.noisePeak + 0.25*(d.signalPeak-d.noisePeak)
+	return threshold, threshold / 2
+}
+
+func (d *QRSDetector) classify(peak float64, index int) (Beat, bool) {
+	threshold, searchThreshold := d.thresholds()
+	since := d.seconds(index - d.lastPeak)
+
+	if d.lastQRS >= 0 && since < qrsRefractory {
+		return Beat{}, false
+	}
+
+	slope := d.maxSlope(index)
+	if peak > threshold {
+		if d.lastQRS >= 0 && since < qrsTWaveWindow && slope < d.lastSlope/2 {
+			d.noisePeak = 0.125*peak + 0.875*d.noisePeak
+			return Beat{}, false
+		}
+		d.signalPeak = 0.125*peak + 0.875*d.signalPeak
+		d.lastSlope = slope
+		return d.accept(d.rPeak(index), index)
+	}
+
+	d.noisePeak = 0.125*peak + 0.875*d.noisePeak
+	if peak > searchThreshold && peak > d.candidate {
+		d.candidate, d.candidateIndex, d.candidateR = peak, index, d.rPeak(index)
+	}
+	return Beat{}, false
+}
+
+// searchBack accepts the largest peak above the lower threshold when no QRS
+// was found for 166% of the average RR interval.
+func (d *QRSDetector) searchBack() (Beat, bool) {
+	if d.lastQRS < 0 || len(d.rr) == 0 || d.candidate == 0 {
+		return Beat{}, false
+	}
+	if d.seconds(d.n-d.lastQRS) < 1.66*d.averageRR() {
+		return Beat{}, false
+	}
+
+	d.signalPeak = 0.25*d.candidate + 0.75*d.signalPeak
+	d.lastSlope = d.maxSlope(d.candidateIndex)
+	return d.accept(d.candidateR, d.candidateIndex)
+}
+
+func (d *QRSDetector) accept(r, peak int) (Beat, bool) {
+	last := d.lastQRS
+	d.lastQRS, d.lastPeak = r, peak
+	d.candidate = 0
+	if last < 0 {
+		return Beat{}, false
+	}
+
+	rr := d.seconds(r - last)
+	d.rr = append(d.rr, rr)
+	if len(d.rr) > qrsAveragedBeats {
+		d.rr = d.rr[1:]
+	}
+	return Beat{Timestamp: d.Start.Add(d.duration(r)), RRInterval: rr}, true
+}
+
+// maxSlope is the steepest slope over the integration window ending at the
+// peak.
+func (d *QRSDetector) maxSlope(index int) float64 {
+	slope := 0.0
+	for i := max(index-len(d.window), d.n-len(d.slope)); i <= index; i++ {
+		slope = max(slope, d.slope[d.ring(i)])
+	}
+	return slope
+}
+
+// rPeak locates the R peak behind a peak of the integrated signal: the
+// largest band-passed deflection within the window and filter delay, then
+// the largest raw deflection just before it, since the filters delay the
+// band-passed peak.
+func (d *QRSDetector) rPeak(index int) int {
+	oldest := d.n - len(d.raw)
+	first := max(index-len(d.window)-int(qrsSearchWindow*float64(d.SampleRate)), oldest, d.lastQRS+1)
+
+	peak := index
+	for i := first; i <= index; i++ {
+		if math.Abs(d.bandPassed[d.ring(i)]) > math.Abs(d.bandPassed[d.ring(peak)]) {
+			peak = i
+		}
+	}
+
+	first = max(peak-int(qrsRPeakWindow*float64(d.SampleRate)), oldest, d.lastQRS+1)
+	mean := 0.0
+	for i := first; i <= peak; i++ {
+		mean += d.raw[d.ring(i)]
+	}
+	mean /= float64(peak - first + 1)
+
+	r := peak
+	for i := first; i <= peak; i++ {
+		if math.Abs(d.raw[d.ring(i)]-mean) > math.Abs(d.raw[d.ring(r)]-mean) {
+			r = i
+		}
+	}
+	return r
+}
+
+// biquad is a second-order IIR filter section.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+// Butterworth sections from the bilinear transform.
+func newLowPass(cutoff, sampleRate float64) biquad {
+	w := 2 * math.Pi * cutoff / sampleRate
+	alpha, cos := math.Sin(w)/math.Sqrt2, math.Cos(w)
+	a0 := 1 + alpha
+	return biquad{
+		b0: (1 - cos) / 2 / a0, b1: (1 - cos) / a0, b2: (1 - cos) / 2 / a0,
+		a1: -2 * cos / a0, a2: (1 - alpha) / a0,
+	}
+}
+
+func newHighPass(cutoff, sampleRate float64) biquad {
+	w := 2 * math.Pi * cutoff / sampleRate
+	alpha, cos := math.Sin(w)/math.Sqrt2, math.Cos(w)
+	a0 := 1 + alpha
+	return biquad{
+		b0: (1 + cos) / 2 / a0, b1: -(1 + cos) / a0, b2: (1 + cos) / 2 / a0,
+		a1: -2 * cos / a0, a2: (1 - alpha) / a0,
+	}
+}
+
+func (f *biquad) filter(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
@@ -0,0 +1,279 @@
+package ecg
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"time"
+)
+
+// Defaults of a new RhythmAnalyzer.
+const (
+	DefaultRhythmWindow = 30 * time.Second
+	DefaultMinBeats     = 10
+
+	// Rate alarms follow the rate over the last DefaultRateBeats beats, so
+	// that a premature beat and its pause do not read as tachycardia and
+	// bradycardia.
+	DefaultRateBeats = 8
+
+	// The rhythm is irregular when the coefficient of variation of the RR
+	// intervals exceeds DefaultMaxRRVariation and more than
+	// DefaultMaxIrregularFraction of successive intervals differ by over
+	// DefaultSuccessiveDifference of the median. Sinus arrhythmia varies
+	// slowly with breathing, and a single pause changes only two successive
+	// differences.
+	DefaultMaxRRVariation       = 0.10
+	DefaultSuccessiveDifference = 0.10
+	DefaultMaxIrregularFraction = 0.3
+
+	// Share of premature beats in the window above which ectopy is frequent:
+	// bigeminy and trigeminy, but not the odd isolated PVC.
+	DefaultMaxEctopicFraction = 0.2
+	DefaultMinEctopicRun      = 3
+
+	// Without a beat type, a beat is premature when its RR interval is this
+	// fraction of the median of the preceding beats' intervals or shorter.
+	DefaultPrematureRatio = 0.8
+)
+
+// Beats kept at most, whatever their timestamps.
+const maxWindowBeats = 512
+
+// Preceding beats whose median RR interval an untyped beat is judged
+// premature against, and the fewest needed to judge it. A local reference
+// follows changes of rate, where the median of the whole window would take
+// the faster beats for ectopy.
+const (
+	prematureReferenceBeats    = 8
+	minPrematureReferenceBeats = 4
+)
+
+// RhythmStats summarise the beats in a RhythmAnalyzer's window. Intervals are
+// in seconds.
+type RhythmStats struct {
+	Beats       int
+	HeartRate   int // BPM over the last RateBeats beats
+	MeanRR      float64
+	RRVariation float64 // Coefficient of variation
+	RMSSD       float64 // Root mean square of successive differences
+
+	// Share of successive intervals differing by over SuccessiveDifference
+	// of the median.
+	IrregularFraction float64
+
+	Ectopic               int // Premature beats
+	LongestRun            int // Consecutive premature beats
+	LongestVentricularRun int // Consecutive PVCs and ventricular beats
+}
+
+// RhythmAnalyzer classifies one patient's rhythm over a sliding window of
+// beats instead of a single reading, so that one out-of-range RR interval
+// does not raise an arrhythmia or rate alarm. Life-threatening rhythms still
+// come from Analyzer on each reading.
+type RhythmAnalyzer struct {
+	Analyzer *Analyzer // Nil applies the reference ranges
+
+	Window               time.Duration
+	MinBeats             int
+	RateBeats            int
+	MaxRRVariation       float64
+	SuccessiveDifference float64
+	MaxIrregularFraction float64
+	MaxEctopicFraction   float64
+	MinEctopicRun        int
+	PrematureRatio       float64
+
+	beats []Beat
+}
+
+func NewRhythmAnalyzer(analyzer *Analyzer) *RhythmAnalyzer {
+	return &RhythmAnalyzer{
+		Analyzer:             analyzer,
+		Window:               DefaultRhythmWindow,
+		MinBeats:             DefaultMinBeats,
+		RateBeats:            DefaultRateBeats,
+		MaxRRVariation:       DefaultMaxRRVariation,
+		SuccessiveDifference: DefaultSuccessiveDifference,
+		MaxIrregularFraction: DefaultMaxIrregularFraction,
+		MaxEctopicFraction:   DefaultMaxEctopicFraction,
+		MinEctopicRun:        DefaultMinEctopicRun,
+		PrematureRatio:       DefaultPrematureRatio,
+	}
+}
+
+// AddBeat adds a beat to the window and drops the beats that fell out of it.
+// Beats without a valid RR interval are ignored.
+func (a *RhythmAnalyzer) AddBeat(beat Beat) {
+	if !(beat.RRInterval > 0) || math.IsInf(beat.RRInterval, 0) {
+		return
+	}
+
+	a.beats = append(a.beats, beat)
+	start := beat.Timestamp.Add(-a.Window)
+	i := 0
+	for i < len(a.beats) && !a.beats[i].Timestamp.After(start) {
+		i++
+	}
+	i = max(i, len(a.beats)-maxWindowBeats)
+	a.beats = slices.Delete(a.beats, 0, i)
+}
+
+// Reset empties the window, for example after a gap in the readings.
+func (a *RhythmAnalyzer) Reset() {
+	a.beats = nil
+}
+
+// Analyze adds the reading's beats to the window, or the reading itself as a
+// beat when it carries none, and classifies it. Until the window holds
+// RateBeats beats the reading's own rate is used for rate alarms, and
+// arrhythmia is only reported once it holds MinBeats beats.
+func (a *RhythmAnalyzer) Analyze(reading ECGReading) HeartCondition {
+	analyzer := a.Analyzer
+	if analyzer == nil {
+		analyzer = defaultAnalyzer
+	}
+
+	// Technical alerts and lethal rhythms are final, and their beats stay
+	// out of the window.
+	condition := analyzer.Analyze(reading)
+	switch condition.Type {
+	case ConditionAsystole, ConditionVentricularFibrillation, ConditionVentricularTachycardia:
+		return condition
+	}
+	if condition.Severity == SeverityTechnical {
+		return condition
+	}
+
+	if len(reading.Beats) > 0 {
+		for _, beat := range reading.Beats {
+			a.AddBeat(beat)
+		}
+	} else if reading.HeartRate > 0 {
+		a.AddBeat(Beat{Timestamp: reading.Timestamp, RRInterval: reading.RRInterval, BeatType: reading.BeatType})
+	}
+
+	stats := a.Stats()
+	if stats.Beats >= a.RateBeats {
+		windowed := reading
+		windowed.HeartRate = stats.HeartRate
+		windowed.RRInterval = 60 / float64(stats.HeartRate)
+		condition = analyzer.Analyze(windowed)
+		condition.Reading = reading
+	}
+	if condition.Type == ConditionTachycardia || condition.Type == ConditionBradycardia {
+		return condition
+	}
+
+	condition = HeartCondition{
+		Type:        ConditionNormal,
+		Description: "Normal heart activity",
+		Reading:     reading,
+		Severity:    "normal",
+	}
+	arrhythmia := func(severity, format string, args ...any) HeartCondition {
+		return HeartCondition{
+			Type:        ConditionArrhythmia,
+			Description: fmt.Sprintf(format, args...),
+			Reading:     reading,
+			Severity:    severity,
+		}
+	}
+
+	switch {
+	case stats.Beats < a.MinBeats:
+		return condition
+	case stats.LongestVentricularRun >= a.MinEctopicRun:
+		return arrhythmia("critical", "Run of %d ventricular beats", stats.LongestVentricularRun)
+	case stats.LongestRun >= a.MinEctopicRun:
+		return arrhythmia("warning", "Run of %d premature beats", stats.LongestRun)
+	case float64(stats.Ectopic) > a.MaxEctopicFraction*float64(stats.Beats):
+		return arrhythmia("warning", "Frequent ectopy: %d of %d beats premature", stats.Ectopic, stats.Beats)
+	case stats.RRVariation > a.MaxRRVariation && stats.IrregularFraction > a.MaxIrregularFraction:
+		return arrhythmia("warning", "Irregular rhythm: RR variation %0.0f%%, RMSSD %0.0f ms over %d beats",
+			100*stats.RRVariation, 1000*stats.RMSSD, stats.Beats)
+	}
+	return condition
+}
+
+// Stats summarises the beats currently in the window. Beats with a beat type
+// are premature when they are PVCs, PACs or ventricular; beats without one
+// when their RR interval is short against the intervals just before them.
+func (a *RhythmAnalyzer) Stats() RhythmStats {
+	stats := RhythmStats{Beats: len(a.beats)}
+	if stats.Beats == 0 {
+		return stats
+	}
+
+	rr := make([]float64, len(a.beats))
+	for i, beat := range a.beats {
+		rr[i] = beat.RRInterval
+		stats.MeanRR += beat.RRInterval
+	}
+	stats.MeanRR /= float64(len(rr))
+
+	recent := rr[max(len(rr)-a.RateBeats, 0):]
+	meanRecent := 0.0
+	for _, interval := range recent {
+		meanRecent += interval
+	}
+	stats.HeartRate = int(math.Round(60 * float64(len(recent)) / meanRecent))
+
+	sorted := slices.Clone(rr)
+	slices.Sort(sorted)
+	median := sorted[len(sorted)/2]
+
+	var variance, successive float64
+	irregular := 0
+	for i := range rr {
+		variance += (rr[i] - stats.MeanRR) * (rr[i] - stats.MeanRR)
+		if i > 0 {
+			difference := rr[i] - rr[i-1]
+			successive += difference * difference
+			if math.Abs(difference) > a.SuccessiveDifference*median {
+				irregular++
+			}
+		}
+	}
+	stats.RRVariation = math.Sqrt(variance/float64(len(rr))) / stats.MeanRR
+	if len(rr) > 1 {
+		stats.RMSSD = math.Sqrt(successive / float64(len(rr)-1))
+		stats.IrregularFraction = float64(irregular) / float64(len(rr)-1)
+	}
+
+	run, ventricularRun := 0, 0
+	for i, beat := range a.beats {
+		premature := false
+		if i >= minPrematureReferenceBeats {
+			reference := slices.Clone(rr[max(i-prematureReferenceBeats, 0):i])
+			slices.Sort(reference)
+			premature = beat.RRInterval <= a.PrematureRatio*reference[len(reference)/2]
+		}
+		ventricular := false
+		switch beat.BeatType {
+		case "":
+		case BeatPVC, BeatVentricular:
+			premature, ventricular = true, true
+		case BeatPAC:
+			premature = true
+		default:
+			premature = false
+		}
+
+		if premature {
+			stats.Ectopic++
+			run++
+		} else {
+			run = 0
+		}
+		if ventricular {
+			ventricularRun++
+		} else {
+			ventricularRun = 0
+		}
+		stats.LongestRun = max(stats.LongestRun, run)
+		stats.LongestVentricularRun = max(stats.LongestVentricularRun, ventricularRun)
+	}
+
+	return stats
+}
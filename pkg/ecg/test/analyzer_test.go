@@ -0,0 +1,149 @@
+package ecg_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"arhm/ecg-monitoring/pkg/ecg"
+)
+
+func patientReading(patientID string, heartRate int) ecg.ECGReading {
+	return ecg.ECGReading{
+		PatientID:  patientID,
+		Timestamp:  time.Now(),
+		HeartRate:  heartRate,
+		RRInterval: 60 / float64(heartRate),
+	}
+}
+
+func TestAnalyzerDefaultsToReferenceRanges(t *testing.T) {
+	var analyzer ecg.Analyzer
+	for _, hr := range []int{30, 50, 75, 110, 130, 320} {
+		r := patientReading("PATIENT-1", hr)
+		if got, want := analyzer.Analyze(r), ecg.AnalyzeReading(r); got.Type != want.Type || got.Severity != want.Severity {
+			t.Errorf("HR=%d: expected %s (%s), got %s (%s)", hr, want.Type, want.Severity, got.Type, got.Severity)
+		}
+	}
+}
+
+func TestAnalyzerThresholds(t *testing.T) {
+	analyzer := ecg.NewAnalyzer(ecg.Thresholds{MaxHeartRate: 110, CriticalHighRate: 140, MinHeartRate: 50, MinRRInterval: 0.5, MaxRRInterval: 1.2})
+	analyzer.Patients = map[string]ecg.Thresholds{
+		"PATIENT-2": {MaxHeartRate: 95},
+	}
+
+	tests := []struct {
+		reading  ecg.ECGReading
+		expected string
+		severity string
+	}{
+		{patientReading("PATIENT-1", 105), ecg.ConditionNormal, "normal"},
+		{patientReading("PATIENT-1", 130), ecg.ConditionTachycardia, "warning"},
+		{patientReading("PATIENT-1", 150), ecg.ConditionTachycardia, "critical"},
+		{patientReading("PATIENT-1", 55), ecg.ConditionNormal, "normal"},
+		{patientReading("PATIENT-1", 48), ecg.ConditionBradycardia, "warning"},
+		// The patient's override replaces the ward's limit and keeps the rest.
+		{patientReading("PATIENT-2", 100), ecg.ConditionTachycardia, "warning"},
+		{patientReading("PATIENT-2", 55), ecg.ConditionNormal, "normal"},
+	}
+
+	for _, tt := range tests {
+		got := analyzer.Analyze(tt.reading)
+		if got.Type != tt.expected || got.Severity != tt.severity {
+			t.Errorf("%s HR=%d: expected %s (%s), got %s (%s)", tt.reading.PatientID, tt.reading.HeartRate, tt.expected, tt.severity, got.Type, got.Severity)
+		}
+	}
+
+	// Unset limits still follow the patient's demographics.
+	athlete := ecg.Demographics{Athlete: true}
+	if r := analyzer.ReferenceRange("PATIENT-1", athlete); r.MinHeartRate != 50 || r.CriticalLowRate != athlete.ReferenceRange().CriticalLowRate {
+		t.Errorf("Expected the ward's rate limit over the athlete's critical limit, got %+v", r)
+	}
+
+	// Children keep their age group's limits, unless overridden per patient.
+	neonate := ecg.Demographics{AgeGroup: ecg.AgeNeonate}
+	if r := analyzer.ReferenceRange("PATIENT-1", neonate); r != neonate.ReferenceRange() {
+		t.Errorf("Expected the neonatal reference range, got %+v", r)
+	}
+	reading := patientReading("PATIENT-1", 140)
+	reading.Demographics = &neonate
+	if got := analyzer.Analyze(reading); got.Type != ecg.ConditionNormal {
+		t.Errorf("Expected 140 BPM to be normal for a neonate, got %s (%s)", got.Type, got.Description)
+	}
+	if r := analyzer.ReferenceRange("PATIENT-2", neonate); r.MaxHeartRate != 95 {
+		t.Errorf("Expected the patient's override for a neonate, got max_heart_rate %d", r.MaxHeartRate)
+	}
+}
+
+func TestParseAnalyzer(t *testing.T) {
+	analyzer, err := ecg.ParseAnalyzer([]byte(`{
+		"name": "step-down",
+		"thresholds": {"max_heart_rate": 120, "critical_high_rate": 150, "min_rr_interval": 0.5},
+		"patients": {"PATIENT-3": {"min_fibrillation_rate": 280}}
+	}`))
+	if err != nil {
+		t.Fatalf("ParseAnalyzer failed: %v", err)
+	}
+	if analyzer.Name != "step-down" || analyzer.Thresholds.MaxHeartRate != 120 {
+		t.Errorf("Unexpected profile %+v", analyzer)
+	}
+	if got := analyzer.Analyze(patientReading("PATIENT-3", 290)); got.Type != ecg.ConditionVentricularFibrillation {
+		t.Errorf("Expected the patient's fibrillation limit to apply, got %s", got.Type)
+	}
+
+	for _, tt := range []struct {
+		data string
+		err  string
+	}{
+		{`{"thresholds": {"max_heart_rate": -1}}`, "must not be negative"},
+		{`{"thresholds": {"max_heart_rate": 50}}`, "must be below max_heart_rate"},
+		{`{"thresholds": {"critical_high_rate": 90}}`, "critical_high_rate"},
+		{`{"patients": {"PATIENT-1": {"min_rr_interval": 1.2}}}`, "patients[PATIENT-1]"},
+		{`{"thresholds": {"max_hr": 120}}`, "unknown field"},
+		// A regular rhythm within the rate limits would alarm on its RR
+		// interval.
+		{`{"thresholds": {"max_heart_rate": 110, "critical_high_rate": 140}}`, "min_rr_interval 0.60 must not be above 0.55"},
+		// An override that suits no age group.
+		{`{"patients": {"PATIENT-1": {"max_heart_rate": 150, "critical_high_rate": 140}}}`, "patients[PATIENT-1]"},
+	} {
+		if _, err := ecg.ParseAnalyzer([]byte(tt.data)); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.data, tt.err, err)
+		}
+	}
+}
+
+func TestLoadAnalyzer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ward.json")
+	if err := os.WriteFile(path, []byte(`{"thresholds": {"min_heart_rate": 40, "critical_low_rate": 30, "max_rr_interval": 1.5, "critical_max_rr_interval": 2}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	analyzer, err := ecg.LoadAnalyzer(path)
+	if err != nil {
+		t.Fatalf("LoadAnalyzer failed: %v", err)
+	}
+	if got := analyzer.Analyze(patientReading("", 45)); got.Type != ecg.ConditionNormal {
+		t.Errorf("Expected 45 BPM to be normal on the ward, got %s", got.Type)
+	}
+	if got := analyzer.Analyze(patientReading("", 35)); got.Type != ecg.ConditionBradycardia || got.Severity != "warning" {
+		t.Errorf("Expected a bradycardia warning at 35 BPM, got %s (%s)", got.Type, got.Severity)
+	}
+
+	// Ward limits are only checked against the adult range, and a patient's
+	// override against any age group.
+	for _, data := range []string{
+		`{"thresholds": {"min_heart_rate": 40, "critical_low_rate": 30, "max_rr_interval": 1.5}}`,
+		`{"patients": {"PATIENT-1": {"min_heart_rate": 120, "critical_high_rate": 230}}}`,
+	} {
+		if _, err := ecg.ParseAnalyzer([]byte(data)); err != nil {
+			t.Errorf("%s: unexpected error %v", data, err)
+		}
+	}
+
+	if _, err := ecg.LoadAnalyzer(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
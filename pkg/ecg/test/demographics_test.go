@@ -0,0 +1,82 @@
+package ecg_test
+
+import (
+	"testing"
+
+	"arhm/ecg-monitoring/pkg/ecg"
+)
+
+func TestDemographicsReferenceRange(t *testing.T) {
+	adult := ecg.Demographics{}.ReferenceRange()
+	if adult.MinHeartRate != ecg.MinNormalHeartRate || adult.MaxHeartRate != ecg.MaxNormalHeartRate {
+		t.Errorf("Expected the global thresholds for an adult, got %d-%d", adult.MinHeartRate, adult.MaxHeartRate)
+	}
+
+	neonate := ecg.Demographics{AgeGroup: ecg.AgeNeonate}.ReferenceRange()
+	if neonate.MinHeartRate <= adult.MinHeartRate || neonate.MaxHeartRate <= adult.MaxHeartRate || neonate.WideQRSDuration >= adult.WideQRSDuration {
+		t.Errorf("Expected faster rates and narrower complexes for a neonate, got %+v", neonate)
+	}
+
+	athlete := ecg.Demographics{Athlete: true}.ReferenceRange()
+	if athlete.MinHeartRate >= adult.MinHeartRate || athlete.MaxRRInterval <= adult.MaxRRInterval {
+		t.Errorf("Expected a lower normal range for an athlete, got %+v", athlete)
+	}
+
+	paced := ecg.Demographics{PacemakerRate: 70}.ReferenceRange()
+	if paced.MinHeartRate != 70 || paced.RestingHeartRate < 70 {
+		t.Errorf("Expected the pacemaker rate as the lower limit, got %+v", paced)
+	}
+}
+
+func TestAnalyzeReadingFor(t *testing.T) {
+	tests := []struct {
+		name         string
+		demographics ecg.Demographics
+		heartRate    int
+		want         string
+	}{
+		{"neonate at 140", ecg.Demographics{AgeGroup: ecg.AgeNeonate}, 140, ecg.ConditionNormal},
+		{"adult at 140", ecg.Demographics{}, 140, ecg.ConditionTachycardia},
+		{"neonate at 90", ecg.Demographics{AgeGroup: ecg.AgeNeonate}, 90, ecg.ConditionBradycardia},
+		{"child at 110", ecg.Demographics{AgeGroup: ecg.AgeChild}, 110, ecg.ConditionNormal},
+		{"athlete at 45", ecg.Demographics{AgeGroup: ecg.AgeAdult, Athlete: true}, 45, ecg.ConditionNormal},
+		{"adult at 45", ecg.Demographics{}, 45, ecg.ConditionBradycardia},
+		{"paced at 65", ecg.Demographics{PacemakerRate: 70}, 65, ecg.ConditionBradycardia},
+	}
+
+	for _, tt := range tests {
+		reading := ecg.ECGReading{HeartRate: tt.heartRate, RRInterval: 60.0 / float64(tt.heartRate)}
+		if got := ecg.AnalyzeReadingFor(reading, tt.demographics); got.Type != tt.want {
+			t.Errorf("%s: expected %s, got %s (%s)", tt.name, tt.want, got.Type, got.Description)
+		}
+	}
+}
+
+func TestAnalyzeReadingUsesReadingDemographics(t *testing.T) {
+	reading := ecg.ECGReading{HeartRate: 150, RRInterval: 0.4}
+	if got := ecg.AnalyzeReading(reading); got.Type != ecg.ConditionTachycardia || got.Severity != "critical" {
+		t.Errorf("Expected critical tachycardia for an adult, got %s (%s)", got.Type, got.Severity)
+	}
+
+	reading.Demographics = &ecg.Demographics{AgeGroup: ecg.AgeInfant}
+	if got := ecg.AnalyzeReading(reading); got.Type != ecg.ConditionNormal {
+		t.Errorf("Expected a normal rate for an infant, got %s (%s)", got.Type, got.Description)
+	}
+}
+
+func TestDemographicsValidate(t *testing.T) {
+	valid := ecg.Demographics{AgeGroup: ecg.AgeToddler, Sex: ecg.SexFemale, PacemakerRate: 80}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Expected %+v to be valid: %v", valid, err)
+	}
+
+	for _, invalid := range []ecg.Demographics{
+		{AgeGroup: "senior"},
+		{Sex: "x"},
+		{PacemakerRate: 10},
+	} {
+		if invalid.Validate() == nil {
+			t.Errorf("Expected a validation error for %+v", invalid)
+		}
+	}
+}
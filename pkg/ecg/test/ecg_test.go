@@ -68,3 +68,30 @@ func TestFormatAlert(t *testing.T) {
 		t.Errorf("Expected alert format: %s, got: %s", expected, alert)
 	}
 }
+
+func TestAnalyzeLethalRhythms(t *testing.T) {
+	testCase := func(name string, heartRate int, rrInterval float64, qrsDuration float64, expectedType string) {
+		t.Run(name, func(t *testing.T) {
+			condition := ecg.AnalyzeReading(ecg.ECGReading{
+				Timestamp:   time.Now(),
+				HeartRate:   heartRate,
+				RRInterval:  rrInterval,
+				QRSDuration: qrsDuration,
+			})
+
+			if condition.Type != expectedType {
+				t.Errorf("Expected condition type %s, got %s", expectedType, condition.Type)
+			}
+
+			if expectedType != ecg.ConditionTachycardia && condition.Severity != "critical" {
+				t.Errorf("Expected critical severity, got %s", condition.Severity)
+			}
+		})
+	}
+
+	testCase("Asystole", 0, 0, 0, ecg.ConditionAsystole)
+	testCase("Ventricular fibrillation", 350, 0.17, 0, ecg.ConditionVentricularFibrillation)
+	testCase("Ventricular tachycardia", 180, 0.33, 0.16, ecg.ConditionVentricularTachycardia)
+	testCase("Narrow complex tachycardia", 115, 0.52, 0.08, ecg.ConditionTachycardia)
+	testCase("Wide complex below VT rate", 110, 0.55, 0.16, ecg.ConditionTachycardia)
+}
@@ -0,0 +1,146 @@
+package ecg_test
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"arhm/ecg-monitoring/pkg/ecg"
+)
+
+func TestCheckReading(t *testing.T) {
+	valid := []ecg.ECGReading{
+		{HeartRate: 75, RRInterval: 0.8},
+		{HeartRate: 0, RRInterval: 0},
+		{HeartRate: 350, RRInterval: 0.2},
+		{HeartRate: 70, RRInterval: 0.4},
+	}
+	for _, reading := range valid {
+		if err := ecg.CheckReading(reading); err != nil {
+			t.Errorf("HR=%d RR=%.2f: unexpected fault %v", reading.HeartRate, reading.RRInterval, err)
+		}
+	}
+
+	invalid := []ecg.ECGReading{
+		{HeartRate: 400, RRInterval: 0.8},
+		{HeartRate: 0, RRInterval: 0.8},
+		{HeartRate: 600, RRInterval: 0.1},
+		{HeartRate: -1, RRInterval: 0.8},
+		{HeartRate: 75, RRInterval: math.NaN()},
+		{HeartRate: 75, RRInterval: -0.8},
+		{HeartRate: 75, RRInterval: 0},
+		{HeartRate: 75, RRInterval: 0.8, SignalQuality: ecg.SignalLeadOff},
+		{HeartRate: 75, RRInterval: 0.8, SignalQuality: ecg.SignalNoisy},
+	}
+	for _, reading := range invalid {
+		if err := ecg.CheckReading(reading); err == nil {
+			t.Errorf("HR=%d RR=%.2f: expected a fault", reading.HeartRate, reading.RRInterval)
+		}
+	}
+}
+
+func TestAnalyzeReadingRaisesTechnicalAlert(t *testing.T) {
+	condition := ecg.AnalyzeReading(ecg.ECGReading{HeartRate: 400, RRInterval: 0.8})
+	if condition.Type != ecg.ConditionSensorFault || condition.Severity != ecg.SeverityTechnical {
+		t.Errorf("Expected a technical alert instead of a clinical alarm, got %s (%s)", condition.Type, condition.Severity)
+	}
+}
+
+func TestFaultDetector(t *testing.T) {
+	start := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	at := func(seconds int, heartRate int, rr float64) ecg.ECGReading {
+		return ecg.ECGReading{Timestamp: start.Add(time.Duration(seconds) * time.Second), HeartRate: heartRate, RRInterval: rr}
+	}
+
+	tests := []struct {
+		name     string
+		readings []ecg.ECGReading
+		want     string // Fault expected on the last reading, empty for none
+	}{
+		{"steady", []ecg.ECGReading{at(0, 75, 0.8), at(1, 76, 0.79), at(2, 74, 0.81)}, ""},
+		{"backwards", []ecg.ECGReading{at(0, 75, 0.8), at(1, 76, 0.79), at(-59, 74, 0.81)}, "went back"},
+		{"duplicate", []ecg.ECGReading{at(0, 75, 0.8), at(1, 76, 0.79), at(1, 76, 0.79)}, "duplicate"},
+		{"missing", []ecg.ECGReading{at(0, 75, 0.8), at(1, 76, 0.79), at(5, 74, 0.81)}, "3 readings missing"},
+		{"stuck", []ecg.ECGReading{at(0, 75, 0.8), at(1, 75, 0.8), at(2, 75, 0.8), at(3, 75, 0.8), at(4, 75, 0.8)}, "stuck"},
+		{"asystole", []ecg.ECGReading{at(0, 0, 0), at(1, 0, 0), at(2, 0, 0), at(3, 0, 0), at(4, 0, 0)}, ""},
+	}
+
+	for _, tt := range tests {
+		detector := ecg.NewFaultDetector(0)
+		var err error
+		for i, reading := range tt.readings {
+			err = detector.Check(reading)
+			if i < len(tt.readings)-1 && err != nil {
+				t.Fatalf("%s: unexpected fault on reading %d: %v", tt.name, i, err)
+			}
+		}
+
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: unexpected fault %v", tt.name, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: expected a fault containing %q, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestFaultDetectorPacedRhythm(t *testing.T) {
+	start := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	detector := ecg.NewFaultDetector(time.Second)
+	paced := &ecg.Demographics{PacemakerRate: 60}
+
+	for i := 0; i < 10; i++ {
+		reading := ecg.ECGReading{Timestamp: start.Add(time.Duration(i) * time.Second), HeartRate: 60, RRInterval: 1, Demographics: paced}
+		if err := detector.Check(reading); err != nil {
+			t.Fatalf("Reading %d: a paced rhythm is not a stuck sensor: %v", i, err)
+		}
+	}
+}
+
+func TestFaultDetectorLeadOff(t *testing.T) {
+	start := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	detector := ecg.NewFaultDetector(time.Second)
+
+	for i := 0; i < 6; i++ {
+		reading := ecg.ECGReading{Timestamp: start.Add(time.Duration(i) * time.Second), HeartRate: 75 + i%2, RRInterval: 0.8}
+		leadOff := i >= 2 && i < 5
+		if leadOff {
+			reading.SignalQuality = ecg.SignalLeadOff
+		}
+
+		err := detector.Check(reading)
+		if leadOff != errors.Is(err, ecg.ErrLeadOff) {
+			t.Errorf("Reading %d: expected lead-off=%v, got %v", i, leadOff, err)
+		}
+		// The readings with a lead off still arrived, so none are missing.
+		if !leadOff && err != nil {
+			t.Errorf("Reading %d: unexpected fault %v", i, err)
+		}
+	}
+}
+
+func TestReadingJSONWithInvalidRR(t *testing.T) {
+	data, err := json.Marshal(ecg.ECGReading{HeartRate: 75, RRInterval: math.NaN()})
+	if err != nil {
+		t.Fatalf("Failed to marshal a reading with a NaN RR interval: %v", err)
+	}
+	if !strings.Contains(string(data), `"rr_interval":null`) {
+		t.Errorf("Expected a null RR interval, got %s", data)
+	}
+
+	var reading ecg.ECGReading
+	if err := json.Unmarshal(data, &reading); err != nil {
+		t.Fatalf("Failed to unmarshal: %v", err)
+	}
+	if !math.IsNaN(reading.RRInterval) || reading.HeartRate != 75 {
+		t.Errorf("Expected HR=75 with a NaN RR interval, got %+v", reading)
+	}
+
+	var missing ecg.ECGReading
+	if err := json.Unmarshal([]byte(`{"heart_rate": 75}`), &missing); err != nil || missing.RRInterval != 0 {
+		t.Errorf("Expected a missing RR interval to decode as 0, got %v (%v)", missing.RRInterval, err)
+	}
+}
@@ -0,0 +1,29 @@
+package ecg_test
+
+import (
+	"reflect"
+	"testing"
+
+	"arhm/ecg-monitoring/pkg/ecg"
+)
+
+func TestParseLeads(t *testing.T) {
+	leads, err := ecg.ParseLeads("II, avf,V1")
+	if err != nil {
+		t.Fatalf("Failed to parse leads: %v", err)
+	}
+	if want := []ecg.Lead{ecg.LeadII, ecg.LeadAVF, ecg.LeadV1}; !reflect.DeepEqual(leads, want) {
+		t.Errorf("Expected %v, got %v", want, leads)
+	}
+
+	all, err := ecg.ParseLeads("all")
+	if err != nil || len(all) != 12 {
+		t.Errorf("Expected all twelve leads, got %v (%v)", all, err)
+	}
+
+	for _, invalid := range []string{"V7", "", " , "} {
+		if _, err := ecg.ParseLeads(invalid); err == nil {
+			t.Errorf("Expected error for %q", invalid)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package ecg_test
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"arhm/ecg-monitoring/pkg/ecg"
+	"arhm/ecg-monitoring/pkg/simulation"
+)
+
+var qrsStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// syntheticECG is a noisy ECG with narrow R waves at the given times, a tall
+// T wave 250 ms after each and baseline wander, both of which the detector
+// must reject.
+func syntheticECG(sampleRate int, duration float64, peaks []float64) ecg.Samples {
+	rng := rand.New(rand.NewSource(1))
+	samples := make(ecg.Samples, int(duration*float64(sampleRate)))
+	for i := range samples {
+		t := float64(i) / float64(sampleRate)
+		v := 0.3*math.Sin(2*math.Pi*0.3*t) + 0.02*rng.NormFloat64()
+		for _, peak := range peaks {
+			v += 1.2 * math.Exp(-math.Pow((t-peak)/0.012, 2)/2)
+			v += 0.4 * math.Exp(-math.Pow((t-peak-0.25)/0.05, 2)/2)
+		}
+		samples[i] = v
+	}
+	return samples
+}
+
+// detectChunks feeds the samples to a detector one second at a time, as
+// readings would carry them.
+func detectChunks(t *testing.T, detector *ecg.QRSDetector, samples ecg.Samples) []ecg.ECGReading {
+	t.Helper()
+
+	rate := detector.SampleRate
+	var readings []ecg.ECGReading
+	for start := 0; start < len(samples); start += rate {
+		chunk := samples[start:min(start+rate, len(samples))]
+		reading, err := detector.Detect(ecg.ECGReading{
+			PatientID:  "PATIENT-1",
+			Timestamp:  qrsStart.Add(time.Duration(start+len(chunk)) * time.Second / time.Duration(rate)),
+			SampleRate: rate,
+			Samples:    chunk,
+		})
+		if err != nil {
+			t.Fatalf("Detect failed: %v", err)
+		}
+		readings = append(readings, reading)
+	}
+	return readings
+}
+
+func TestQRSDetectorFindsBeats(t *testing.T) {
+	// 72 BPM with a little variation.
+	var peaks []float64
+	for t := 0.4; t < 30; t += 0.83 + 0.04*math.Sin(t) {
+		peaks = append(peaks, t)
+	}
+
+	for _, rate := range []int{250, 500, 1000} {
+		detector, err := ecg.NewQRSDetector(rate)
+		if err != nil {
+			t.Fatalf("NewQRSDetector(%d) failed: %v", rate, err)
+		}
+		readings := detectChunks(t, detector, syntheticECG(rate, 30, peaks))
+
+		var beats []ecg.Beat
+		for _, reading := range readings {
+			beats = append(beats, reading.Beats...)
+		}
+
+		// Beats are reported after the learning period, from the second
+		// detected R peak on.
+		var expected []float64
+		for _, peak := range peaks {
+			if peak > 3 {
+				expected = append(expected, peak)
+			}
+		}
+		if len(beats) < len(expected) || len(beats) > len(expected)+1 {
+			t.Errorf("%d Hz: expected about %d beats, got %d", rate, len(expected), len(beats))
+			continue
+		}
+
+		beats = beats[len(beats)-len(expected):]
+		for i, beat := range beats {
+			offset := beat.Timestamp.Sub(qrsStart).Seconds() - expected[i]
+			if math.Abs(offset) > 0.01 {
+				t.Errorf("%d Hz: beat %d at %0.3f s, expected %0.3f s", rate, i, beat.Timestamp.Sub(qrsStart).Seconds(), expected[i])
+			}
+			if i > 0 && math.Abs(beat.RRInterval-(expected[i]-expected[i-1])) > 0.01 {
+				t.Errorf("%d Hz: beat %d RR %0.3f s, expected %0.3f s", rate, i, beat.RRInterval, expected[i]-expected[i-1])
+			}
+			if beat.PatientID != "PATIENT-1" {
+				t.Errorf("%d Hz: expected the reading's patient on beat %d, got %q", rate, i, beat.PatientID)
+			}
+		}
+
+		last := readings[len(readings)-1]
+		if last.HeartRate < 68 || last.HeartRate > 76 {
+			t.Errorf("%d Hz: expected about 72 BPM, got %d", rate, last.HeartRate)
+		}
+		if ecg.CheckReading(last) != nil {
+			t.Errorf("%d Hz: detected reading fails the sensor checks: %v", rate, ecg.CheckReading(last))
+		}
+	}
+}
+
+func TestQRSDetectorGap(t *testing.T) {
+	var peaks []float64
+	for t := 0.4; t < 20; t += 0.8 {
+		peaks = append(peaks, t)
+	}
+	const rate = 250
+	samples := syntheticECG(rate, 20, peaks)
+	detector, _ := ecg.NewQRSDetector(rate)
+
+	// The reading covering the tenth second is dropped.
+	var beats []ecg.Beat
+	for start := 0; start < len(samples); start += rate {
+		if start == 9*rate {
+			continue
+		}
+		reading, err := detector.Detect(ecg.ECGReading{
+			Timestamp:  qrsStart.Add(time.Duration(start+rate) * time.Second / rate),
+			SampleRate: rate,
+			Samples:    samples[start : start+rate],
+		})
+		if err != nil {
+			t.Fatalf("Detect failed: %v", err)
+		}
+		beats = append(beats, reading.Beats...)
+	}
+
+	after := 0
+	for _, beat := range beats {
+		at := beat.Timestamp.Sub(qrsStart).Seconds()
+		if math.Abs(beat.RRInterval-0.8) > 0.01 {
+			t.Errorf("Beat at %0.3f s: expected RR 0.8 s, got %0.3f s", at, beat.RRInterval)
+		}
+		if offset := math.Remainder(at-0.4, 0.8); math.Abs(offset) > 0.01 {
+			t.Errorf("Beat at %0.3f s is %0.3f s off the R peaks", at, offset)
+		}
+		if at > 10 {
+			after++
+		}
+	}
+	if after < 8 {
+		t.Errorf("Expected beats again after the detector restarted, got %d", after)
+	}
+}
+
+func TestQRSDetectorAsystole(t *testing.T) {
+	peaks := []float64{0.5, 1.3, 2.1, 2.9, 3.7, 4.5, 5.3, 6.1}
+	detector, _ := ecg.NewQRSDetector(500)
+	readings := detectChunks(t, detector, syntheticECG(500, 14, peaks))
+
+	if readings[6].HeartRate == 0 {
+		t.Errorf("Expected a heart rate while beating")
+	}
+	last := readings[len(readings)-1]
+	if last.HeartRate != 0 || last.RRInterval != 0 {
+		t.Errorf("Expected no heart rate after %v without beats, got HR=%d RR=%0.2f", ecg.AsystoleDelay, last.HeartRate, last.RRInterval)
+	}
+	if condition := ecg.AnalyzeReading(last); condition.Type != ecg.ConditionAsystole {
+		t.Errorf("Expected asystole, got %s", condition.Type)
+	}
+}
+
+func TestQRSDetectorLeadOff(t *testing.T) {
+	const rate = 500
+	patient := simulation.NewDefaultPatient()
+	patient.Rand = simulation.NewRandomSource(21)
+	patient.Artifacts = []simulation.ArtifactConfig{
+		{Type: simulation.ArtifactLeadOff, Start: simulation.Duration(10 * time.Second), Duration: simulation.Duration(6 * time.Second)},
+	}
+	generator, err := simulation.NewWaveformGenerator(patient, rate)
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+	samples, _ := generator.Generate(30 * time.Second)
+
+	// The lead is off for longer than AsystoleDelay.
+	detector, _ := ecg.NewQRSDetector(rate)
+	for second := 0; second < 30; second++ {
+		reading, err := detector.Detect(ecg.ECGReading{
+			Timestamp:  qrsStart.Add(time.Duration(second+1) * time.Second),
+			HeartRate:  80,
+			RRInterval: 0.75,
+			SampleRate: rate,
+			Samples:    samples[second*rate : (second+1)*rate],
+		})
+
+		leadOff := second >= 10 && second < 16
+		if leadOff != errors.Is(err, ecg.ErrSignalLoss) {
+			t.Errorf("Second %d: expected signal loss=%v, got %v", second, leadOff, err)
+		}
+		if condition := ecg.AnalyzeReading(reading); condition.Type == ecg.ConditionAsystole {
+			t.Errorf("Second %d: lead-off reported as asystole", second)
+		}
+		for _, beat := range reading.Beats {
+			if beat.RRInterval > 1.5 {
+				t.Errorf("Second %d: beat with RR %0.2f s across the lost signal", second, beat.RRInterval)
+			}
+		}
+		if second >= 20 && (reading.HeartRate < 65 || reading.HeartRate > 95) {
+			t.Errorf("Second %d: expected the rate of the signal after it returned, got HR=%d", second, reading.HeartRate)
+		}
+	}
+}
+
+func TestQRSDetectorErrors(t *testing.T) {
+	for _, rate := range []int{0, 50, 5000} {
+		if _, err := ecg.NewQRSDetector(rate); err == nil {
+			t.Errorf("Expected an error for %d Hz", rate)
+		}
+	}
+
+	detector, _ := ecg.NewQRSDetector(250)
+	reading := ecg.ECGReading{HeartRate: 70, RRInterval: 0.86}
+	if got, err := detector.Detect(reading); !errors.Is(err, ecg.ErrNoWaveform) || got.HeartRate != 70 {
+		t.Errorf("Expected ErrNoWaveform and the reading unchanged, got %v and HR=%d", err, got.HeartRate)
+	}
+
+	reading.SampleRate = 500
+	reading.Leads = map[ecg.Lead]ecg.Samples{ecg.LeadII: make(ecg.Samples, 500)}
+	if _, err := detector.Detect(reading); err == nil {
+		t.Error("Expected an error for a waveform at another sample rate")
+	}
+}
@@ -0,0 +1,235 @@
+package ecg_test
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"arhm/ecg-monitoring/pkg/ecg"
+)
+
+// analyzeRhythm feeds one reading per RR interval to a new RhythmAnalyzer and
+// returns the condition of each. Like summary readings, they report a steady
+// rate alongside the last interval.
+func analyzeRhythm(rr []float64, types []ecg.BeatType) []ecg.HeartCondition {
+	analyzer := ecg.NewRhythmAnalyzer(nil)
+	timestamp := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	conditions := make([]ecg.HeartCondition, len(rr))
+	for i := range rr {
+		timestamp = timestamp.Add(time.Duration(rr[i] * float64(time.Second)))
+		reading := ecg.ECGReading{
+			Timestamp:  timestamp,
+			HeartRate:  75,
+			RRInterval: rr[i],
+		}
+		if types != nil {
+			reading.BeatType = types[i]
+		}
+		conditions[i] = analyzer.Analyze(reading)
+	}
+	return conditions
+}
+
+// sinusRhythm is 75 BPM with a respiratory sinus arrhythmia over four beats.
+func sinusRhythm(n int) []float64 {
+	rr := make([]float64, n)
+	for i := range rr {
+		rr[i] = 0.8 + 0.06*math.Sin(2*math.Pi*float64(i)/4)
+	}
+	return rr
+}
+
+func countArrhythmia(conditions []ecg.HeartCondition) int {
+	count := 0
+	for _, condition := range conditions {
+		if condition.Type == ecg.ConditionArrhythmia {
+			count++
+		}
+	}
+	return count
+}
+
+func TestRhythmAnalyzerIgnoresIsolatedPause(t *testing.T) {
+	rr := sinusRhythm(60)
+	rr[30] = 1.6
+
+	// On its own the pause is a critical arrhythmia.
+	if condition := ecg.AnalyzeReading(ecg.ECGReading{HeartRate: 75, RRInterval: 1.6}); condition.Type != ecg.ConditionArrhythmia || condition.Severity != "critical" {
+		t.Fatalf("Expected the single reading to alarm, got %s (%s)", condition.Type, condition.Severity)
+	}
+
+	if count := countArrhythmia(analyzeRhythm(rr, nil)); count != 0 {
+		t.Errorf("Expected no arrhythmia alarms for sinus rhythm with one pause, got %d", count)
+	}
+}
+
+func TestRhythmAnalyzerIrregularRhythm(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	rr := make([]float64, 60)
+	for i := range rr {
+		rr[i] = 0.62 + 0.38*rng.Float64()
+	}
+
+	conditions := analyzeRhythm(rr, nil)
+	if count := countArrhythmia(conditions[:ecg.DefaultMinBeats-1]); count != 0 {
+		t.Errorf("Expected no decision before the window holds %d beats, got %d alarms", ecg.DefaultMinBeats, count)
+	}
+	if count := countArrhythmia(conditions[20:]); count < 35 {
+		t.Errorf("Expected an irregularly irregular rhythm to alarm, got %d of 40 alarms", count)
+	}
+
+	// The window slides past the irregular beats once the rhythm settles.
+	conditions = analyzeRhythm(append(rr, sinusRhythm(60)...), nil)
+	if count := countArrhythmia(conditions[len(conditions)-10:]); count != 0 {
+		t.Errorf("Expected the alarm to clear after the window passed, got %d alarms", count)
+	}
+}
+
+func TestRhythmAnalyzerEctopy(t *testing.T) {
+	tests := []struct {
+		name     string
+		ectopic  func(i int) bool
+		beatType ecg.BeatType
+		alarm    bool
+		severity string
+	}{
+		{"isolated PVCs", func(i int) bool { return i%12 == 6 }, ecg.BeatPVC, false, ""},
+		{"bigeminy", func(i int) bool { return i%2 == 1 }, ecg.BeatPVC, true, "warning"},
+		{"PAC trigeminy", func(i int) bool { return i%3 == 2 }, ecg.BeatPAC, true, "warning"},
+		{"ventricular run", func(i int) bool { return i >= 20 && i < 23 }, ecg.BeatPVC, true, "critical"},
+		{"untyped premature run", func(i int) bool { return i >= 20 && i < 23 }, "", true, "warning"},
+	}
+
+	for _, tt := range tests {
+		rr := make([]float64, 30)
+		types := make([]ecg.BeatType, len(rr))
+		for i := range rr {
+			rr[i], types[i] = 0.8, ecg.BeatNormal
+			if tt.beatType == "" {
+				types[i] = ""
+			}
+			if tt.ectopic(i) {
+				rr[i], types[i] = 0.55, tt.beatType
+			}
+		}
+
+		last := analyzeRhythm(rr, types)[len(rr)-1]
+		if alarm := last.Type == ecg.ConditionArrhythmia; alarm != tt.alarm {
+			t.Errorf("%s: expected alarm=%v, got %s (%s)", tt.name, tt.alarm, last.Type, last.Description)
+			continue
+		}
+		if tt.alarm && last.Severity != tt.severity {
+			t.Errorf("%s: expected %s severity, got %s (%s)", tt.name, tt.severity, last.Severity, last.Description)
+		}
+	}
+}
+
+// Premature beats with a compensatory pause, in readings whose rate follows
+// each interval as the simulator's do, keep the average rate and must not
+// raise rate alarms once the window has filled.
+func TestRhythmAnalyzerEctopyRate(t *testing.T) {
+	tests := []struct {
+		name    string
+		ectopic func(i int) bool
+	}{
+		{"bigeminy", func(i int) bool { return i%2 == 1 }},
+		{"trigeminy", func(i int) bool { return i%3 == 2 }},
+	}
+
+	for _, tt := range tests {
+		analyzer := ecg.NewRhythmAnalyzer(nil)
+		timestamp := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+		var last ecg.HeartCondition
+		for i := 0; i < 60; i++ {
+			rr, beatType := 0.8, ecg.BeatNormal
+			if tt.ectopic(i) {
+				rr, beatType = 0.48, ecg.BeatPVC
+			} else if i > 0 && tt.ectopic(i-1) {
+				rr = 1.12
+			}
+			timestamp = timestamp.Add(time.Duration(rr * float64(time.Second)))
+			reading := ecg.ECGReading{
+				Timestamp:  timestamp,
+				HeartRate:  int(math.Round(60 / rr)),
+				RRInterval: rr,
+				BeatType:   beatType,
+			}
+
+			last = analyzer.Analyze(reading)
+			if i >= ecg.DefaultRateBeats && (last.Type == ecg.ConditionTachycardia || last.Type == ecg.ConditionBradycardia) {
+				t.Errorf("%s: reading %d at %d BPM raised %s", tt.name, i, reading.HeartRate, last.Type)
+				break
+			}
+		}
+
+		if stats := analyzer.Stats(); stats.Ectopic == 0 || stats.HeartRate < 70 || stats.HeartRate > 80 {
+			t.Errorf("%s: expected the premature beats in the window at about 75 BPM, got %d ectopic at %d BPM", tt.name, stats.Ectopic, stats.HeartRate)
+		}
+		if last.Type != ecg.ConditionArrhythmia || last.Severity != "warning" {
+			t.Errorf("%s: expected an ectopy warning, got %s (%s)", tt.name, last.Type, last.Description)
+		}
+	}
+}
+
+func TestRhythmAnalyzerKeepsPointAlarms(t *testing.T) {
+	analyzer := ecg.NewRhythmAnalyzer(nil)
+
+	if condition := analyzer.Analyze(ecg.ECGReading{HeartRate: 140, RRInterval: 60.0 / 140}); condition.Type != ecg.ConditionTachycardia {
+		t.Errorf("Expected tachycardia from the first reading, got %s", condition.Type)
+	}
+	if condition := analyzer.Analyze(ecg.ECGReading{HeartRate: 400}); condition.Severity != ecg.SeverityTechnical {
+		t.Errorf("Expected a technical alert, got %s", condition.Type)
+	}
+	if condition := analyzer.Analyze(ecg.ECGReading{HeartRate: 0}); condition.Type != ecg.ConditionAsystole {
+		t.Errorf("Expected asystole, got %s", condition.Type)
+	}
+	if stats := analyzer.Stats(); stats.Beats != 1 {
+		t.Errorf("Expected only the tachycardic beat in the window, got %d beats", stats.Beats)
+	}
+}
+
+func TestRhythmAnalyzerRateChange(t *testing.T) {
+	tests := []struct {
+		name        string
+		fast, sinus int
+	}{
+		// The tachycardia alarms from the window and clears once the rate
+		// settles.
+		{"tachycardia then sinus", 130, 75},
+		// A fast sinus rhythm within the limits is not ectopy once the rate
+		// slows.
+		{"fast then slow sinus", 100, 62},
+	}
+
+	for _, tt := range tests {
+		analyzer := ecg.NewRhythmAnalyzer(nil)
+		timestamp := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		tachycardia := 0
+		var last ecg.HeartCondition
+		for i := 0; i < 80; i++ {
+			hr := tt.sinus
+			if i < 20 {
+				hr = tt.fast
+			}
+			timestamp = timestamp.Add(time.Minute / time.Duration(hr))
+			last = analyzer.Analyze(ecg.ECGReading{Timestamp: timestamp, HeartRate: hr, RRInterval: 60 / float64(hr)})
+			if last.Type == ecg.ConditionArrhythmia {
+				t.Errorf("%s: reading %d at %d BPM raised %q", tt.name, i, hr, last.Description)
+				break
+			}
+			if i < 20 && last.Type == ecg.ConditionTachycardia {
+				tachycardia++
+			}
+		}
+
+		if wantTachycardia := tt.fast > 100; (tachycardia == 20) != wantTachycardia {
+			t.Errorf("%s: expected tachycardia=%v, got %d of 20 alarms", tt.name, wantTachycardia, tachycardia)
+		}
+		if last.Type != ecg.ConditionNormal {
+			t.Errorf("%s: expected the rhythm to settle, got %s", tt.name, last.Type)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package replay
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"arhm/ecg-monitoring/pkg/ecg"
+	"arhm/ecg-monitoring/pkg/simulation"
+)
+
+// Record is one line of a recording: a reading and, when the recording is
+// annotated, the condition it was labelled with.
+type Record struct {
+	Reading   ecg.ECGReading
+	Condition simulation.Condition
+}
+
+// ndjsonLabel is decoded from each line alongside the reading, which has
+// its own JSON decoding.
+type ndjsonLabel struct {
+	Condition string `json:"condition,omitempty"`
+}
+
+// Load reads a recording, choosing the format from the file extension: .csv,
+// or .ndjson / .jsonl for newline-delimited JSON.
+func Load(path string) ([]Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []Record
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		records, err = ParseCSV(file)
+	case ".ndjson", ".jsonl":
+		records, err = ParseNDJSON(file)
+	default:
+		return nil, fmt.Errorf("recording %s: unsupported format (expected .csv, .ndjson or .jsonl)", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("recording %s: %w", path, err)
+	}
+
+	return records, nil
+}
+
+// ParseCSV reads a recording with a header row. The timestamp (RFC 3339),
+// heart_rate and rr_interval columns are required; patient_id, beat_type,
+// qrs_duration and condition are optional. Waveforms are only supported in
+// NDJSON recordings.
+func ParseCSV(r io.Reader) ([]Record, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, required := range []string{"timestamp", "heart_rate", "rr_interval"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing %s column", required)
+		}
+	}
+
+	var records []Record
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(row) {
+				return row[i]
+			}
+			return ""
+		}
+
+		record, err := csvRecord(field)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		records = append(records, record)
+	}
+
+	return records, checkOrder(records)
+}
+
+func csvRecord(field func(string) string) (Record, error) {
+	var record Record
+	var err error
+
+	record.Reading.Timestamp, err = time.Parse(time.RFC3339Nano, field("timestamp"))
+	if err != nil {
+		return record, fmt.Errorf("timestamp: %w", err)
+	}
+	record.Reading.HeartRate, err = strconv.Atoi(field("heart_rate"))
+	if err != nil {
+		return record, fmt.Errorf("heart_rate: %w", err)
+	}
+	record.Reading.RRInterval, err = strconv.ParseFloat(field("rr_interval"), 64)
+	if err != nil {
+		return record, fmt.Errorf("rr_interval: %w", err)
+	}
+	if qrs := field("qrs_duration"); qrs != "" {
+		record.Reading.QRSDuration, err = strconv.ParseFloat(qrs, 64)
+		if err != nil {
+			return record, fmt.Errorf("qrs_duration: %w", err)
+		}
+	}
+
+	record.Reading.PatientID = field("patient_id")
+	record.Reading.BeatType = ecg.BeatType(field("beat_type"))
+
+	if condition := field("condition"); condition != "" {
+		record.Condition, err = simulation.ParseCondition(condition)
+		if err != nil {
+			return record, err
+		}
+	}
+
+	return record, nil
+}
+
+// ParseNDJSON reads one JSON reading per line, in the format the server
+// streams, with an optional condition field. Blank lines are skipped.
+func ParseNDJSON(r io.Reader) ([]Record, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	var records []Record
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var record Record
+		var label ndjsonLabel
+		if err := json.Unmarshal([]byte(text), &record.Reading); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := json.Unmarshal([]byte(text), &label); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if record.Reading.Timestamp.IsZero() {
+			return nil, fmt.Errorf("line %d: timestamp is required", line)
+		}
+
+		if label.Condition != "" {
+			condition, err := simulation.ParseCondition(label.Condition)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			record.Condition = condition
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return records, checkOrder(records)
+}
+
+func checkOrder(records []Record) error {
+	if len(records) == 0 {
+		return errors.New("recording is empty")
+	}
+
+	last := make(map[string]time.Time)
+	for i, record := range records {
+		id := record.Reading.PatientID
+		if previous, ok := last[id]; ok && record.Reading.Timestamp.Before(previous) {
+			return fmt.Errorf("record %d: timestamps must not go backwards", i+1)
+		}
+		last[id] = record.Reading.Timestamp
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package replay
+
+import (
+	"sync"
+	"time"
+
+	"arhm/ecg-monitoring/pkg/ecg"
+	"arhm/ecg-monitoring/pkg/simulation"
+)
+
+// DefaultPatientID is used for records that do not name a patient.
+const DefaultPatientID = "REPLAY"
+
+// Source plays back one patient's recorded readings, spaced by the gaps between
+// their timestamps divided by Speed. Readings are re-stamped with the clock's
+// time when they are sent unless KeepTimestamps is set.
+type Source struct {
+	ID             string
+	Records        []Record
+	Speed          float64 // 10 plays ten times faster; zero or 1 plays in real time
+	Loop           bool
+	KeepTimestamps bool
+	Clock          simulation.Clock // Nil uses the real clock
+}
+
+// Sources splits a recording into one source per patient, in order of first
+// appearance.
+func Sources(records []Record, speed float64, loop bool) []*Source {
+	var sources []*Source
+	byID := make(map[string]*Source)
+
+	for _, record := range records {
+		id := record.Reading.PatientID
+		if id == "" {
+			id = DefaultPatientID
+		}
+
+		source, ok := byID[id]
+		if !ok {
+			source = &Source{ID: id, Speed: speed, Loop: loop}
+			byID[id] = source
+			sources = append(sources, source)
+		}
+		source.Records = append(source.Records, record)
+	}
+
+	return sources
+}
+
+func (s *Source) PatientID() string {
+	return s.ID
+}
+
+func (s *Source) Run(callback func(reading ecg.ECGReading, condition simulation.Condition)) func() {
+	done := make(chan struct{})
+	var once sync.Once
+
+	go s.play(callback, done)
+
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+func (s *Source) clock() simulation.Clock {
+	if s.Clock == nil {
+		return simulation.RealClock{}
+	}
+	return s.Clock
+}
+
+// play schedules each reading relative to the previous one's scheduled time,
+// so pacing does not drift and batch clocks still produce spaced timestamps.
+func (s *Source) play(callback func(reading ecg.ECGReading, condition simulation.Condition), done chan struct{}) {
+	if len(s.Records) == 0 {
+		return
+	}
+
+	clock := s.clock()
+	at := clock.Now()
+
+	for {
+		for i, record := range s.Records {
+			if i > 0 {
+				at = at.Add(s.delay(s.Records[i-1], record))
+			} else {
+				at = at.Add(s.firstDelay())
+			}
+
+			select {
+			case <-done:
+				return
+			case <-clock.After(at.Sub(clock.Now())):
+			}
+
+			reading := record.Reading
+			reading.PatientID = s.ID
+			if !s.KeepTimestamps {
+				reading.Timestamp = at
+			}
+			callback(reading, record.Condition)
+		}
+
+		if !s.Loop {
+			return
+		}
+	}
+}
+
+func (s *Source) delay(previous, next Record) time.Duration {
+	gap := next.Reading.Timestamp.Sub(previous.Reading.Timestamp)
+	if s.Speed > 0 {
+		gap = time.Duration(float64(gap) / s.Speed)
+	}
+	return gap
+}
+
+// firstDelay spaces the first reading of each pass like the one before it, so
+// that looping does not send two readings back to back.
+func (s *Source) firstDelay() time.Duration {
+	if len(s.Records) < 2 {
+		return 0
+	}
+	return s.delay(s.Records[0], s.Records[1])
+}
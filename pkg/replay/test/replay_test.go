@@ -0,0 +1,222 @@
+package replay_test
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"arhm/ecg-monitoring/pkg/ecg"
+	"arhm/ecg-monitoring/pkg/replay"
+	"arhm/ecg-monitoring/pkg/simulation"
+)
+
+const testCSV = `timestamp,patient_id,heart_rate,rr_interval,condition
+2024-05-01T09:00:00Z,BED-1,72,0.833,normal
+2024-05-01T09:00:00.5Z,BED-2,110,0.545,tachycardia
+2024-05-01T09:00:01Z,BED-1,74,0.811,
+2024-05-01T09:00:02Z,BED-1,130,0.462,tachycardia
+`
+
+const testNDJSON = `{"patient_id":"BED-1","timestamp":"2024-05-01T09:00:00Z","heart_rate":72,"rr_interval":0.833,"sample_rate":250,"samples":[0.1,null,0.3]}
+
+{"timestamp":"2024-05-01T09:00:01Z","heart_rate":160,"rr_interval":0.375,"qrs_duration":0.16,"condition":"ventricular_tachycardia"}
+`
+
+func TestParseCSV(t *testing.T) {
+	records, err := replay.ParseCSV(strings.NewReader(testCSV))
+	if err != nil {
+		t.Fatalf("Failed to parse CSV: %v", err)
+	}
+
+	if len(records) != 4 {
+		t.Fatalf("Expected 4 records, got %d", len(records))
+	}
+	if records[0].Reading.HeartRate != 72 || records[0].Reading.RRInterval != 0.833 {
+		t.Errorf("Unexpected first reading: %+v", records[0].Reading)
+	}
+	if records[1].Condition != simulation.ConditionTachycardia {
+		t.Errorf("Expected tachycardia label, got %q", records[1].Condition)
+	}
+	if records[2].Condition != "" {
+		t.Errorf("Expected empty label, got %q", records[2].Condition)
+	}
+}
+
+func TestParseCSVErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"missing column", "timestamp,heart_rate\n2024-05-01T09:00:00Z,70\n", "rr_interval"},
+		{"bad number", "timestamp,heart_rate,rr_interval\n2024-05-01T09:00:00Z,fast,0.8\n", "line 2"},
+		{"bad condition", "timestamp,heart_rate,rr_interval,condition\n2024-05-01T09:00:00Z,70,0.8,sleepy\n", "unknown condition"},
+		{"backwards", "timestamp,heart_rate,rr_interval\n2024-05-01T09:00:01Z,70,0.8\n2024-05-01T09:00:00Z,70,0.8\n", "backwards"},
+		{"empty", "timestamp,heart_rate,rr_interval\n", "empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := replay.ParseCSV(strings.NewReader(tt.input))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestParseNDJSON(t *testing.T) {
+	records, err := replay.ParseNDJSON(strings.NewReader(testNDJSON))
+	if err != nil {
+		t.Fatalf("Failed to parse NDJSON: %v", err)
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+
+	first := records[0].Reading
+	if first.SampleRate != 250 || len(first.Samples) != 3 {
+		t.Fatalf("Expected 3 samples at 250 Hz, got %d at %d", len(first.Samples), first.SampleRate)
+	}
+	if !math.IsNaN(first.Samples[1]) {
+		t.Errorf("Expected null sample to be NaN, got %g", first.Samples[1])
+	}
+
+	if records[1].Condition != simulation.ConditionVentricularTachycardia {
+		t.Errorf("Expected ventricular_tachycardia label, got %q", records[1].Condition)
+	}
+	if records[1].Reading.QRSDuration != 0.16 {
+		t.Errorf("Expected QRS duration 0.16, got %g", records[1].Reading.QRSDuration)
+	}
+}
+
+func TestLoadFormat(t *testing.T) {
+	dir := t.TempDir()
+
+	for name, content := range map[string]string{"rec.csv": testCSV, "rec.ndjson": testNDJSON} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write recording: %v", err)
+		}
+		if _, err := replay.Load(path); err != nil {
+			t.Errorf("Failed to load %s: %v", name, err)
+		}
+	}
+
+	if _, err := replay.Load(filepath.Join(dir, "rec.txt")); err == nil {
+		t.Error("Expected error for missing file")
+	}
+}
+
+func TestSourcesByPatient(t *testing.T) {
+	records, err := replay.ParseNDJSON(strings.NewReader(testNDJSON))
+	if err != nil {
+		t.Fatalf("Failed to parse NDJSON: %v", err)
+	}
+
+	sources := replay.Sources(records, 1, false)
+	if len(sources) != 2 {
+		t.Fatalf("Expected 2 sources, got %d", len(sources))
+	}
+	if sources[0].PatientID() != "BED-1" || sources[1].PatientID() != replay.DefaultPatientID {
+		t.Errorf("Unexpected source IDs %q, %q", sources[0].PatientID(), sources[1].PatientID())
+	}
+}
+
+func TestSourceRun(t *testing.T) {
+	records, err := replay.ParseCSV(strings.NewReader(testCSV))
+	if err != nil {
+		t.Fatalf("Failed to parse CSV: %v", err)
+	}
+
+	// BED-1 spans two seconds; at 20x that is 100ms per pass.
+	source := replay.Sources(records, 20, true)[0]
+
+	type delivery struct {
+		reading   ecg.ECGReading
+		condition simulation.Condition
+		at        time.Time
+	}
+	deliveries := make(chan delivery, 16)
+
+	start := time.Now()
+	stop := source.Run(func(reading ecg.ECGReading, condition simulation.Condition) {
+		deliveries <- delivery{reading, condition, time.Now()}
+	})
+
+	var got []delivery
+	for len(got) < 5 {
+		select {
+		case d := <-deliveries:
+			got = append(got, d)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out after %d readings", len(got))
+		}
+	}
+	stop()
+	stop()
+
+	wantRates := []int{72, 74, 130, 72, 74}
+	for i, d := range got {
+		if d.reading.HeartRate != wantRates[i] {
+			t.Errorf("Reading %d: expected HR %d, got %d", i, wantRates[i], d.reading.HeartRate)
+		}
+		if d.reading.PatientID != "BED-1" {
+			t.Errorf("Reading %d: expected BED-1, got %q", i, d.reading.PatientID)
+		}
+		if d.reading.Timestamp.Before(start) {
+			t.Errorf("Reading %d: expected timestamp at send time, got %v", i, d.reading.Timestamp)
+		}
+	}
+	if got[2].condition != simulation.ConditionTachycardia {
+		t.Errorf("Expected tachycardia label on third reading, got %q", got[2].condition)
+	}
+
+	// The last reading is 1s after the one before it, so 50ms at 20x.
+	if gap := got[2].at.Sub(got[1].at); gap < 30*time.Millisecond {
+		t.Errorf("Expected readings to be paced, gap was %v", gap)
+	}
+
+	// A reading already being delivered may still arrive; nothing after it.
+	time.Sleep(100 * time.Millisecond)
+	for len(deliveries) > 0 {
+		<-deliveries
+	}
+	time.Sleep(200 * time.Millisecond)
+	if len(deliveries) > 0 {
+		t.Error("Expected no readings after stop")
+	}
+}
+
+func TestSourceBatchClock(t *testing.T) {
+	records, err := replay.ParseCSV(strings.NewReader(testCSV))
+	if err != nil {
+		t.Fatalf("Failed to parse CSV: %v", err)
+	}
+
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	source := replay.Sources(records, 1, false)[0]
+	source.Clock = simulation.NewBatchClock(start)
+
+	readings := make(chan ecg.ECGReading)
+	stop := source.Run(func(reading ecg.ECGReading, condition simulation.Condition) {
+		readings <- reading
+	})
+	defer stop()
+
+	// The first reading is spaced like the second; the rest follow the recording.
+	for i, offset := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second} {
+		select {
+		case reading := <-readings:
+			if want := start.Add(offset); !reading.Timestamp.Equal(want) {
+				t.Errorf("Reading %d: expected %v, got %v", i, want, reading.Timestamp)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for reading %d", i)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"arhm/ecg-monitoring/pkg/simulation"
+)
+
+const (
+	ActionForceCondition = "force_condition"
+	ActionClearCondition = "clear_condition"
+	ActionSetParameters  = "set_parameters"
+	ActionPause          = "pause"
+	ActionResume         = "resume"
+	ActionInjectEvent    = "inject_event"
+)
+
+var ErrUnknownPatient = errors.New("unknown patient")
+
+// ControlMessage is a command for one simulated patient. Clients send it as a
+// JSON WebSocket message; the HTTP control endpoints build it from the request.
+type ControlMessage struct {
+	PatientID  string                        `json:"patient_id"`
+	Action     string                        `json:"action"`
+	Condition  simulation.Condition          `json:"condition,omitempty"`
+	Parameters *simulation.PatientParameters `json:"parameters,omitempty"`
+	Event      *simulation.Event             `json:"event,omitempty"`
+}
+
+// ControlReply answers a ControlMessage received on a WebSocket stream. Its
+// type tells it apart from the readings sent on the same connection.
+type ControlReply struct {
+	Type      string                       `json:"type"`
+	PatientID string                       `json:"patient_id,omitempty"`
+	Action    string                       `json:"action,omitempty"`
+	Status    *simulation.ControllerStatus `json:"status,omitempty"`
+	Error     string                       `json:"error,omitempty"`
+}
+
+const ControlReplyType = "control_reply"
+
+// ApplyControl carries out the message on the patient's live controller and
+// returns the patient's resulting status.
+func ApplyControl(roster *simulation.Roster, msg ControlMessage) (simulation.ControllerStatus, error) {
+	controller, ok := roster.Get(msg.PatientID)
+	if !ok {
+		return simulation.ControllerStatus{}, fmt.Errorf("%w %q", ErrUnknownPatient, msg.PatientID)
+	}
+
+	var err error
+	switch msg.Action {
+	case ActionForceCondition:
+		err = controller.ForceCondition(msg.Condition)
+	case ActionClearCondition:
+		controller.ClearCondition()
+	case ActionSetParameters:
+		if msg.Parameters == nil {
+			err = errors.New("parameters are required")
+		} else {
+			err = controller.SetParameters(*msg.Parameters)
+		}
+	case ActionPause:
+		controller.Pause()
+	case ActionResume:
+		controller.Resume()
+	case ActionInjectEvent:
+		if msg.Event == nil {
+			err = errors.New("event is required")
+		} else {
+			err = controller.InjectEvent(*msg.Event)
+		}
+	default:
+		err = fmt.Errorf("unknown action %q", msg.Action)
+	}
+	if err != nil {
+		return simulation.ControllerStatus{}, err
+	}
+
+	return controller.Status(), nil
+}
+
+// ControlHandler serves the HTTP control API for a roster of simulated
+// patients.
+type ControlHandler struct {
+	Loggers *Loggers
+	Roster  *simulation.Roster
+}
+
+func NewControlHandler(loggers *Loggers, roster *simulation.Roster) *ControlHandler {
+	return &ControlHandler{Loggers: loggers, Roster: roster}
+}
+
+func (h *ControlHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /patients", h.list)
+	mux.HandleFunc("GET /patients/{patientID}", h.status)
+	mux.HandleFunc("PUT /patients/{patientID}/condition", h.control(ActionForceCondition))
+	mux.HandleFunc("DELETE /patients/{patientID}/condition", h.control(ActionClearCondition))
+	mux.HandleFunc("PATCH /patients/{patientID}/parameters", h.control(ActionSetParameters))
+	mux.HandleFunc("POST /patients/{patientID}/pause", h.control(ActionPause))
+	mux.HandleFunc("POST /patients/{patientID}/resume", h.control(ActionResume))
+	mux.HandleFunc("POST /patients/{patientID}/events", h.control(ActionInjectEvent))
+}
+
+func (h *ControlHandler) list(w http.ResponseWriter, r *http.Request) {
+	var statuses []simulation.ControllerStatus
+	for _, controller := range h.Roster.Controllers() {
+		statuses = append(statuses, controller.Status())
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func (h *ControlHandler) status(w http.ResponseWriter, r *http.Request) {
+	controller, ok := h.Roster.Get(r.PathValue("patientID"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%w %q", ErrUnknownPatient, r.PathValue("patientID")))
+		return
+	}
+	writeJSON(w, http.StatusOK, controller.Status())
+}
+
+// control handles an endpoint for one action. The request body, when the
+// action takes one, is the condition, parameters or event on its own.
+func (h *ControlHandler) control(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		msg := ControlMessage{PatientID: r.PathValue("patientID"), Action: action}
+
+		var body any
+		switch action {
+		case ActionForceCondition:
+			body = &struct {
+				Condition *simulation.Condition `json:"condition"`
+			}{&msg.Condition}
+		case ActionSetParameters:
+			msg.Parameters = &simulation.PatientParameters{}
+			body = msg.Parameters
+		case ActionInjectEvent:
+			msg.Event = &simulation.Event{}
+			body = msg.Event
+		}
+
+		if body != nil {
+			decoder := json.NewDecoder(r.Body)
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(body); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid JSON: %w", err))
+				return
+			}
+		}
+
+		status, err := h.apply(msg)
+		switch {
+		case errors.Is(err, ErrUnknownPatient):
+			writeError(w, http.StatusNotFound, err)
+		case err != nil:
+			writeError(w, http.StatusBadRequest, err)
+		default:
+			writeJSON(w, http.StatusOK, status)
+		}
+	}
+}
+
+func (h *ControlHandler) apply(msg ControlMessage) (simulation.ControllerStatus, error) {
+	status, err := ApplyControl(h.Roster, msg)
+	if err != nil {
+		h.Loggers.General.Printf("[%s] Control %s rejected: %v", msg.PatientID, msg.Action, err)
+		return status, err
+	}
+
+	h.Loggers.General.Printf("[%s] Control %s applied: condition=%s paused=%t", msg.PatientID, msg.Action, status.Condition, status.Paused)
+	return status, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
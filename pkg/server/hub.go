@@ -0,0 +1,251 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+
+	"arhm/ecg-monitoring/pkg/ecg"
+	"arhm/ecg-monitoring/pkg/simulation"
+)
+
+// SlowConsumerPolicy decides what the hub does when a subscriber's queue is
+// full.
+type SlowConsumerPolicy string
+
+const (
+	DropOldest SlowConsumerPolicy = "drop_oldest" // Discard the oldest queued reading
+	Disconnect SlowConsumerPolicy = "disconnect"  // Close the subscription
+)
+
+const DefaultQueueSize = 64
+
+func ParseSlowConsumerPolicy(s string) (SlowConsumerPolicy, error) {
+	switch policy := SlowConsumerPolicy(s); policy {
+	case DropOldest, Disconnect:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown slow consumer policy %q (expected %s or %s)", s, DropOldest, Disconnect)
+	}
+}
+
+// Message is a reading fanned out by the hub. Start marks the first reading
+// since the source was started, after a time without subscribers.
+type Message struct {
+	Reading   ecg.ECGReading
+	Condition simulation.Condition
+	Start     bool
+}
+
+// Hub runs each source once, while it has subscribers, and fans its readings
+// out to all of them, so that every client watching a patient sees the same
+// readings. Each subscription has its own queue of QueueSize readings, and
+// Policy decides what happens when a client does not keep up.
+type Hub struct {
+	QueueSize int
+	Policy    SlowConsumerPolicy
+
+	// Wait for room in every subscriber's queue instead of applying Policy,
+	// so that sources paced by a BatchClock run as fast as their slowest
+	// client reads rather than as fast as they can.
+	Blocking bool
+
+	// Called for every reading before it is fanned out, with the hub locked,
+	// so calls never overlap.
+	Observe func(msg Message)
+
+	mu      sync.Mutex
+	streams map[string]*hubStream
+}
+
+type hubStream struct {
+	source      ReadingSource
+	subscribers map[*Subscription]struct{}
+	stop        func()
+
+	// Incremented on every start, so that a reading delivered by a stopped
+	// run is not mistaken for one of the current run.
+	run     int
+	started bool
+}
+
+// Subscription receives the readings of one patient on C, which is closed
+// when the subscription is closed, whether by Close or by the hub
+// disconnecting a slow consumer.
+type Subscription struct {
+	C <-chan Message
+
+	c            chan Message
+	hub          *Hub
+	stream       *hubStream
+	closed       bool
+	disconnected bool
+	dropped      int
+
+	// For blocking sends, which wait without the hub locked: done is closed
+	// first to release a waiting send, and sendMu keeps c open until it has
+	// returned.
+	done   chan struct{}
+	sendMu sync.Mutex
+}
+
+func NewHub(sources ...ReadingSource) *Hub {
+	hub := &Hub{
+		QueueSize: DefaultQueueSize,
+		Policy:    DropOldest,
+		streams:   make(map[string]*hubStream),
+	}
+	for _, source := range sources {
+		hub.streams[source.PatientID()] = &hubStream{
+			source:      source,
+			subscribers: make(map[*Subscription]struct{}),
+		}
+	}
+	return hub
+}
+
+// Subscribe starts receiving the patient's readings, starting its source if
+// nobody else is watching.
+func (h *Hub) Subscribe(patientID string) (*Subscription, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	stream, ok := h.streams[patientID]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownPatient, patientID)
+	}
+
+	queueSize := h.QueueSize
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+	c := make(chan Message, queueSize)
+	sub := &Subscription{C: c, c: c, hub: h, stream: stream, done: make(chan struct{})}
+	stream.subscribers[sub] = struct{}{}
+
+	if stream.stop == nil {
+		stream.run++
+		stream.started = true
+		run := stream.run
+		stream.stop = stream.source.Run(func(reading ecg.ECGReading, condition simulation.Condition) {
+			h.broadcast(stream, run, Message{Reading: reading, Condition: condition})
+		})
+	}
+
+	return sub, nil
+}
+
+func (h *Hub) broadcast(stream *hubStream, run int, msg Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if stream.run != run || stream.stop == nil {
+		return
+	}
+
+	msg.Start = stream.started
+	stream.started = false
+	if h.Observe != nil {
+		h.Observe(msg)
+	}
+
+	if h.Blocking {
+		subscribers := make([]*Subscription, 0, len(stream.subscribers))
+		for sub := range stream.subscribers {
+			subscribers = append(subscribers, sub)
+		}
+
+		h.mu.Unlock()
+		for _, sub := range subscribers {
+			sub.send(msg)
+		}
+		h.mu.Lock()
+		return
+	}
+
+	for sub := range stream.subscribers {
+		select {
+		case sub.c <- msg:
+			continue
+		default:
+		}
+
+		if h.Policy == Disconnect {
+			sub.disconnected = true
+			h.remove(sub)
+			continue
+		}
+
+		select {
+		case <-sub.c:
+			sub.dropped++
+		default:
+		}
+		select {
+		case sub.c <- msg:
+		default:
+			sub.dropped++
+		}
+	}
+}
+
+// remove closes the subscription and stops its source once nobody is
+// watching. The hub must be locked.
+func (h *Hub) remove(sub *Subscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.done)
+	sub.sendMu.Lock()
+	close(sub.c)
+	sub.sendMu.Unlock()
+
+	stream := sub.stream
+	delete(stream.subscribers, sub)
+	if len(stream.subscribers) == 0 && stream.stop != nil {
+		stream.stop()
+		stream.stop = nil
+	}
+}
+
+// send waits until the message is queued or the subscription is closed.
+func (s *Subscription) send(msg Message) {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	// c is closed after done, so it is still open unless done is.
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	select {
+	case <-s.done:
+	case s.c <- msg:
+	}
+}
+
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	s.hub.remove(s)
+}
+
+// Disconnected reports whether the hub closed the subscription because it
+// fell behind.
+func (s *Subscription) Disconnected() bool {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	return s.disconnected
+}
+
+// Dropped is the number of readings discarded because the subscription fell
+// behind.
+func (s *Subscription) Dropped() int {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	return s.dropped
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"time"
+
+	"arhm/ecg-monitoring/pkg/ecg"
+	"arhm/ecg-monitoring/pkg/simulation"
+)
+
+// ReadingSource produces the readings for one patient. Run delivers readings
+// to callback until the returned stop function is called. The condition is the
+// source's own label for the reading, or empty when it has none.
+type ReadingSource interface {
+	PatientID() string
+	Run(callback func(reading ecg.ECGReading, condition simulation.Condition)) (stop func())
+}
+
+type SimulatorSource struct {
+	Controller *simulation.Controller
+	Interval   time.Duration
+	patientID  string
+}
+
+func NewSimulatorSource(controller *simulation.Controller, interval time.Duration) *SimulatorSource {
+	return &SimulatorSource{
+		Controller: controller,
+		Interval:   interval,
+		patientID:  controller.Patient.ID,
+	}
+}
+
+func SimulatorSources(roster *simulation.Roster, interval time.Duration) []ReadingSource {
+	var sources []ReadingSource
+	for _, controller := range roster.Controllers() {
+		sources = append(sources, NewSimulatorSource(controller, interval))
+	}
+	return sources
+}
+
+func (s *SimulatorSource) PatientID() string {
+	return s.patientID
+}
+
+func (s *SimulatorSource) Run(callback func(reading ecg.ECGReading, condition simulation.Condition)) func() {
+	ticker := s.Controller.RunWithCallback(s.Interval, callback)
+	return ticker.Stop
+}
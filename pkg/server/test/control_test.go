@@ -0,0 +1,181 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"arhm/ecg-monitoring/pkg/server"
+	"arhm/ecg-monitoring/pkg/simulation"
+
+	"github.com/gorilla/websocket"
+)
+
+func newControlServer(t *testing.T) (*httptest.Server, *simulation.Roster) {
+	t.Helper()
+
+	tempDir := t.TempDir()
+	loggers, err := server.SetupLoggers(tempDir+"/test.log", tempDir+"/alerts.log")
+	if err != nil {
+		t.Fatalf("Failed to setup test loggers: %v", err)
+	}
+	t.Cleanup(func() { loggers.Close() })
+
+	roster := simulation.NewRoster(2)
+	handler := server.NewRosterECGHandler(loggers, roster)
+
+	mux := http.NewServeMux()
+	mux.Handle("/ecg/{patientID}", handler)
+	handler.Control.Register(mux)
+
+	testServer := httptest.NewServer(mux)
+	t.Cleanup(testServer.Close)
+	return testServer, roster
+}
+
+func doRequest(t *testing.T, method, url, body string) (int, simulation.ControllerStatus) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+
+	var status simulation.ControllerStatus
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			t.Fatalf("Failed to decode status: %v", err)
+		}
+	}
+	return resp.StatusCode, status
+}
+
+func TestControlEndpoints(t *testing.T) {
+	testServer, roster := newControlServer(t)
+	base := testServer.URL + "/patients/PATIENT-2"
+
+	code, status := doRequest(t, http.MethodPut, base+"/condition", `{"condition": "ventricular_fibrillation"}`)
+	if code != http.StatusOK || status.Forced != simulation.ConditionVentricularFibrillation {
+		t.Errorf("Expected forced V-FIB, got %d %+v", code, status)
+	}
+
+	code, status = doRequest(t, http.MethodPatch, base+"/parameters", `{"base_heart_rate": 62, "arrhythmia_intensity": 0.9}`)
+	if code != http.StatusOK || status.BaseHeartRate != 62 {
+		t.Errorf("Expected base heart rate 62, got %d %+v", code, status)
+	}
+
+	code, status = doRequest(t, http.MethodPost, base+"/pause", "")
+	if code != http.StatusOK || !status.Paused {
+		t.Errorf("Expected paused, got %d %+v", code, status)
+	}
+
+	code, status = doRequest(t, http.MethodPost, base+"/events", `{"type": "episode", "condition": "asystole", "duration": "5s"}`)
+	if code != http.StatusOK || status.Episode != simulation.ConditionAsystole {
+		t.Errorf("Expected asystole episode, got %d %+v", code, status)
+	}
+
+	code, status = doRequest(t, http.MethodDelete, base+"/condition", "")
+	if code != http.StatusOK || status.Forced != "" {
+		t.Errorf("Expected forced condition cleared, got %d %+v", code, status)
+	}
+
+	controller, _ := roster.Get("PATIENT-2")
+	if !controller.Paused() {
+		t.Error("Expected the live controller to be paused")
+	}
+	if other, _ := roster.Get("PATIENT-1"); other.Status().BaseHeartRate == 62 {
+		t.Error("Expected other patients to be unaffected")
+	}
+
+	for _, tt := range []struct {
+		method, url, body string
+		want              int
+	}{
+		{http.MethodPut, testServer.URL + "/patients/NOBODY/condition", `{"condition": "normal"}`, http.StatusNotFound},
+		{http.MethodPut, base + "/condition", `{"condition": "sleepy"}`, http.StatusBadRequest},
+		{http.MethodPatch, base + "/parameters", `{"base_hr": 70}`, http.StatusBadRequest},
+		{http.MethodPost, base + "/events", `{"type": "stroke"}`, http.StatusBadRequest},
+	} {
+		if code, _ := doRequest(t, tt.method, tt.url, tt.body); code != tt.want {
+			t.Errorf("%s %s: expected %d, got %d", tt.method, tt.url, tt.want, code)
+		}
+	}
+
+	resp, err := http.Get(testServer.URL + "/patients")
+	if err != nil {
+		t.Fatalf("Failed to list patients: %v", err)
+	}
+	defer resp.Body.Close()
+	var statuses []simulation.ControllerStatus
+	if err := json.NewDecoder(resp.Body).Decode(&statuses); err != nil || len(statuses) != 2 {
+		t.Errorf("Expected two patient statuses, got %v (%v)", statuses, err)
+	}
+}
+
+func TestControlMessages(t *testing.T) {
+	testServer, roster := newControlServer(t)
+
+	wsURL := "ws" + strings.TrimPrefix(testServer.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL+"/ecg/PATIENT-1", nil)
+	if err != nil {
+		t.Fatalf("Could not open websocket connection: %v", err)
+	}
+	defer ws.Close()
+
+	// The patient ID defaults to the stream's patient.
+	err = ws.WriteJSON(server.ControlMessage{Action: server.ActionForceCondition, Condition: simulation.ConditionTachycardia})
+	if err != nil {
+		t.Fatalf("Failed to send control message: %v", err)
+	}
+
+	reply := readControlReply(t, ws)
+	if reply.Error != "" || reply.Status == nil || reply.Status.Forced != simulation.ConditionTachycardia {
+		t.Fatalf("Expected the forced condition in the reply, got %+v", reply)
+	}
+	if reply.PatientID != "PATIENT-1" || reply.Action != server.ActionForceCondition {
+		t.Errorf("Expected the reply to name the patient and action, got %+v", reply)
+	}
+	if controller, _ := roster.Get("PATIENT-1"); controller.Status().Forced != simulation.ConditionTachycardia {
+		t.Error("Control message was not applied")
+	}
+
+	// Rejected messages are answered with the reason.
+	for _, msg := range []string{
+		`{"action": "force_condition", "condition": "hiccups"}`,
+		`{"patient_id": "PATIENT-9", "action": "pause"}`,
+		`{"action": `,
+	} {
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatalf("Failed to send control message: %v", err)
+		}
+		if reply := readControlReply(t, ws); reply.Error == "" || reply.Status != nil {
+			t.Errorf("%s: expected an error reply, got %+v", msg, reply)
+		}
+	}
+}
+
+// readControlReply skips the readings on the stream until the reply to a
+// control message arrives.
+func readControlReply(t *testing.T, ws *websocket.Conn) server.ControlReply {
+	t.Helper()
+
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("No reply to the control message: %v", err)
+		}
+		var reply server.ControlReply
+		if err := json.Unmarshal(data, &reply); err == nil && reply.Type == server.ControlReplyType {
+			return reply
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package server_test
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"arhm/ecg-monitoring/pkg/ecg"
+	"arhm/ecg-monitoring/pkg/server"
+	"arhm/ecg-monitoring/pkg/simulation"
+
+	"github.com/gorilla/websocket"
+)
+
+// stubSource delivers readings only when the test emits them.
+type stubSource struct {
+	mu       sync.Mutex
+	callback func(reading ecg.ECGReading, condition simulation.Condition)
+	runs     int
+}
+
+func (s *stubSource) PatientID() string {
+	return "PATIENT-1"
+}
+
+func (s *stubSource) Run(callback func(reading ecg.ECGReading, condition simulation.Condition)) func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.callback = callback
+	s.runs++
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.callback = nil
+	}
+}
+
+func (s *stubSource) running() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.callback != nil
+}
+
+func (s *stubSource) emit(heartRate int) {
+	s.mu.Lock()
+	callback := s.callback
+	s.mu.Unlock()
+
+	callback(ecg.ECGReading{
+		PatientID:  "PATIENT-1",
+		Timestamp:  time.Unix(int64(heartRate), 0),
+		HeartRate:  heartRate,
+		RRInterval: 60 / float64(heartRate),
+	}, simulation.ConditionNormal)
+}
+
+func receive(t *testing.T, sub *server.Subscription) ecg.ECGReading {
+	t.Helper()
+	select {
+	case msg, ok := <-sub.C:
+		if !ok {
+			t.Fatal("Subscription closed unexpectedly")
+		}
+		return msg.Reading
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for a reading")
+	}
+	return ecg.ECGReading{}
+}
+
+func TestHubFansOutOneRun(t *testing.T) {
+	source := &stubSource{}
+	hub := server.NewHub(source)
+
+	var observed int
+	hub.Observe = func(msg server.Message) {
+		observed++
+	}
+
+	first, err := hub.Subscribe("PATIENT-1")
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	second, err := hub.Subscribe("PATIENT-1")
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	for hr := 60; hr < 65; hr++ {
+		source.emit(hr)
+	}
+	for hr := 60; hr < 65; hr++ {
+		a, b := receive(t, first), receive(t, second)
+		if a.HeartRate != hr || b.HeartRate != hr {
+			t.Errorf("Expected both subscribers to receive HR=%d, got %d and %d", hr, a.HeartRate, b.HeartRate)
+		}
+	}
+
+	if source.runs != 1 {
+		t.Errorf("Expected the source to run once, ran %d times", source.runs)
+	}
+	if observed != 5 {
+		t.Errorf("Expected each reading to be observed once, got %d observations", observed)
+	}
+
+	if _, err := hub.Subscribe("UNKNOWN"); !errors.Is(err, server.ErrUnknownPatient) {
+		t.Errorf("Expected ErrUnknownPatient, got %v", err)
+	}
+}
+
+func TestHubStopsWithoutSubscribers(t *testing.T) {
+	source := &stubSource{}
+	hub := server.NewHub(source)
+
+	var starts []bool
+	hub.Observe = func(msg server.Message) {
+		starts = append(starts, msg.Start)
+	}
+
+	first, _ := hub.Subscribe("PATIENT-1")
+	second, _ := hub.Subscribe("PATIENT-1")
+	source.emit(60)
+	source.emit(61)
+
+	first.Close()
+	if !source.running() {
+		t.Fatal("Expected the source to keep running while a subscriber remains")
+	}
+	second.Close()
+	if source.running() {
+		t.Fatal("Expected the source to stop with the last subscriber")
+	}
+	if _, ok := <-second.C; !ok {
+		t.Error("Expected queued readings to remain readable after Close")
+	}
+
+	third, _ := hub.Subscribe("PATIENT-1")
+	defer third.Close()
+	source.emit(62)
+	if reading := receive(t, third); reading.HeartRate != 62 {
+		t.Errorf("Expected HR=62 after restart, got %d", reading.HeartRate)
+	}
+
+	if source.runs != 2 {
+		t.Errorf("Expected the source to restart once, ran %d times", source.runs)
+	}
+	if want := []bool{true, false, true}; len(starts) != 3 || starts[0] != want[0] || starts[1] != want[1] || starts[2] != want[2] {
+		t.Errorf("Expected Start on the first reading of each run %v, got %v", want, starts)
+	}
+}
+
+func TestHubDropOldest(t *testing.T) {
+	source := &stubSource{}
+	hub := server.NewHub(source)
+	hub.QueueSize = 2
+
+	slow, _ := hub.Subscribe("PATIENT-1")
+	defer slow.Close()
+	for hr := 60; hr < 65; hr++ {
+		source.emit(hr)
+	}
+
+	for _, hr := range []int{63, 64} {
+		if reading := receive(t, slow); reading.HeartRate != hr {
+			t.Errorf("Expected the newest readings to be kept, got HR=%d want %d", reading.HeartRate, hr)
+		}
+	}
+	if slow.Dropped() != 3 {
+		t.Errorf("Expected 3 dropped readings, got %d", slow.Dropped())
+	}
+	if slow.Disconnected() {
+		t.Error("Expected drop_oldest to keep the subscriber")
+	}
+}
+
+func TestHubDisconnectSlowConsumer(t *testing.T) {
+	source := &stubSource{}
+	hub := server.NewHub(source)
+	hub.QueueSize = 1
+	hub.Policy = server.Disconnect
+
+	fast, _ := hub.Subscribe("PATIENT-1")
+	defer fast.Close()
+	slow, _ := hub.Subscribe("PATIENT-1")
+
+	for hr := 60; hr < 63; hr++ {
+		source.emit(hr)
+		if reading := receive(t, fast); reading.HeartRate != hr {
+			t.Errorf("Expected the fast subscriber to receive HR=%d, got %d", hr, reading.HeartRate)
+		}
+	}
+
+	if !slow.Disconnected() {
+		t.Fatal("Expected the slow subscriber to be disconnected")
+	}
+	if reading := receive(t, slow); reading.HeartRate != 60 {
+		t.Errorf("Expected the readings queued before the disconnect, got HR=%d", reading.HeartRate)
+	}
+	if _, ok := <-slow.C; ok {
+		t.Error("Expected the slow subscription to be closed")
+	}
+	if !source.running() {
+		t.Error("Expected the source to keep running for the fast subscriber")
+	}
+}
+
+func TestHubBlockingBatchClock(t *testing.T) {
+	controller := simulation.NewController()
+	controller.Seed(1)
+	controller.Clock = simulation.NewBatchClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	source := server.NewSimulatorSource(controller, server.ReadingInterval)
+	hub := server.NewHub(source)
+	hub.QueueSize = 4
+	hub.Policy = server.Disconnect
+	hub.Blocking = true
+
+	var mu sync.Mutex
+	observed := 0
+	hub.Observe = func(server.Message) {
+		mu.Lock()
+		observed++
+		mu.Unlock()
+	}
+
+	fast, _ := hub.Subscribe(source.PatientID())
+	slow, _ := hub.Subscribe(source.PatientID())
+
+	// Nobody reads: the simulation waits for the slow subscriber instead of
+	// running ahead.
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	if observed > hub.QueueSize+1 {
+		t.Errorf("Expected the source to wait for room in the queues, got %d readings", observed)
+	}
+	mu.Unlock()
+
+	var last time.Time
+	for i := 0; i < 20; i++ {
+		reading := receive(t, fast)
+		receive(t, slow)
+		if !last.IsZero() && reading.Timestamp.Sub(last) != server.ReadingInterval {
+			t.Fatalf("Reading %d: expected no gap in simulated time, got %v", i, reading.Timestamp.Sub(last))
+		}
+		last = reading.Timestamp
+	}
+	if slow.Disconnected() || slow.Dropped() != 0 || fast.Dropped() != 0 {
+		t.Errorf("Expected no dropped readings, got %d and %d", fast.Dropped(), slow.Dropped())
+	}
+
+	// Closing a subscription the source is waiting on releases it.
+	time.Sleep(20 * time.Millisecond)
+	slow.Close()
+	for i := 0; i < 10; i++ {
+		receive(t, fast)
+	}
+	fast.Close()
+}
+
+func TestECGHandlerSharedStream(t *testing.T) {
+	tempDir := t.TempDir()
+	loggers, err := server.SetupLoggers(tempDir+"/test.log", tempDir+"/alerts.log")
+	if err != nil {
+		t.Fatalf("Failed to setup test loggers: %v", err)
+	}
+	defer loggers.Close()
+
+	roster := simulation.NewRoster(1)
+	roster.SetClock(simulation.NewClock(20))
+	testServer := httptest.NewServer(server.NewRosterECGHandler(loggers, roster))
+	defer testServer.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(testServer.URL, "http")
+	var nurses []*websocket.Conn
+	for range 2 {
+		ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatalf("Could not open websocket connection: %v", err)
+		}
+		defer ws.Close()
+		ws.SetReadDeadline(time.Now().Add(3 * time.Second))
+		nurses = append(nurses, ws)
+	}
+
+	// The second nurse joins a little later, so compare readings by time.
+	seen := make(map[time.Time]ecg.ECGReading)
+	for range 5 {
+		var reading ecg.ECGReading
+		if err := nurses[0].ReadJSON(&reading); err != nil {
+			t.Fatalf("Failed to read reading: %v", err)
+		}
+		seen[reading.Timestamp] = reading
+	}
+
+	shared := 0
+	for range 5 {
+		var reading ecg.ECGReading
+		if err := nurses[1].ReadJSON(&reading); err != nil {
+			t.Fatalf("Failed to read reading: %v", err)
+		}
+		other, ok := seen[reading.Timestamp]
+		if !ok {
+			continue
+		}
+		shared++
+		if other.HeartRate != reading.HeartRate || other.RRInterval != reading.RRInterval {
+			t.Errorf("Nurses saw different readings at %v: HR=%d RR=%0.3f vs HR=%d RR=%0.3f",
+				reading.Timestamp, other.HeartRate, other.RRInterval, reading.HeartRate, reading.RRInterval)
+		}
+	}
+	if shared == 0 {
+		t.Error("Expected the nurses to see some of the same readings")
+	}
+}
@@ -3,11 +3,14 @@ package server_test
 import (
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
 	"time"
 
+	"arhm/ecg-monitoring/pkg/ecg"
 	"arhm/ecg-monitoring/pkg/server"
+	"arhm/ecg-monitoring/pkg/simulation"
 
 	"github.com/gorilla/websocket"
 )
@@ -66,3 +69,245 @@ func TestECGHandlerWebSocketConnection(t *testing.T) {
 		}
 	}
 }
+
+func TestECGHandlerPatientStream(t *testing.T) {
+	tempDir := t.TempDir()
+	loggers, err := server.SetupLoggers(tempDir+"/test.log", tempDir+"/alerts.log")
+	if err != nil {
+		t.Fatalf("Failed to setup test loggers: %v", err)
+	}
+	defer loggers.Close()
+
+	handler := server.NewRosterECGHandler(loggers, simulation.NewRoster(2))
+
+	mux := http.NewServeMux()
+	mux.Handle("/ecg/{patientID}", handler)
+	testServer := httptest.NewServer(mux)
+	defer testServer.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(testServer.URL, "http")
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL+"/ecg/UNKNOWN", nil)
+	if err == nil {
+		t.Fatal("Expected dial to unknown patient to fail")
+	}
+	if resp == nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown patient, got %v", resp)
+	}
+
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL+"/ecg/PATIENT-2", nil)
+	if err != nil {
+		t.Fatalf("Could not open websocket connection: %v", err)
+	}
+	defer ws.Close()
+
+	ws.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for i := 0; i < 2; i++ {
+		var reading ecg.ECGReading
+		if err := ws.ReadJSON(&reading); err != nil {
+			t.Fatalf("Failed to read reading: %v", err)
+		}
+
+		if reading.PatientID != "PATIENT-2" {
+			t.Errorf("Expected reading for PATIENT-2, got %q", reading.PatientID)
+		}
+	}
+}
+
+func TestECGHandlerLeadSelection(t *testing.T) {
+	tempDir := t.TempDir()
+	loggers, err := server.SetupLoggers(tempDir+"/test.log", tempDir+"/alerts.log")
+	if err != nil {
+		t.Fatalf("Failed to setup test loggers: %v", err)
+	}
+	defer loggers.Close()
+
+	roster := simulation.NewRoster(1)
+	if err := roster.SetWaveform(simulation.SampleRate250); err != nil {
+		t.Fatalf("Failed to enable waveform: %v", err)
+	}
+	handler := server.NewRosterECGHandler(loggers, roster)
+
+	mux := http.NewServeMux()
+	mux.Handle("/ecg", handler)
+	testServer := httptest.NewServer(mux)
+	defer testServer.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(testServer.URL, "http") + "/ecg"
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL+"?leads=II,V9", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown lead, got %v", resp)
+	}
+
+	for _, tt := range []struct {
+		query string
+		want  []ecg.Lead
+	}{
+		{"", nil},
+		{"?leads=II,V1", []ecg.Lead{ecg.LeadII, ecg.LeadV1}},
+	} {
+		ws, _, err := websocket.DefaultDialer.Dial(wsURL+tt.query, nil)
+		if err != nil {
+			t.Fatalf("Could not open websocket connection: %v", err)
+		}
+
+		ws.SetReadDeadline(time.Now().Add(3 * time.Second))
+		var reading ecg.ECGReading
+		if err := ws.ReadJSON(&reading); err != nil {
+			t.Fatalf("Failed to read reading: %v", err)
+		}
+		ws.Close()
+
+		if len(reading.Leads) != len(tt.want) {
+			t.Errorf("%q: expected leads %v, got %d leads", tt.query, tt.want, len(reading.Leads))
+		}
+		for _, lead := range tt.want {
+			if len(reading.Leads[lead]) != simulation.SampleRate250 {
+				t.Errorf("%q: expected a second of lead %s, got %d samples", tt.query, lead, len(reading.Leads[lead]))
+			}
+		}
+	}
+}
+
+func TestECGHandlerBeatStream(t *testing.T) {
+	tempDir := t.TempDir()
+	loggers, err := server.SetupLoggers(tempDir+"/test.log", tempDir+"/alerts.log")
+	if err != nil {
+		t.Fatalf("Failed to setup test loggers: %v", err)
+	}
+	defer loggers.Close()
+
+	roster := simulation.NewRoster(1)
+	roster.SetBeats(true)
+	handler := server.NewRosterECGHandler(loggers, roster)
+
+	testServer := httptest.NewServer(handler)
+	defer testServer.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(testServer.URL, "http")
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL+"?stream=samples", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown stream, got %v", resp)
+	}
+
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL+"?stream=beats", nil)
+	if err != nil {
+		t.Fatalf("Could not open websocket connection: %v", err)
+	}
+	defer ws.Close()
+
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var previous ecg.Beat
+	for i := 0; i < 3; i++ {
+		var beat ecg.Beat
+		if err := ws.ReadJSON(&beat); err != nil {
+			t.Fatalf("Failed to read beat %d: %v", i, err)
+		}
+		if beat.PatientID == "" || beat.RRInterval <= 0 || beat.BeatType == "" {
+			t.Errorf("Beat %d: incomplete %+v", i, beat)
+		}
+		if i > 0 && !beat.Timestamp.After(previous.Timestamp) {
+			t.Errorf("Beat %d: timestamp %v not after %v", i, beat.Timestamp, previous.Timestamp)
+		}
+		previous = beat
+	}
+
+	summary, _, err := websocket.DefaultDialer.Dial(wsURL+"?stream=summary", nil)
+	if err != nil {
+		t.Fatalf("Could not open websocket connection: %v", err)
+	}
+	defer summary.Close()
+
+	summary.SetReadDeadline(time.Now().Add(3 * time.Second))
+	var reading ecg.ECGReading
+	if err := summary.ReadJSON(&reading); err != nil {
+		t.Fatalf("Failed to read reading: %v", err)
+	}
+	if reading.Beats != nil {
+		t.Errorf("Expected a summary reading without beats, got %d beats", len(reading.Beats))
+	}
+}
+
+func TestECGHandlerSensorFaultAlert(t *testing.T) {
+	tempDir := t.TempDir()
+	loggers, err := server.SetupLoggers(tempDir+"/test.log", tempDir+"/alerts.log")
+	if err != nil {
+		t.Fatalf("Failed to setup test loggers: %v", err)
+	}
+	defer loggers.Close()
+
+	// A tachycardic patient whose sensor reports an impossible heart rate.
+	patient := simulation.NewDefaultPatient()
+	patient.Faults = []simulation.FaultConfig{{Type: simulation.FaultInvalidHeartRate}}
+	roster := simulation.NewRoster(0)
+	roster.Add(simulation.NewStepController(patient, []simulation.Step{
+		{Condition: simulation.ConditionTachycardia, Ticks: 1, Next: -1},
+	}))
+
+	testServer := httptest.NewServer(server.NewRosterECGHandler(loggers, roster))
+	defer testServer.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(testServer.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Could not open websocket connection: %v", err)
+	}
+	defer ws.Close()
+
+	ws.SetReadDeadline(time.Now().Add(3 * time.Second))
+	var reading ecg.ECGReading
+	if err := ws.ReadJSON(&reading); err != nil {
+		t.Fatalf("Failed to read reading: %v", err)
+	}
+	if reading.HeartRate != 400 {
+		t.Errorf("Expected the faulty reading to be delivered, got HR=%d", reading.HeartRate)
+	}
+
+	alerts, err := os.ReadFile(tempDir + "/alerts.log")
+	if err != nil {
+		t.Fatalf("Failed to read alert log: %v", err)
+	}
+	if !strings.Contains(string(alerts), "TECHNICAL ALERT") {
+		t.Errorf("Expected a technical alert, got %q", alerts)
+	}
+	if strings.Contains(string(alerts), "TACHYCARDIA") {
+		t.Errorf("Expected no clinical alarm for a faulty reading, got %q", alerts)
+	}
+}
+
+func TestECGHandlerGroundTruth(t *testing.T) {
+	tempDir := t.TempDir()
+	loggers, err := server.SetupLoggers(tempDir+"/test.log", tempDir+"/alerts.log")
+	if err != nil {
+		t.Fatalf("Failed to setup test loggers: %v", err)
+	}
+	defer loggers.Close()
+
+	for _, groundTruth := range []bool{false, true} {
+		handler := server.NewECGHandler(loggers)
+		handler.GroundTruth = groundTruth
+		testServer := httptest.NewServer(handler)
+
+		ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(testServer.URL, "http"), nil)
+		if err != nil {
+			t.Fatalf("Could not open websocket connection: %v", err)
+		}
+
+		ws.SetReadDeadline(time.Now().Add(3 * time.Second))
+		var reading ecg.ECGReading
+		if err := ws.ReadJSON(&reading); err != nil {
+			t.Fatalf("Failed to read reading: %v", err)
+		}
+		ws.Close()
+		testServer.Close()
+
+		if (reading.Truth != nil) != groundTruth {
+			t.Errorf("GroundTruth=%v: got truth %+v", groundTruth, reading.Truth)
+			continue
+		}
+		if groundTruth && (reading.Truth.Condition != string(simulation.ConditionNormal) || reading.Truth.EpisodeID != 1) {
+			t.Errorf("Expected the first reading in normal episode 1, got %+v", reading.Truth)
+		}
+	}
+}
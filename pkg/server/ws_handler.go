@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	"arhm/ecg-monitoring/pkg/ecg"
@@ -12,25 +13,101 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+const ReadingInterval = 1 * time.Second
+
+// Subscriptions a client can choose with the stream query parameter: one
+// summary reading per interval, or one message per beat.
+const (
+	StreamSummary = "summary"
+	StreamBeats   = "beats"
+)
+
 type ECGHandler struct {
-	Loggers   *Loggers
-	Upgrader  websocket.Upgrader
-	Simulator *simulation.Controller
+	Loggers  *Loggers
+	Upgrader websocket.Upgrader
+	Sources  []ReadingSource
+
+	// Target of control messages sent by clients; nil ignores them.
+	Control *ControlHandler
+
+	// Runs the sources and fans their readings out to connections.
+	Hub *Hub
+
+	// Send the sources' ground truth labels with readings, for validating
+	// detectors. Off by default, as a monitor has no ground truth.
+	GroundTruth bool
+
+	// Device faults raise technical alerts instead of clinical ones. Only
+	// used from the hub's Observe, so calls never overlap.
+	detectors map[string]*ecg.FaultDetector
 }
 
 func NewECGHandler(loggers *Loggers) *ECGHandler {
-	return &ECGHandler{
+	return NewRosterECGHandler(loggers, simulation.NewRoster(1))
+}
+
+func NewRosterECGHandler(loggers *Loggers, roster *simulation.Roster) *ECGHandler {
+	handler := NewSourceECGHandler(loggers, SimulatorSources(roster, ReadingInterval)...)
+	handler.Control = NewControlHandler(loggers, roster)
+	return handler
+}
+
+func NewSourceECGHandler(loggers *Loggers, sources ...ReadingSource) *ECGHandler {
+	handler := &ECGHandler{
 		Loggers: loggers,
 		Upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
 				return true
 			},
 		},
-		Simulator: simulation.NewController(),
+		Sources:   sources,
+		Hub:       NewHub(sources...),
+		detectors: make(map[string]*ecg.FaultDetector),
 	}
+	handler.Hub.Observe = handler.observe
+	return handler
+}
+
+func (h *ECGHandler) source(patientID string) (ReadingSource, bool) {
+	for _, source := range h.Sources {
+		if source.PatientID() == patientID {
+			return source, true
+		}
+	}
+	return nil, false
 }
 
 func (h *ECGHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sources := h.Sources
+	if patientID := r.PathValue("patientID"); patientID != "" {
+		source, ok := h.source(patientID)
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown patient %q", patientID), http.StatusNotFound)
+			return
+		}
+		sources = []ReadingSource{source}
+	}
+
+	// Waveforms are only sent for the leads the client asks for.
+	var leads []ecg.Lead
+	if query := r.URL.Query().Get("leads"); query != "" {
+		var err error
+		if leads, err = ecg.ParseLeads(query); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	stream := r.URL.Query().Get("stream")
+	switch stream {
+	case "":
+		stream = StreamSummary
+	case StreamSummary, StreamBeats:
+	default:
+		http.Error(w, fmt.Sprintf("unknown stream %q, expected %q or %q", stream, StreamSummary, StreamBeats), http.StatusBadRequest)
+		return
+	}
+
 	c, err := h.Upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		h.Loggers.General.Printf("WebSocket upgrade error: %v", err)
@@ -38,53 +115,214 @@ func (h *ECGHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	defer c.Close()
 
-	h.Loggers.General.Printf("New client connected from %s", c.RemoteAddr())
-
-	ticker := h.Simulator.RunWithCallback(1*time.Second, func(reading ecg.ECGReading, condition simulation.Condition) {
-		switch condition {
-		case simulation.ConditionTachycardia:
-			h.Loggers.General.Printf("Tachycardia - HR=%d, RR=%0.2f", reading.HeartRate, reading.RRInterval)
-			alertMsg := fmt.Sprintf("ALERT: TACHYCARDIA detected - High heart rate (HR=%d BPM, RR=%0.2f s)",
-				reading.HeartRate, reading.RRInterval)
-			h.Loggers.Alert.Println(alertMsg)
-			h.Loggers.General.Println(alertMsg)
-		case simulation.ConditionBradycardia:
-			h.Loggers.General.Printf("Bradycardia - HR=%d, RR=%0.2f", reading.HeartRate, reading.RRInterval)
-			alertMsg := fmt.Sprintf("ALERT: BRADYCARDIA detected - Low heart rate (HR=%d BPM, RR=%0.2f s)",
-				reading.HeartRate, reading.RRInterval)
-			h.Loggers.Alert.Println(alertMsg)
-			h.Loggers.General.Println(alertMsg)
-		case simulation.ConditionArrhythmia:
-			h.Loggers.General.Printf("Arrhythmia - HR=%d, RR=%0.2f", reading.HeartRate, reading.RRInterval)
-			alertMsg := fmt.Sprintf("ALERT: ARRHYTHMIA detected - Irregular heartbeat (HR=%d BPM, RR=%0.2f s)",
-				reading.HeartRate, reading.RRInterval)
-			h.Loggers.Alert.Println(alertMsg)
-			h.Loggers.General.Println(alertMsg)
-		default:
-			h.Loggers.General.Printf("Normal - HR=%d, RR=%0.2f", reading.HeartRate, reading.RRInterval)
-		}
+	h.Loggers.General.Printf("New client connected from %s (%d patients, %s stream)", c.RemoteAddr(), len(sources), stream)
 
-		data, err := json.Marshal(reading)
+	var writeMu sync.Mutex
+	send := func(v any) bool {
+		data, err := json.Marshal(v)
 		if err != nil {
 			h.Loggers.General.Printf("Marshal error: %v", err)
-			return
+			return false
 		}
 
+		writeMu.Lock()
 		err = c.WriteMessage(websocket.TextMessage, data)
+		writeMu.Unlock()
 		if err != nil {
 			h.Loggers.General.Printf("Write error: %v", err)
-			return
+			return false
+		}
+		return true
+	}
+
+	var writers sync.WaitGroup
+	subscriptions := make([]*Subscription, 0, len(sources))
+	for _, source := range sources {
+		sub, err := h.Hub.Subscribe(source.PatientID())
+		if err != nil {
+			h.Loggers.General.Printf("Subscribe error: %v", err)
+			continue
 		}
+		subscriptions = append(subscriptions, sub)
 
-		h.Loggers.General.Printf("Sent reading: HR=%d, RR=%0.2f", reading.HeartRate, reading.RRInterval)
-	})
-	defer ticker.Stop()
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			for msg := range sub.C {
+				if !h.sendReading(send, msg.Reading, stream, leads) {
+					c.Close()
+					return
+				}
+			}
+			if sub.Disconnected() {
+				h.Loggers.General.Printf("[%s] Disconnecting slow client %s", source.PatientID(), c.RemoteAddr())
+				c.Close()
+			}
+		}()
+	}
 
 	for {
-		_, _, err := c.ReadMessage()
+		_, data, err := c.ReadMessage()
 		if err != nil {
 			h.Loggers.General.Printf("Read error: %v", err)
 			break
 		}
+		send(h.handleControlMessage(data, r.PathValue("patientID")))
+	}
+
+	for _, sub := range subscriptions {
+		sub.Close()
+	}
+	writers.Wait()
+}
+
+// sendReading sends one reading in the form the client subscribed to. The
+// reading is shared with the other connections and is copied before it is
+// trimmed.
+func (h *ECGHandler) sendReading(send func(v any) bool, reading ecg.ECGReading, stream string, leads []ecg.Lead) bool {
+	if stream == StreamBeats {
+		for _, beat := range reading.Beats {
+			if !send(beat) {
+				return false
+			}
+		}
+		h.Loggers.General.Printf("[%s] Sent %d beats", reading.PatientID, len(reading.Beats))
+		return true
+	}
+
+	reading.Beats = nil
+	if !h.GroundTruth {
+		reading.Truth = nil
+	}
+	reading.Leads = selectLeads(reading.Leads, leads)
+	if reading.Leads == nil && reading.Samples == nil {
+		reading.SampleRate = 0
+	}
+
+	if !send(reading) {
+		return false
+	}
+	h.Loggers.General.Printf("[%s] Sent reading: HR=%d, RR=%0.2f", reading.PatientID, reading.HeartRate, reading.RRInterval)
+	return true
+}
+
+// selectLeads returns the selected leads of a reading's waveform in a new map,
+// leaving the reading shared with other connections untouched.
+func selectLeads(all map[ecg.Lead]ecg.Samples, leads []ecg.Lead) map[ecg.Lead]ecg.Samples {
+	if len(all) == 0 || len(leads) == 0 {
+		return nil
+	}
+
+	selected := make(map[ecg.Lead]ecg.Samples, len(leads))
+	for _, lead := range leads {
+		if samples, ok := all[lead]; ok {
+			selected[lead] = samples
+		}
+	}
+	return selected
+}
+
+// handleControlMessage applies a ControlMessage sent by the client and returns
+// the reply to send back: the patient's status, or why the message was
+// rejected. On a single-patient stream the patient ID may be omitted.
+func (h *ECGHandler) handleControlMessage(data []byte, streamPatientID string) ControlReply {
+	reply := ControlReply{Type: ControlReplyType}
+	if h.Control == nil {
+		h.Loggers.General.Printf("Ignoring client message: this stream does not accept control messages")
+		reply.Error = "this stream does not accept control messages"
+		return reply
+	}
+
+	var msg ControlMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		h.Loggers.General.Printf("Invalid control message: %v", err)
+		reply.Error = fmt.Sprintf("invalid JSON: %v", err)
+		return reply
+	}
+	if msg.PatientID == "" {
+		msg.PatientID = streamPatientID
+	}
+	reply.PatientID, reply.Action = msg.PatientID, msg.Action
+
+	status, err := h.Control.apply(msg)
+	if err != nil {
+		reply.Error = err.Error()
+		return reply
+	}
+	reply.Status = &status
+	return reply
+}
+
+// observe logs each reading once, however many clients receive it. A source
+// that restarts after a time without subscribers has a gap in its readings,
+// so its fault detector starts over.
+func (h *ECGHandler) observe(msg Message) {
+	patientID := msg.Reading.PatientID
+	detector, ok := h.detectors[patientID]
+	if !ok || msg.Start {
+		detector = ecg.NewFaultDetector(0)
+		h.detectors[patientID] = detector
+	}
+
+	if err := detector.Check(msg.Reading); err != nil {
+		h.logTechnicalAlert(msg.Reading, err)
+	} else {
+		h.logReading(msg.Reading, msg.Condition)
+	}
+}
+
+func (h *ECGHandler) logTechnicalAlert(reading ecg.ECGReading, err error) {
+	alertMsg := fmt.Sprintf("[%s] TECHNICAL ALERT: %s - %v", reading.PatientID, ecg.ConditionSensorFault, err)
+	h.Loggers.Alert.Println(alertMsg)
+	h.Loggers.General.Println(alertMsg)
+}
+
+func (h *ECGHandler) logReading(reading ecg.ECGReading, condition simulation.Condition) {
+	switch condition {
+	case simulation.ConditionTachycardia:
+		h.Loggers.General.Printf("[%s] Tachycardia - HR=%d, RR=%0.2f", reading.PatientID, reading.HeartRate, reading.RRInterval)
+		alertMsg := fmt.Sprintf("[%s] ALERT: TACHYCARDIA detected - High heart rate (HR=%d BPM, RR=%0.2f s)",
+			reading.PatientID, reading.HeartRate, reading.RRInterval)
+		h.Loggers.Alert.Println(alertMsg)
+		h.Loggers.General.Println(alertMsg)
+	case simulation.ConditionBradycardia:
+		h.Loggers.General.Printf("[%s] Bradycardia - HR=%d, RR=%0.2f", reading.PatientID, reading.HeartRate, reading.RRInterval)
+		alertMsg := fmt.Sprintf("[%s] ALERT: BRADYCARDIA detected - Low heart rate (HR=%d BPM, RR=%0.2f s)",
+			reading.PatientID, reading.HeartRate, reading.RRInterval)
+		h.Loggers.Alert.Println(alertMsg)
+		h.Loggers.General.Println(alertMsg)
+	case simulation.ConditionArrhythmia:
+		h.Loggers.General.Printf("[%s] Arrhythmia - HR=%d, RR=%0.2f", reading.PatientID, reading.HeartRate, reading.RRInterval)
+		alertMsg := fmt.Sprintf("[%s] ALERT: ARRHYTHMIA detected - Irregular heartbeat (HR=%d BPM, RR=%0.2f s)",
+			reading.PatientID, reading.HeartRate, reading.RRInterval)
+		h.Loggers.Alert.Println(alertMsg)
+		h.Loggers.General.Println(alertMsg)
+	case simulation.ConditionAtrialFibrillation:
+		h.Loggers.General.Printf("[%s] Atrial fibrillation - HR=%d, RR=%0.2f", reading.PatientID, reading.HeartRate, reading.RRInterval)
+		alertMsg := fmt.Sprintf("[%s] ALERT: ATRIAL FIBRILLATION detected - Irregularly irregular rhythm (HR=%d BPM, RR=%0.2f s)",
+			reading.PatientID, reading.HeartRate, reading.RRInterval)
+		h.Loggers.Alert.Println(alertMsg)
+		h.Loggers.General.Println(alertMsg)
+	case simulation.ConditionVentricularTachycardia:
+		h.Loggers.General.Printf("[%s] Ventricular tachycardia - HR=%d, RR=%0.2f, QRS=%0.2f", reading.PatientID, reading.HeartRate, reading.RRInterval, reading.QRSDuration)
+		alertMsg := fmt.Sprintf("[%s] CRITICAL ALERT: V-TACH detected - Wide complex tachycardia (HR=%d BPM, QRS=%0.2f s)",
+			reading.PatientID, reading.HeartRate, reading.QRSDuration)
+		h.Loggers.Alert.Println(alertMsg)
+		h.Loggers.General.Println(alertMsg)
+	case simulation.ConditionVentricularFibrillation:
+		h.Loggers.General.Printf("[%s] Ventricular fibrillation - HR=%d, RR=%0.2f", reading.PatientID, reading.HeartRate, reading.RRInterval)
+		alertMsg := fmt.Sprintf("[%s] CRITICAL ALERT: V-FIB detected - Chaotic ventricular activity (HR=%d BPM)",
+			reading.PatientID, reading.HeartRate)
+		h.Loggers.Alert.Println(alertMsg)
+		h.Loggers.General.Println(alertMsg)
+	case simulation.ConditionAsystole:
+		h.Loggers.General.Printf("[%s] Asystole - HR=%d", reading.PatientID, reading.HeartRate)
+		alertMsg := fmt.Sprintf("[%s] CRITICAL ALERT: ASYSTOLE detected - No ventricular activity", reading.PatientID)
+		h.Loggers.Alert.Println(alertMsg)
+		h.Loggers.General.Println(alertMsg)
+	case "":
+		h.Loggers.General.Printf("[%s] Unlabelled - HR=%d, RR=%0.2f", reading.PatientID, reading.HeartRate, reading.RRInterval)
+	default:
+		h.Loggers.General.Printf("[%s] Normal - HR=%d, RR=%0.2f", reading.PatientID, reading.HeartRate, reading.RRInterval)
 	}
 }
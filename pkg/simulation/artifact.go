@@ -0,0 +1,213 @@
+package simulation
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"arhm/ecg-monitoring/pkg/ecg"
+)
+
+const (
+	ArtifactBaselineWander = "baseline_wander"
+	ArtifactPowerline      = "powerline"
+	ArtifactMuscle         = "muscle"
+	ArtifactMotion         = "motion"
+	ArtifactLeadOff        = "lead_off"
+	ArtifactDroppedSamples = "dropped_samples"
+)
+
+// ArtifactConfig adds one kind of noise or artifact to the simulated signal.
+//
+// An artifact with neither Duration nor Rate is always present. With Duration
+// it is present from Start for Duration, repeating every Period if set. With
+// Rate it occurs at random, Rate times per minute on average, each occurrence
+// lasting Duration.
+//
+// Amplitude is in millivolts; Frequency applies to baseline wander and
+// powerline interference. For dropped samples Amplitude is the fraction of
+// samples lost while active (1 when zero).
+type ArtifactConfig struct {
+	Type      string   `json:"type"`
+	Amplitude float64  `json:"amplitude"`
+	Frequency float64  `json:"frequency"`
+	Start     Duration `json:"start"`
+	Duration  Duration `json:"duration"`
+	Period    Duration `json:"period"`
+	Rate      float64  `json:"rate"`
+}
+
+func (a ArtifactConfig) Validate() error {
+	switch a.Type {
+	case ArtifactBaselineWander, ArtifactPowerline, ArtifactMuscle, ArtifactMotion, ArtifactLeadOff, ArtifactDroppedSamples:
+	default:
+		return fmt.Errorf("unknown artifact type %q", a.Type)
+	}
+
+	if a.Amplitude < 0 {
+		return fmt.Errorf("%s: amplitude must not be negative", a.Type)
+	}
+	if a.Type == ArtifactDroppedSamples && a.Amplitude > 1 {
+		return fmt.Errorf("%s: amplitude is a fraction of samples and must be at most 1", a.Type)
+	}
+	if a.Frequency < 0 {
+		return fmt.Errorf("%s: frequency must not be negative", a.Type)
+	}
+	return validateSchedule(a.Type, a.Start, a.Duration, a.Period, a.Rate)
+}
+
+func validateSchedule(name string, start, duration, period Duration, rate float64) error {
+	if start < 0 || duration < 0 || period < 0 {
+		return fmt.Errorf("%s: start, duration and period must not be negative", name)
+	}
+	if rate < 0 {
+		return fmt.Errorf("%s: rate must not be negative", name)
+	}
+	if rate > 0 && duration == 0 {
+		return fmt.Errorf("%s: random occurrences need a duration", name)
+	}
+	if period > 0 && period < duration {
+		return fmt.Errorf("%s: period must not be shorter than duration", name)
+	}
+	return nil
+}
+
+func (a ArtifactConfig) amplitude() float64 {
+	if a.Amplitude > 0 {
+		return a.Amplitude
+	}
+
+	switch a.Type {
+	case ArtifactBaselineWander:
+		return 0.3
+	case ArtifactPowerline:
+		return 0.1
+	case ArtifactMuscle:
+		return 0.05
+	case ArtifactMotion:
+		return 1.0
+	default:
+		return 1
+	}
+}
+
+func (a ArtifactConfig) frequency() float64 {
+	if a.Frequency > 0 {
+		return a.Frequency
+	}
+
+	switch a.Type {
+	case ArtifactBaselineWander:
+		return 0.3
+	case ArtifactPowerline:
+		return 50
+	default:
+		return 0
+	}
+}
+
+type artifactState struct {
+	config      ArtifactConfig
+	activeUntil float64 // Seconds, for random occurrences
+	motion      float64 // Low-pass filtered noise for motion artifacts
+}
+
+func (s *artifactState) active(t, dt float64, rng RandomSource) bool {
+	c := s.config
+	return scheduled(c.Start, c.Duration, c.Period, c.Rate, &s.activeUntil, t, dt, rng)
+}
+
+// scheduled implements the timing shared by artifacts and faults, see
+// ArtifactConfig. activeUntil holds the end of the current random occurrence.
+func scheduled(startAt, lasting, period Duration, rate float64, activeUntil *float64, t, dt float64, rng RandomSource) bool {
+	start := time.Duration(startAt).Seconds()
+	duration := time.Duration(lasting).Seconds()
+
+	switch {
+	case rate > 0:
+		if t < *activeUntil {
+			return true
+		}
+		if t >= start && rng.Float64() < rate/60*dt {
+			*activeUntil = t + duration
+			return true
+		}
+		return false
+	case duration > 0:
+		if t < start {
+			return false
+		}
+		offset := t - start
+		if period := time.Duration(period).Seconds(); period > 0 {
+			offset = math.Mod(offset, period)
+		}
+		return offset < duration
+	default:
+		return t >= start
+	}
+}
+
+// applyArtifacts corrupts the clean samples of every lead taken at t seconds,
+// and returns the quality of the signal: lead-off, or noisy during motion,
+// which no detector can tell from beats. Lead-off and dropped samples replace
+// the signal (0 mV and NaN respectively); the other artifacts are added to
+// it, identically on every lead.
+func applyArtifacts(states []artifactState, values []float64, t, dt float64, rng RandomSource) ecg.SignalQuality {
+	noise := 0.0
+	leadOff := false
+	motion := false
+	dropped := false
+
+	for i := range states {
+		s := &states[i]
+		if !s.active(t, dt, rng) {
+			continue
+		}
+
+		amplitude := s.config.amplitude()
+		switch s.config.Type {
+		case ArtifactBaselineWander:
+			f := s.config.frequency()
+			noise += amplitude * (math.Sin(2*math.Pi*f*t) + 0.5*math.Sin(2*math.Pi*f*0.27*t+1))
+		case ArtifactPowerline:
+			noise += amplitude * math.Sin(2*math.Pi*s.config.frequency()*t)
+		case ArtifactMuscle:
+			noise += amplitude * rng.NormFloat64()
+		case ArtifactMotion:
+			// Random walk pulled back to zero, giving slow large swings.
+			s.motion += (rng.NormFloat64()*amplitude*math.Sqrt(dt)*8 - s.motion*dt*2)
+			noise += s.motion
+			motion = true
+		case ArtifactLeadOff:
+			leadOff = true
+		case ArtifactDroppedSamples:
+			fraction := s.config.Amplitude
+			if fraction == 0 {
+				fraction = 1
+			}
+			if rng.Float64() < fraction {
+				dropped = true
+			}
+		}
+	}
+
+	for i := range values {
+		switch {
+		case dropped:
+			values[i] = math.NaN()
+		case leadOff:
+			values[i] = 0
+		default:
+			values[i] += noise
+		}
+	}
+
+	switch {
+	case leadOff:
+		return ecg.SignalLeadOff
+	case motion:
+		return ecg.SignalNoisy
+	default:
+		return ecg.SignalGood
+	}
+}
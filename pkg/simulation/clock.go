@@ -0,0 +1,187 @@
+package simulation
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the source of simulated time. Readings are stamped and paced by
+// the controller's clock, so a simulation can run in real time, faster than
+// real time, or as fast as its consumer can keep up.
+type Clock interface {
+	Now() time.Time
+	// After delivers the clock's time once d of simulated time has passed.
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+type Stopper interface {
+	Stop()
+}
+
+// Ticker delivers the simulated time every period until stopped. Unlike
+// time.Ticker, Stop also releases any goroutine behind the ticker.
+type Ticker interface {
+	Stopper
+	C() <-chan time.Time
+}
+
+// NewClock returns a clock running speed times faster than real time,
+// starting now. Speed 1 is the real clock and speed 0 is batch mode.
+func NewClock(speed float64) Clock {
+	switch {
+	case speed == 1:
+		return RealClock{}
+	case speed <= 0:
+		return NewBatchClock(time.Now())
+	default:
+		return NewScaledClock(time.Now(), speed)
+	}
+}
+
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (RealClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (RealClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+func (t realTicker) Stop() {
+	t.ticker.Stop()
+}
+
+// ScaledClock runs Speed times faster than real time from Origin.
+type ScaledClock struct {
+	Origin time.Time
+	Speed  float64
+	start  time.Time
+}
+
+func NewScaledClock(origin time.Time, speed float64) *ScaledClock {
+	return &ScaledClock{Origin: origin, Speed: speed, start: time.Now()}
+}
+
+func (c *ScaledClock) Now() time.Time {
+	return c.Origin.Add(time.Duration(float64(time.Since(c.start)) * c.Speed))
+}
+
+func (c *ScaledClock) real(d time.Duration) time.Duration {
+	return max(time.Duration(float64(d)/c.Speed), 1)
+}
+
+func (c *ScaledClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	time.AfterFunc(c.real(d), func() {
+		ch <- c.Now()
+	})
+	return ch
+}
+
+func (c *ScaledClock) NewTicker(d time.Duration) Ticker {
+	ticker := time.NewTicker(c.real(d))
+	t := &forwardingTicker{c: make(chan time.Time, 1), done: make(chan struct{}), stop: ticker.Stop}
+
+	go func() {
+		for {
+			select {
+			case <-t.done:
+				return
+			case <-ticker.C:
+				// Drop ticks for slow receivers, as time.Ticker does.
+				select {
+				case t.c <- c.Now():
+				default:
+				}
+			}
+		}
+	}()
+
+	return t
+}
+
+// BatchClock generates simulated time as fast as it is consumed. Each ticker
+// keeps its own timeline, starting at the clock's start, that advances by one
+// period per tick received; Now is the latest time any ticker has offered.
+// After returns immediately.
+type BatchClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewBatchClock(start time.Time) *BatchClock {
+	return &BatchClock{now: start}
+}
+
+func (c *BatchClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *BatchClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	ch <- c.Now()
+	return ch
+}
+
+func (c *BatchClock) advance(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t.After(c.now) {
+		c.now = t
+	}
+}
+
+func (c *BatchClock) NewTicker(d time.Duration) Ticker {
+	t := &forwardingTicker{c: make(chan time.Time), done: make(chan struct{})}
+	next := c.Now()
+
+	go func() {
+		for {
+			next = next.Add(d)
+			c.advance(next)
+			select {
+			case <-t.done:
+				return
+			case t.c <- next:
+			}
+		}
+	}()
+
+	return t
+}
+
+type forwardingTicker struct {
+	c    chan time.Time
+	done chan struct{}
+	stop func()
+	once sync.Once
+}
+
+func (t *forwardingTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *forwardingTicker) Stop() {
+	t.once.Do(func() {
+		close(t.done)
+		if t.stop != nil {
+			t.stop()
+		}
+	})
+}
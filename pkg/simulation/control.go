@@ -0,0 +1,169 @@
+package simulation
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"arhm/ecg-monitoring/pkg/ecg"
+)
+
+const (
+	EventPVC     = "pvc"     // One premature ventricular beat
+	EventPAC     = "pac"     // One premature atrial beat
+	EventEpisode = "episode" // Condition for Duration, then back to the timeline
+)
+
+// Event is a one-off change injected into a running simulation.
+type Event struct {
+	Type      string    `json:"type"`
+	Condition Condition `json:"condition,omitempty"`
+	Duration  Duration  `json:"duration,omitempty"`
+}
+
+func (e Event) Validate() error {
+	switch e.Type {
+	case EventPVC, EventPAC:
+		return nil
+	case EventEpisode:
+		if _, err := ParseCondition(string(e.Condition)); err != nil {
+			return err
+		}
+		if e.Duration <= 0 {
+			return errors.New("episode duration must be positive")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown event type %q (expected pvc, pac or episode)", e.Type)
+	}
+}
+
+// ControllerStatus describes what a controller is currently simulating.
+type ControllerStatus struct {
+	PatientID     string    `json:"patient_id"`
+	Condition     Condition `json:"condition"`
+	Forced        Condition `json:"forced,omitempty"`
+	Episode       Condition `json:"episode,omitempty"`
+	Paused        bool      `json:"paused"`
+	BaseHeartRate int       `json:"base_heart_rate"`
+}
+
+// effectiveCondition applies the runtime overrides to the timeline: an
+// injected episode wins over a forced condition, which wins over the timeline.
+func (c *Controller) effectiveCondition() Condition {
+	switch {
+	case c.episodeRemaining > 0:
+		return c.episode
+	case c.forced != "":
+		return c.forced
+	default:
+		return c.CurrentCondition()
+	}
+}
+
+func (c *Controller) Status() ControllerStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	status := ControllerStatus{
+		PatientID:     c.Patient.ID,
+		Condition:     c.effectiveCondition(),
+		Forced:        c.forced,
+		Paused:        c.paused,
+		BaseHeartRate: c.Patient.BaseHeartRate,
+	}
+	if c.episodeRemaining > 0 {
+		status.Episode = c.episode
+	}
+	return status
+}
+
+// ForceCondition simulates condition until ClearCondition is called. The
+// timeline keeps advancing underneath.
+func (c *Controller) ForceCondition(condition Condition) error {
+	if _, err := ParseCondition(string(condition)); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.forced = condition
+	return nil
+}
+
+func (c *Controller) ClearCondition() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.forced = ""
+}
+
+// SetParameters applies the parameters to the patient. With a timeline they
+// become the base that each step's own parameters override.
+func (c *Controller) SetParameters(parameters PatientParameters) error {
+	if err := parameters.Validate(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Patient = parameters.Apply(c.Patient)
+	c.BasePatient = parameters.Apply(c.BasePatient)
+	return nil
+}
+
+func (c *Controller) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.paused {
+		c.paused = true
+		c.resumed = make(chan struct{})
+	}
+}
+
+func (c *Controller) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paused {
+		c.paused = false
+		close(c.resumed)
+	}
+}
+
+// waitResumed returns a channel closed on Resume while the controller is
+// paused, and nil otherwise.
+func (c *Controller) waitResumed() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.paused {
+		return nil
+	}
+	return c.resumed
+}
+
+func (c *Controller) Paused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+// InjectEvent applies a one-off event. Premature beats only appear during
+// sinus rhythm and wait for it otherwise.
+func (c *Controller) InjectEvent(event Event) error {
+	if err := event.Validate(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch event.Type {
+	case EventPVC:
+		c.ectopySequencer().inject(ecg.BeatPVC)
+	case EventPAC:
+		c.ectopySequencer().inject(ecg.BeatPAC)
+	case EventEpisode:
+		c.episode = event.Condition
+		c.episodeRemaining = c.durationTicks(time.Duration(event.Duration))
+	}
+	return nil
+}
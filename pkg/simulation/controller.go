@@ -2,6 +2,10 @@ package simulation
 
 import (
 	"arhm/ecg-monitoring/pkg/ecg"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
 	"time"
 )
 
@@ -12,32 +16,270 @@ const (
 	ConditionTachycardia Condition = "tachycardia"
 	ConditionBradycardia Condition = "bradycardia"
 	ConditionArrhythmia  Condition = "arrhythmia"
+
+	ConditionAtrialFibrillation Condition = "atrial_fibrillation"
+	// Alternates between AF episodes and sinus rhythm. Readings are reported
+	// as ConditionAtrialFibrillation or ConditionNormal depending on the episode.
+	ConditionParoxysmalAF Condition = "paroxysmal_af"
+
+	ConditionVentricularTachycardia  Condition = "ventricular_tachycardia"
+	ConditionVentricularFibrillation Condition = "ventricular_fibrillation"
+	ConditionAsystole                Condition = "asystole"
 )
 
+var knownConditions = []Condition{
+	ConditionNormal,
+	ConditionTachycardia,
+	ConditionBradycardia,
+	ConditionArrhythmia,
+	ConditionAtrialFibrillation,
+	ConditionParoxysmalAF,
+	ConditionVentricularTachycardia,
+	ConditionVentricularFibrillation,
+	ConditionAsystole,
+}
+
+func ParseCondition(s string) (Condition, error) {
+	for _, condition := range knownConditions {
+		if string(condition) == s {
+			return condition, nil
+		}
+	}
+	return "", fmt.Errorf("unknown condition %q (expected one of %v)", s, knownConditions)
+}
+
+// Expected returns the ecg condition AnalyzeReading should report for a
+// reading simulated under the condition. AnalyzeReading has no AF rhythm, so
+// AF is expected as an arrhythmia.
+func (c Condition) Expected() string {
+	switch c {
+	case ConditionTachycardia:
+		return ecg.ConditionTachycardia
+	case ConditionBradycardia:
+		return ecg.ConditionBradycardia
+	case ConditionArrhythmia, ConditionAtrialFibrillation, ConditionParoxysmalAF:
+		return ecg.ConditionArrhythmia
+	case ConditionVentricularTachycardia:
+		return ecg.ConditionVentricularTachycardia
+	case ConditionVentricularFibrillation:
+		return ecg.ConditionVentricularFibrillation
+	case ConditionAsystole:
+		return ecg.ConditionAsystole
+	default:
+		return ecg.ConditionNormal
+	}
+}
+
+// Step is one entry of a scripted timeline. Next is the index of the step that
+// follows it, or -1 to stay on this step once its duration has elapsed.
+type Step struct {
+	Name       string
+	Condition  Condition
+	Ticks      int
+	Parameters PatientParameters
+	Next       int
+	Transition *Transition // Overrides the controller's Transition while in this step
+}
+
 type Controller struct {
 	Patient         SimulatedPatient
 	SimulationCycle []Condition
 	CycleIndex      int
 	CycleTime       int
 	CycleLength     int
+
+	// When Steps is set it replaces SimulationCycle and CycleLength, and each
+	// step's parameters are applied on top of BasePatient.
+	Steps       []Step
+	BasePatient SimulatedPatient
+
+	// When Markov is set conditions follow the chain instead, CycleIndex is
+	// the current state and each state's parameters are applied on top of
+	// BasePatient.
+	Markov      *MarkovChain
+	markovTicks int
+
+	// Time represented by one reading, used to convert episode durations.
+	Interval time.Duration
+
+	// Stamps and paces readings; nil uses the real clock.
+	Clock Clock
+
+	// Shared by the controller and its patient when set, see Seed.
+	Rand RandomSource
+
+	// How the heart rate moves between conditions; the zero value switches abruptly.
+	Transition Transition
+	transition transitionState
+
+	hrv    *HRVGenerator
+	ectopy *ectopySequencer
+	vitals *vitalsState
+	faults []faultState
+
+	// Simulated time since the first reading, for the patient's daily profile.
+	elapsed time.Duration
+
+	// Simulated time of the last HRV sample in seconds, so that readings and
+	// the beats drawn between them advance the HRV model only once.
+	hrvTime float64
+
+	// Twelve-lead waveform attached to each reading when set, see SetWaveform.
+	waveform *WaveformGenerator
+
+	// Individual beats attached to each reading when set, see SetBeats.
+	// Without a waveform, beatPeak is the offset of the next R peak from the
+	// start of the interval and the pending beat is the one placed there.
+	beats       bool
+	beatReading ecg.ECGReading
+	beatFresh   bool
+	beatPending bool
+	beatPeak    time.Duration
+	beatType    ecg.BeatType
+	beatRR      float64
+
+	afEpisode   bool
+	afRemaining int
+
+	// Ground truth episode: the condition of the last reading and its
+	// episode number.
+	truthCondition Condition
+	truthEpisode   int
+
+	// Runtime overrides, see control.go. mu guards the controller while it
+	// is running.
+	mu               sync.Mutex
+	paused           bool
+	resumed          chan struct{} // Closed by Resume, for runs waiting while paused
+	forced           Condition
+	episode          Condition
+	episodeRemaining int
 }
 
 func NewController() *Controller {
+	return NewPatientController(NewDefaultPatient())
+}
+
+func NewPatientController(patient SimulatedPatient) *Controller {
 	return &Controller{
-		Patient:         NewDefaultPatient(),
+		Patient:         patient,
 		SimulationCycle: []Condition{ConditionNormal, ConditionTachycardia, ConditionNormal, ConditionBradycardia, ConditionNormal, ConditionArrhythmia},
 		CycleIndex:      0,
 		CycleTime:       0,
 		CycleLength:     3,
+		Interval:        time.Second,
+	}
+}
+
+func NewStepController(patient SimulatedPatient, steps []Step) *Controller {
+	return &Controller{
+		Patient:     patient,
+		BasePatient: patient,
+		Steps:       steps,
+		Interval:    time.Second,
+	}
+}
+
+func NewMarkovController(patient SimulatedPatient, chain MarkovChain) *Controller {
+	controller := NewPatientController(patient)
+	controller.SetMarkov(chain)
+	return controller
+}
+
+// SetMarkov switches the controller to the Markov chain, starting in its
+// initial state with the current patient as the base patient.
+func (c *Controller) SetMarkov(chain MarkovChain) {
+	c.Markov = &chain
+	c.BasePatient = c.Patient
+	c.CycleIndex = chain.initialIndex()
+	c.CycleTime = 0
+	c.markovTicks = 0
+}
+
+// Seed makes the controller's readings reproducible: the same seed yields the
+// same sequence of readings.
+func (c *Controller) Seed(seed int64) {
+	c.Rand = NewRandomSource(seed)
+	c.Patient.Rand = c.Rand
+}
+
+func (c *Controller) clock() Clock {
+	if c.Clock == nil {
+		return RealClock{}
+	}
+	return c.Clock
+}
+
+func (c *Controller) CurrentCondition() Condition {
+	if c.Markov != nil {
+		return c.Markov.States[c.CycleIndex].Condition
+	}
+	if len(c.Steps) > 0 {
+		return c.Steps[c.CycleIndex].Condition
 	}
+	return c.SimulationCycle[c.CycleIndex]
 }
 
+// TimeOfDay is the simulated time of day of the next reading when the patient
+// has a daily profile.
+func (c *Controller) TimeOfDay() (time.Duration, bool) {
+	if c.Patient.Profile == nil {
+		return 0, false
+	}
+	return c.Patient.Profile.TimeOfDay(c.elapsed), true
+}
+
+// NextReading generates the next reading, stamped with the clock's current
+// time. Duplicate and dropout faults only take effect in RunWithCallback.
 func (c *Controller) NextReading() (ecg.ECGReading, Condition) {
-	currentCondition := c.SimulationCycle[c.CycleIndex]
+	reading, condition, _ := c.nextReadingAt(c.clock().Now())
+	return reading, condition
+}
+
+// nextReadingAt generates the reading stamped with timestamp and returns how
+// many times it is to be delivered.
+func (c *Controller) nextReadingAt(timestamp time.Time) (ecg.ECGReading, Condition, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	currentCondition := c.effectiveCondition()
+	if c.episodeRemaining > 0 {
+		c.episodeRemaining--
+	}
+
+	switch {
+	case c.Markov != nil:
+		c.Patient = c.Markov.States[c.CycleIndex].Parameters.Apply(c.BasePatient)
+	case len(c.Steps) > 0:
+		c.Patient = c.Steps[c.CycleIndex].Parameters.Apply(c.BasePatient)
+	}
+	if c.Rand != nil {
+		c.Patient.Rand = c.Rand
+	}
+	if c.Patient.Profile != nil {
+		c.Patient = c.Patient.Profile.modulate(c.Patient, c.Patient.Profile.TimeOfDay(c.elapsed))
+	}
+	t := c.elapsed.Seconds()
+	c.elapsed += c.Interval
 
 	c.Patient.SimulateTachycardia = false
 	c.Patient.SimulateBradycardia = false
 	c.Patient.SimulateArrhythmia = false
+	c.Patient.SimulateAtrialFibrillation = false
+	c.Patient.SimulateVentricularTachycardia = false
+	c.Patient.SimulateVentricularFibrillation = false
+	c.Patient.SimulateAsystole = false
+
+	if currentCondition == ConditionParoxysmalAF {
+		currentCondition = c.paroxysmalAFCondition()
+	} else {
+		c.afEpisode = false
+		c.afRemaining = 0
+	}
+	if c.truthEpisode == 0 || currentCondition != c.truthCondition {
+		c.truthCondition = currentCondition
+		c.truthEpisode++
+	}
 
 	switch currentCondition {
 	case ConditionTachycardia:
@@ -46,16 +288,329 @@ func (c *Controller) NextReading() (ecg.ECGReading, Condition) {
 		c.Patient.SimulateBradycardia = true
 	case ConditionArrhythmia:
 		c.Patient.SimulateArrhythmia = true
+	case ConditionAtrialFibrillation:
+		c.Patient.SimulateAtrialFibrillation = true
+	case ConditionVentricularTachycardia:
+		c.Patient.SimulateVentricularTachycardia = true
+	case ConditionVentricularFibrillation:
+		c.Patient.SimulateVentricularFibrillation = true
+	case ConditionAsystole:
+		c.Patient.SimulateAsystole = true
 	}
 
 	reading := GenerateECGReading(c.Patient)
+	reading.Timestamp = timestamp
+	if c.Patient.HRV != nil && c.Patient.sinusRhythm() {
+		reading = c.applyHRV(reading, t)
+	}
+	reading = c.applyTransition(reading)
+	if (c.Patient.Ectopy != nil || c.ectopy.busy()) && c.Patient.sinusRhythm() {
+		reading = c.applyEctopy(reading)
+	}
+
+	if c.Patient.Vitals != nil {
+		if c.vitals == nil {
+			c.vitals = newVitalsState(c.Patient)
+		}
+		reading.Vitals = c.vitals.next(c.Patient, reading.HeartRate, c.Interval)
+	}
+	if c.Patient.Demographics != nil {
+		demographics := *c.Patient.Demographics
+		reading.Demographics = &demographics
+	}
+	reading.Truth = &ecg.GroundTruth{
+		Condition: string(currentCondition),
+		Expected:  currentCondition.Expected(),
+		EpisodeID: c.truthEpisode,
+	}
+
+	c.beatReading = reading
+	c.beatFresh = true
+	switch {
+	case c.waveform != nil:
+		reading = c.attachWaveform(reading, timestamp)
+	case c.beats:
+		reading.Beats = c.intervalBeats(timestamp)
+	}
+
+	deliveries := 1
+	if len(c.Patient.Faults) > 0 || len(c.faults) > 0 {
+		reading, deliveries = c.applyFaults(reading, t)
+	}
 
 	c.advanceCycle()
 
-	return reading, currentCondition
+	return reading, currentCondition, deliveries
+}
+
+// paroxysmalAFCondition starts with an AF episode and then alternates between
+// AF and sinus rhythm, with exponentially distributed episode lengths.
+func (c *Controller) paroxysmalAFCondition() Condition {
+	if c.afRemaining <= 0 {
+		c.afEpisode = !c.afEpisode
+
+		mean := c.Patient.AFSinusMean
+		if c.afEpisode {
+			mean = c.Patient.AFEpisodeMean
+		}
+		c.afRemaining = c.episodeTicks(mean)
+	}
+	c.afRemaining--
+
+	if c.afEpisode {
+		return ConditionAtrialFibrillation
+	}
+	return ConditionNormal
+}
+
+func (c *Controller) episodeTicks(mean time.Duration) int {
+	return c.durationTicks(time.Duration(c.Patient.random().ExpFloat64() * float64(mean)))
+}
+
+func (c *Controller) durationTicks(duration time.Duration) int {
+	ticks := int(math.Round(float64(duration) / float64(c.Interval)))
+	if ticks < 1 {
+		ticks = 1
+	}
+	return ticks
+}
+
+// applyHRV replaces the reading's uniform jitter with the HRV model, sampled
+// at simulated time at in seconds. A beat drawn past the next reading's time
+// leaves the model where it is for that reading.
+func (c *Controller) applyHRV(reading ecg.ECGReading, at float64) ecg.ECGReading {
+	if c.hrv == nil || c.hrv.Parameters != *c.Patient.HRV {
+		c.hrv = NewHRVGenerator(*c.Patient.HRV, c.Patient.random())
+	}
+
+	dt := max(at-c.hrvTime, 0)
+	c.hrvTime += dt
+
+	meanRR := 60.0 / meanHeartRate(c.Patient)
+	rr := math.Max(meanRR+c.hrv.Offset(dt)*c.Patient.variabilityFactor(), 0.25)
+
+	reading.RRInterval = rr
+	reading.HeartRate = int(math.Round(60.0 / rr))
+	return reading
+}
+
+// applyEctopy treats each reading as one beat of the ectopy sequence and labels
+// it with its beat type.
+func (c *Controller) applyEctopy(reading ecg.ECGReading) ecg.ECGReading {
+	var parameters EctopyParameters
+	if c.Patient.Ectopy != nil {
+		parameters = *c.Patient.Ectopy
+	}
+	c.ectopySequencer().parameters = parameters
+
+	beatType, rr := c.ectopy.next(reading.RRInterval)
+
+	reading.BeatType = beatType
+	reading.RRInterval = rr
+	reading.HeartRate = int(math.Round(60.0 / rr))
+	return reading
+}
+
+func (c *Controller) ectopySequencer() *ectopySequencer {
+	if c.ectopy == nil {
+		c.ectopy = newEctopySequencer(EctopyParameters{}, c.Patient.random())
+	}
+	return c.ectopy
+}
+
+// SetWaveform attaches twelve leads sampled at sampleRate to every reading, or
+// stops doing so when sampleRate is zero.
+func (c *Controller) SetWaveform(sampleRate int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if sampleRate == 0 {
+		c.waveform = nil
+		return nil
+	}
+
+	generator, err := NewWaveformGenerator(c.Patient, sampleRate)
+	if err != nil {
+		return err
+	}
+	generator.rhythm = c.beatRhythm
+	c.waveform = generator
+	return nil
+}
+
+// SetBeats attaches every beat of the interval to each reading, or stops
+// doing so.
+func (c *Controller) SetBeats(enabled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.beats = enabled
+}
+
+// attachWaveform generates the waveform of the interval that ends at
+// timestamp, and its beats when they are enabled.
+func (c *Controller) attachWaveform(reading ecg.ECGReading, timestamp time.Time) ecg.ECGReading {
+	c.waveform.Patient = c.Patient
+	first := c.waveform.samples
+	samples, beats := c.waveform.GenerateLeads(c.Interval, ecg.StandardLeads)
+
+	reading.SampleRate = c.waveform.SampleRate
+	reading.SignalQuality = c.waveform.SignalQuality()
+	reading.Leads = make(map[ecg.Lead]ecg.Samples, len(ecg.StandardLeads))
+	for i, lead := range ecg.StandardLeads {
+		reading.Leads[lead] = samples[i]
+	}
+
+	if !c.beats {
+		return reading
+	}
+
+	start := timestamp.Add(-c.Interval)
+	offset := time.Duration(float64(first) / float64(c.waveform.SampleRate) * float64(time.Second))
+	leadII := samples[slices.Index(ecg.StandardLeads, ecg.LeadII)]
+
+	for _, beat := range beats {
+		peak := beat.Time - offset
+		index := min(int(math.Round(peak.Seconds()*float64(c.waveform.SampleRate))), len(leadII)-1)
+		reading.Beats = append(reading.Beats, ecg.Beat{
+			PatientID:  c.Patient.ID,
+			Timestamp:  start.Add(peak),
+			RRInterval: beat.RR,
+			BeatType:   beat.Type,
+			Morphology: &ecg.BeatMorphology{
+				QRSDuration: c.waveform.qrsDuration(beat.Type, beat.RR),
+				RAmplitude:  leadII[index],
+			},
+		})
+	}
+	return reading
+}
+
+// intervalBeats places the beats of the interval that ends at timestamp when
+// there is no waveform to take them from.
+func (c *Controller) intervalBeats(timestamp time.Time) []ecg.Beat {
+	// As in the waveform, there are no beats without organised ventricular
+	// activity.
+	if c.Patient.SimulateVentricularFibrillation || c.Patient.SimulateAsystole {
+		c.beatPending = false
+		c.beatPeak = 0
+		return nil
+	}
+
+	start := timestamp.Add(-c.Interval)
+
+	var beats []ecg.Beat
+	for {
+		if !c.beatPending {
+			c.beatType, _ = c.beatRhythm()
+			c.beatPeak += time.Duration(c.beatRR * float64(time.Second))
+			c.beatPending = true
+		}
+		if c.beatPeak >= c.Interval {
+			break
+		}
+
+		beats = append(beats, ecg.Beat{
+			PatientID:  c.Patient.ID,
+			Timestamp:  start.Add(c.beatPeak),
+			RRInterval: c.beatRR,
+			BeatType:   c.beatType,
+		})
+		c.beatPending = false
+	}
+	c.beatPeak -= c.Interval
+
+	return beats
+}
+
+// beatRhythm supplies the type and RR interval of each beat of the waveform
+// and the beat stream. The first beat of an interval follows the reading, and
+// further beats are drawn from the patient model so that HRV, AF and ectopy
+// act beat by beat.
+func (c *Controller) beatRhythm() (ecg.BeatType, float64) {
+	beat := c.beatReading
+	if c.beatFresh {
+		c.beatFresh = false
+	} else if !c.Patient.SimulateVentricularTachycardia {
+		beat = c.drawBeat()
+	}
+
+	beatType := beat.BeatType
+	if beatType == "" {
+		beatType = ecg.BeatNormal
+		if c.Patient.SimulateVentricularTachycardia {
+			beatType = ecg.BeatVentricular
+		}
+	}
+
+	rr := beat.RRInterval
+	if rr <= 0 {
+		rr = 60.0 / meanHeartRate(c.Patient)
+	}
+	c.beatRR = rr
+	return beatType, rr
+}
+
+// drawBeat generates a beat within the interval the way readings are
+// generated, except that the heart rate transition is not advanced.
+func (c *Controller) drawBeat() ecg.ECGReading {
+	beat := GenerateECGReading(c.Patient)
+	if c.Patient.HRV != nil && c.Patient.sinusRhythm() {
+		beat = c.applyHRV(beat, c.hrvTime+c.beatRR)
+	}
+	if c.transition.initialized && !c.Patient.lethalRhythm() {
+		beat = shiftHeartRate(beat, meanHeartRate(c.Patient), c.transition.value)
+	}
+	if (c.Patient.Ectopy != nil || c.ectopy.busy()) && c.Patient.sinusRhythm() {
+		beat = c.applyEctopy(beat)
+	}
+	return beat
+}
+
+// applyTransition shifts the reading so that its baseline follows the
+// transition model instead of jumping to the new condition's mean, keeping the
+// reading's own variability.
+func (c *Controller) applyTransition(reading ecg.ECGReading) ecg.ECGReading {
+	transition := c.Transition
+	if c.Markov == nil && len(c.Steps) > 0 && c.Steps[c.CycleIndex].Transition != nil {
+		transition = *c.Steps[c.CycleIndex].Transition
+	}
+
+	// Lethal rhythms start abruptly, and recovery restarts from the new
+	// condition's baseline.
+	if c.Patient.lethalRhythm() {
+		c.transition = transitionState{}
+		return reading
+	}
+
+	target := meanHeartRate(c.Patient)
+	baseline := c.transition.step(target, transition, c.Interval)
+
+	return shiftHeartRate(reading, target, baseline)
+}
+
+func shiftHeartRate(reading ecg.ECGReading, target, baseline float64) ecg.ECGReading {
+	if reading.HeartRate <= 0 {
+		return reading
+	}
+
+	heartRate := math.Max(float64(reading.HeartRate)-target+baseline, 1)
+	reading.RRInterval *= float64(reading.HeartRate) / heartRate
+	reading.HeartRate = int(math.Round(heartRate))
+
+	return reading
 }
 
 func (c *Controller) advanceCycle() {
+	if c.Markov != nil {
+		c.advanceMarkov()
+		return
+	}
+	if len(c.Steps) > 0 {
+		c.advanceStep()
+		return
+	}
+
 	c.CycleTime++
 	if c.CycleTime >= c.CycleLength {
 		c.CycleTime = 0
@@ -63,13 +618,67 @@ func (c *Controller) advanceCycle() {
 	}
 }
 
-func (c *Controller) RunWithCallback(interval time.Duration, callback func(reading ecg.ECGReading, condition Condition)) *time.Ticker {
-	ticker := time.NewTicker(interval)
+func (c *Controller) advanceStep() {
+	step := c.Steps[c.CycleIndex]
+
+	c.CycleTime++
+	if c.CycleTime >= step.Ticks {
+		c.CycleTime = 0
+		if step.Next >= 0 {
+			c.CycleIndex = step.Next
+		}
+	}
+}
+
+// advanceMarkov draws the dwell time of each state on entry and moves to the
+// next state once it has elapsed.
+func (c *Controller) advanceMarkov() {
+	if c.markovTicks == 0 {
+		c.markovTicks = c.durationTicks(c.Markov.States[c.CycleIndex].Dwell.sample(c.Patient.random()))
+	}
+
+	c.CycleTime++
+	if c.CycleTime >= c.markovTicks {
+		c.CycleTime = 0
+		c.markovTicks = 0
+		c.CycleIndex = c.Markov.next(c.CycleIndex, c.Patient.random())
+	}
+}
+
+// RunWithCallback delivers a reading every interval of the controller's clock
+// until stopped. No readings are delivered while the controller is paused.
+func (c *Controller) RunWithCallback(interval time.Duration, callback func(reading ecg.ECGReading, condition Condition)) Stopper {
+	c.mu.Lock()
+	c.Interval = interval
+	c.mu.Unlock()
+
+	clockTicker := c.clock().NewTicker(interval)
+	ticker := &forwardingTicker{done: make(chan struct{}), stop: clockTicker.Stop}
 
 	go func() {
-		for range ticker.C {
-			reading, condition := c.NextReading()
-			callback(reading, condition)
+		for {
+			// Ticks are not taken while paused, so that a BatchClock does
+			// not run on with nothing delivered.
+			if resumed := c.waitResumed(); resumed != nil {
+				select {
+				case <-ticker.done:
+					return
+				case <-resumed:
+				}
+			}
+
+			select {
+			case <-ticker.done:
+				return
+			case now := <-clockTicker.C():
+				if c.Paused() {
+					continue
+				}
+				reading, condition, deliveries := c.nextReadingAt(now)
+				for i := 0; i < deliveries; i++ {
+					callback(reading, condition)
+				}
+			}
 		}
 	}()
 
@@ -0,0 +1,207 @@
+package simulation
+
+import (
+	"fmt"
+	"math"
+
+	"arhm/ecg-monitoring/pkg/ecg"
+)
+
+const (
+	PatternIsolated  = "isolated"
+	PatternBigeminy  = "bigeminy"  // Every second beat is ectopic
+	PatternTrigeminy = "trigeminy" // Every third beat is ectopic
+	PatternCouplet   = "couplet"   // Ectopic beats occur in pairs
+)
+
+// EctopicFocus configures one source of premature beats. Rate is the number of
+// ectopic events per minute for the isolated and couplet patterns; bigeminy
+// and trigeminy fix the ratio of ectopic to sinus beats instead.
+type EctopicFocus struct {
+	Rate    float64 `json:"rate"`
+	Pattern string  `json:"pattern"`
+}
+
+func (f EctopicFocus) enabled() bool {
+	switch f.Pattern {
+	case PatternBigeminy, PatternTrigeminy:
+		return true
+	default:
+		return f.Rate > 0
+	}
+}
+
+func (f EctopicFocus) validate() error {
+	switch f.Pattern {
+	case "", PatternIsolated, PatternBigeminy, PatternTrigeminy, PatternCouplet:
+	default:
+		return fmt.Errorf("unknown pattern %q (expected isolated, bigeminy, trigeminy or couplet)", f.Pattern)
+	}
+	if f.Rate < 0 || f.Rate > 30 {
+		return fmt.Errorf("rate %g out of range [0, 30] per minute", f.Rate)
+	}
+	return nil
+}
+
+// EctopyParameters configure premature beats. Coupling intervals are fractions
+// of the underlying sinus RR interval. When both foci use a fixed pattern the
+// PVC pattern wins.
+type EctopyParameters struct {
+	PVC         EctopicFocus `json:"pvc"`
+	PAC         EctopicFocus `json:"pac"`
+	PVCCoupling float64      `json:"pvc_coupling"`
+	PACCoupling float64      `json:"pac_coupling"`
+}
+
+func (p EctopyParameters) Validate() error {
+	if err := p.PVC.validate(); err != nil {
+		return fmt.Errorf("pvc: %w", err)
+	}
+	if err := p.PAC.validate(); err != nil {
+		return fmt.Errorf("pac: %w", err)
+	}
+	if p.PVCCoupling != 0 && (p.PVCCoupling < 0.3 || p.PVCCoupling > 0.9) {
+		return fmt.Errorf("pvc_coupling %g out of range [0.3, 0.9]", p.PVCCoupling)
+	}
+	if p.PACCoupling != 0 && (p.PACCoupling < 0.3 || p.PACCoupling > 0.9) {
+		return fmt.Errorf("pac_coupling %g out of range [0.3, 0.9]", p.PACCoupling)
+	}
+	return nil
+}
+
+func (p EctopyParameters) pvcCoupling() float64 {
+	if p.PVCCoupling == 0 {
+		return 0.6
+	}
+	return p.PVCCoupling
+}
+
+func (p EctopyParameters) pacCoupling() float64 {
+	if p.PACCoupling == 0 {
+		return 0.7
+	}
+	return p.PACCoupling
+}
+
+// ectopySequencer decides the type of each beat and adjusts its RR interval.
+// A PVC does not reset the sinus node, so the beat after it waits for the next
+// sinus beat (full compensatory pause). A PAC resets the sinus node, so the
+// next beat follows one sinus cycle later (non-compensatory pause).
+type ectopySequencer struct {
+	parameters EctopyParameters
+	rng        RandomSource
+
+	sinceEctopic int
+	pending      []ecg.BeatType
+	ectopicRR    float64 // Sum of RR intervals of the current ectopic run
+	lastEctopic  ecg.BeatType
+}
+
+func newEctopySequencer(parameters EctopyParameters, rng RandomSource) *ectopySequencer {
+	return &ectopySequencer{parameters: parameters, rng: rng}
+}
+
+// busy reports whether injected beats or a pause after an ectopic run are
+// still to come.
+func (s *ectopySequencer) busy() bool {
+	return s != nil && (len(s.pending) > 0 || s.ectopicRR > 0)
+}
+
+// inject makes the next beat ectopic, regardless of the configured foci.
+func (s *ectopySequencer) inject(beatType ecg.BeatType) {
+	s.pending = append([]ecg.BeatType{beatType}, s.pending...)
+}
+
+// next returns the type and RR interval of the next beat given the RR
+// interval the sinus node would produce.
+func (s *ectopySequencer) next(sinusRR float64) (ecg.BeatType, float64) {
+	if len(s.pending) == 0 {
+		s.pending = s.schedule(sinusRR)
+	}
+
+	beatType := s.pending[0]
+	s.pending = s.pending[1:]
+
+	switch beatType {
+	case ecg.BeatPVC:
+		s.sinceEctopic = 0
+		s.lastEctopic = ecg.BeatPVC
+		rr := s.parameters.pvcCoupling() * sinusRR
+		s.ectopicRR += rr
+		return beatType, rr
+	case ecg.BeatPAC:
+		s.sinceEctopic = 0
+		s.lastEctopic = ecg.BeatPAC
+		rr := s.parameters.pacCoupling() * sinusRR
+		s.ectopicRR += rr
+		return beatType, rr
+	}
+
+	s.sinceEctopic++
+	rr := sinusRR
+
+	if s.ectopicRR > 0 {
+		if s.lastEctopic == ecg.BeatPVC {
+			// Next sinus beat that falls at least half a cycle after the run.
+			cycles := math.Ceil((s.ectopicRR + 0.5*sinusRR) / sinusRR)
+			rr = cycles*sinusRR - s.ectopicRR
+		} else {
+			rr = sinusRR * 1.05
+		}
+		s.ectopicRR = 0
+	}
+
+	return ecg.BeatNormal, rr
+}
+
+// schedule returns the next group of beats: a single sinus beat, or the
+// ectopic beat(s) that replace it.
+func (s *ectopySequencer) schedule(sinusRR float64) []ecg.BeatType {
+	pvc := s.parameters.PVC
+	pac := s.parameters.PAC
+
+	if ectopic, ok := s.fixedPattern(pvc, ecg.BeatPVC); ok {
+		return ectopic
+	}
+	if ectopic, ok := s.fixedPattern(pac, ecg.BeatPAC); ok {
+		return ectopic
+	}
+
+	// The beat after an ectopic run is always conducted from the sinus node.
+	if s.ectopicRR > 0 {
+		return []ecg.BeatType{ecg.BeatNormal}
+	}
+
+	for _, focus := range []struct {
+		EctopicFocus
+		beatType ecg.BeatType
+	}{{pvc, ecg.BeatPVC}, {pac, ecg.BeatPAC}} {
+		if focus.Rate <= 0 || focus.Pattern == PatternBigeminy || focus.Pattern == PatternTrigeminy {
+			continue
+		}
+		if s.rng.Float64() < focus.Rate*sinusRR/60 {
+			if focus.Pattern == PatternCouplet {
+				return []ecg.BeatType{focus.beatType, focus.beatType}
+			}
+			return []ecg.BeatType{focus.beatType}
+		}
+	}
+
+	return []ecg.BeatType{ecg.BeatNormal}
+}
+
+func (s *ectopySequencer) fixedPattern(focus EctopicFocus, beatType ecg.BeatType) ([]ecg.BeatType, bool) {
+	switch focus.Pattern {
+	case PatternBigeminy:
+		if s.sinceEctopic >= 1 {
+			return []ecg.BeatType{beatType}, true
+		}
+		return []ecg.BeatType{ecg.BeatNormal}, true
+	case PatternTrigeminy:
+		if s.sinceEctopic >= 2 {
+			return []ecg.BeatType{beatType}, true
+		}
+		return []ecg.BeatType{ecg.BeatNormal}, true
+	}
+	return nil, false
+}
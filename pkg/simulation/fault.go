@@ -0,0 +1,136 @@
+package simulation
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"arhm/ecg-monitoring/pkg/ecg"
+)
+
+const (
+	FaultStuckValue         = "stuck_value"
+	FaultInvalidHeartRate   = "invalid_heart_rate"
+	FaultInvalidRR          = "invalid_rr"
+	FaultTimestampBackwards = "timestamp_backwards"
+	FaultDuplicate          = "duplicate"
+	FaultDropout            = "dropout"
+)
+
+// FaultConfig makes the simulated device misbehave, with the same timing
+// fields as ArtifactConfig. Faults change what the controller reports, not
+// the simulated heart: the waveform, beats and conditions are unaffected.
+//
+// While active, stuck_value repeats the values of the reading the fault
+// started on, invalid_heart_rate reports Value as the heart rate (400 BPM
+// when unset), invalid_rr reports Value as the RR interval (NaN when unset),
+// timestamp_backwards stamps readings Offset earlier (one minute when zero),
+// duplicate delivers every reading twice and dropout delivers none.
+type FaultConfig struct {
+	Type     string   `json:"type"`
+	Value    *float64 `json:"value,omitempty"`
+	Offset   Duration `json:"offset"`
+	Start    Duration `json:"start"`
+	Duration Duration `json:"duration"`
+	Period   Duration `json:"period"`
+	Rate     float64  `json:"rate"`
+}
+
+func (f FaultConfig) Validate() error {
+	switch f.Type {
+	case FaultStuckValue, FaultInvalidHeartRate, FaultInvalidRR, FaultTimestampBackwards, FaultDuplicate, FaultDropout:
+	default:
+		return fmt.Errorf("unknown fault type %q", f.Type)
+	}
+
+	if f.Offset < 0 {
+		return fmt.Errorf("%s: offset must not be negative", f.Type)
+	}
+	return validateSchedule(f.Type, f.Start, f.Duration, f.Period, f.Rate)
+}
+
+func (f FaultConfig) equal(other FaultConfig) bool {
+	sameValue := f.Value == other.Value ||
+		(f.Value != nil && other.Value != nil && *f.Value == *other.Value)
+	f.Value, other.Value = nil, nil
+	return sameValue && f == other
+}
+
+type faultState struct {
+	config      FaultConfig
+	activeUntil float64
+	held        *ecg.ECGReading // Values repeated by a stuck sensor
+}
+
+// applyFaults corrupts the reading taken t seconds into the simulation and
+// returns how many times it is to be delivered.
+func (c *Controller) applyFaults(reading ecg.ECGReading, t float64) (ecg.ECGReading, int) {
+	if len(c.faults) != len(c.Patient.Faults) || !allFaults(c.faults, c.Patient.Faults) {
+		c.faults = make([]faultState, len(c.Patient.Faults))
+		for i, config := range c.Patient.Faults {
+			c.faults[i] = faultState{config: config}
+		}
+	}
+
+	duplicate, dropout := false, false
+	for i := range c.faults {
+		f := &c.faults[i]
+		if !scheduled(f.config.Start, f.config.Duration, f.config.Period, f.config.Rate, &f.activeUntil, t, c.Interval.Seconds(), c.Patient.random()) {
+			f.held = nil
+			continue
+		}
+		if reading.Truth != nil {
+			reading.Truth.Faults = append(reading.Truth.Faults, f.config.Type)
+		}
+
+		switch f.config.Type {
+		case FaultStuckValue:
+			if f.held == nil {
+				held := reading
+				f.held = &held
+			}
+			reading.HeartRate = f.held.HeartRate
+			reading.RRInterval = f.held.RRInterval
+			reading.BeatType = f.held.BeatType
+			reading.QRSDuration = f.held.QRSDuration
+		case FaultInvalidHeartRate:
+			reading.HeartRate = 400
+			if f.config.Value != nil {
+				reading.HeartRate = int(*f.config.Value)
+			}
+		case FaultInvalidRR:
+			reading.RRInterval = math.NaN()
+			if f.config.Value != nil {
+				reading.RRInterval = *f.config.Value
+			}
+		case FaultTimestampBackwards:
+			offset := time.Duration(f.config.Offset)
+			if offset == 0 {
+				offset = time.Minute
+			}
+			reading.Timestamp = reading.Timestamp.Add(-offset)
+		case FaultDuplicate:
+			duplicate = true
+		case FaultDropout:
+			dropout = true
+		}
+	}
+
+	switch {
+	case dropout:
+		return reading, 0
+	case duplicate:
+		return reading, 2
+	default:
+		return reading, 1
+	}
+}
+
+func allFaults(states []faultState, configs []FaultConfig) bool {
+	for i := range states {
+		if !states[i].config.equal(configs[i]) {
+			return false
+		}
+	}
+	return true
+}
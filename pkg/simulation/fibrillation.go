@@ -0,0 +1,70 @@
+package simulation
+
+import (
+	"math"
+)
+
+// AV node refractory period; no conducted RR interval in AF is shorter.
+const afRefractoryPeriod = 0.3
+
+// atrialFibrillationRR draws an RR interval for a conducted beat in AF. RR
+// intervals are independent draws from a refractory-shifted gamma
+// distribution, which gives the right-skewed, uncorrelated
+// ("irregularly irregular") sequence seen in AF tachograms.
+func atrialFibrillationRR(patient SimulatedPatient) float64 {
+	meanRR := 60.0 / float64(patient.AFVentricularRate)
+	sd := meanRR * patient.AFIrregularity
+
+	excess := meanRR - afRefractoryPeriod
+	if excess <= 0.01 || sd <= 0 {
+		return math.Max(meanRR, afRefractoryPeriod)
+	}
+
+	shape := excess * excess / (sd * sd)
+	scale := sd * sd / excess
+
+	return afRefractoryPeriod + gammaSample(patient.random(), shape)*scale
+}
+
+// Marsaglia and Tsang's method for gamma variates with unit scale.
+func gammaSample(rng RandomSource, shape float64) float64 {
+	if shape < 1 {
+		return gammaSample(rng, shape+1) * math.Pow(rng.Float64(), 1/shape)
+	}
+
+	d := shape - 1.0/3.0
+	c := 1.0 / math.Sqrt(9*d)
+	for {
+		x := rng.NormFloat64()
+		v := 1 + c*x
+		if v <= 0 {
+			continue
+		}
+		v = v * v * v
+		u := rng.Float64()
+		if math.Log(u) < 0.5*x*x+d-d*v+d*math.Log(v) {
+			return d * v
+		}
+	}
+}
+
+// fibrillatoryWave is the atrial f-wave baseline at time t seconds: a 4-9 Hz
+// oscillation with slowly drifting frequency and amplitude, replacing the P wave.
+func fibrillatoryWave(t float64) float64 {
+	amplitude := 0.05 + 0.02*math.Sin(2*math.Pi*0.23*t)
+	// Integral of an instantaneous frequency of 6 ± 1.5 Hz varying at 0.1 Hz.
+	phase := 2 * math.Pi * (6.0*t - 1.5/(2*math.Pi*0.1)*math.Cos(2*math.Pi*0.1*t))
+
+	return amplitude * (math.Sin(phase) + 0.3*math.Sin(2*phase+1))
+}
+
+// ventricularFibrillationWave is coarse VF at time t seconds: an irregular
+// 4-7 Hz undulation with no identifiable QRS complexes, built from
+// incommensurate components so that it never repeats.
+func ventricularFibrillationWave(t float64) float64 {
+	amplitude := 0.4 + 0.2*math.Sin(2*math.Pi*0.17*t) + 0.1*math.Sin(2*math.Pi*0.41*t)
+	// Integral of an instantaneous frequency of 5.5 ± 1.5 Hz varying at 0.3 Hz.
+	phase := 2 * math.Pi * (5.5*t - 1.5/(2*math.Pi*0.3)*math.Cos(2*math.Pi*0.3*t))
+
+	return amplitude * (math.Sin(phase) + 0.4*math.Sin(1.618*phase+2) + 0.2*math.Sin(2.414*phase))
+}
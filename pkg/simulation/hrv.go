@@ -0,0 +1,128 @@
+package simulation
+
+import (
+	"fmt"
+	"math"
+)
+
+// HRVParameters configure the RR interval variability of sinus rhythm. The
+// total variance (SDNN squared) is split between 1/f noise (PinkFraction) and
+// two narrow-band oscillations: LF Mayer waves around LFFrequency and
+// respiratory sinus arrhythmia at the respiration rate, in the ratio LFHFRatio.
+type HRVParameters struct {
+	SDNN            float64 `json:"sdnn"`             // Seconds
+	LFHFRatio       float64 `json:"lf_hf_ratio"`      // LF power / HF power
+	RespirationRate float64 `json:"respiration_rate"` // Breaths per minute
+	LFFrequency     float64 `json:"lf_frequency"`     // Hz
+	PinkFraction    float64 `json:"pink_fraction"`    // Share of variance from 1/f noise
+}
+
+func NewDefaultHRV() HRVParameters {
+	return HRVParameters{
+		SDNN:            0.05,
+		LFHFRatio:       1.5,
+		RespirationRate: 15,
+		LFFrequency:     0.1,
+		PinkFraction:    0.3,
+	}
+}
+
+func (p HRVParameters) Validate() error {
+	if p.SDNN < 0 || p.SDNN > 0.3 {
+		return fmt.Errorf("sdnn %g out of range [0, 0.3] s", p.SDNN)
+	}
+	if p.LFHFRatio <= 0 {
+		return fmt.Errorf("lf_hf_ratio must be positive, got %g", p.LFHFRatio)
+	}
+	if p.RespirationRate < 4 || p.RespirationRate > 60 {
+		return fmt.Errorf("respiration_rate %g out of range [4, 60] breaths/min", p.RespirationRate)
+	}
+	if p.LFFrequency < 0.04 || p.LFFrequency > 0.15 {
+		return fmt.Errorf("lf_frequency %g out of range [0.04, 0.15] Hz", p.LFFrequency)
+	}
+	if p.PinkFraction < 0 || p.PinkFraction > 1 {
+		return fmt.Errorf("pink_fraction %g out of range [0, 1]", p.PinkFraction)
+	}
+	return nil
+}
+
+// Time constants of the Ornstein-Uhlenbeck processes whose sum approximates a
+// 1/f spectrum between roughly 0.003 and 1 Hz.
+var pinkTimeConstants = []float64{0.25, 1, 4, 16, 64}
+
+// Bandwidth of the LF and HF oscillations.
+const hrvBandwidth = 0.015
+
+// HRVGenerator produces RR interval offsets in seconds. Each call advances the
+// model by dt seconds, so the same generator can be sampled once per beat or
+// at any fixed rate.
+type HRVGenerator struct {
+	Parameters HRVParameters
+
+	rng   RandomSource
+	lf    complex128
+	hf    complex128
+	pink  []float64
+	ready bool
+}
+
+func NewHRVGenerator(parameters HRVParameters, rng RandomSource) *HRVGenerator {
+	if rng == nil {
+		rng = globalSource{}
+	}
+
+	return &HRVGenerator{
+		Parameters: parameters,
+		rng:        rng,
+		pink:       make([]float64, len(pinkTimeConstants)),
+	}
+}
+
+func (g *HRVGenerator) Offset(dt float64) float64 {
+	p := g.Parameters
+	total := p.SDNN * p.SDNN
+	pinkVariance := total * p.PinkFraction
+	lfVariance := (total - pinkVariance) * p.LFHFRatio / (1 + p.LFHFRatio)
+	hfVariance := total - pinkVariance - lfVariance
+
+	if !g.ready {
+		g.lf = g.complexNormal(lfVariance)
+		g.hf = g.complexNormal(hfVariance)
+		for i := range g.pink {
+			g.pink[i] = g.rng.NormFloat64() * math.Sqrt(pinkVariance/float64(len(g.pink)))
+		}
+		g.ready = true
+	} else {
+		g.lf = g.oscillate(g.lf, p.LFFrequency, lfVariance, dt)
+		g.hf = g.oscillate(g.hf, p.RespirationRate/60, hfVariance, dt)
+		for i, tau := range pinkTimeConstants {
+			decay := math.Exp(-dt / tau)
+			sd := math.Sqrt(pinkVariance / float64(len(g.pink)) * (1 - decay*decay))
+			g.pink[i] = g.pink[i]*decay + sd*g.rng.NormFloat64()
+		}
+	}
+
+	offset := real(g.lf) + real(g.hf)
+	for _, x := range g.pink {
+		offset += x
+	}
+	return offset
+}
+
+// oscillate advances a narrow-band process: a complex AR(1) rotating at the
+// centre frequency whose real part has the requested stationary variance.
+func (g *HRVGenerator) oscillate(z complex128, frequency, variance, dt float64) complex128 {
+	gamma := 2 * math.Pi * hrvBandwidth
+	decay := math.Exp(-gamma * dt)
+	angle := 2 * math.Pi * frequency * dt
+
+	z *= complex(decay*math.Cos(angle), decay*math.Sin(angle))
+
+	innovation := g.complexNormal(variance * (1 - decay*decay))
+	return z + innovation
+}
+
+func (g *HRVGenerator) complexNormal(variance float64) complex128 {
+	sd := math.Sqrt(variance)
+	return complex(sd*g.rng.NormFloat64(), sd*g.rng.NormFloat64())
+}
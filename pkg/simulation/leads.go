@@ -0,0 +1,78 @@
+package simulation
+
+import (
+	"math"
+
+	"arhm/ecg-monitoring/pkg/ecg"
+)
+
+// Vector is a cardiac dipole in Frank coordinates (millivolts): X points to
+// the patient's left, Y to the feet and Z to the back.
+type Vector [3]float64
+
+func (v Vector) scale(k float64) Vector {
+	return Vector{v[0] * k, v[1] * k, v[2] * k}
+}
+
+func (v Vector) add(w Vector) Vector {
+	return Vector{v[0] + w[0], v[1] + w[1], v[2] + w[2]}
+}
+
+// rotateFrontal turns the vector in the frontal (X-Y) plane. Positive angles
+// turn it toward the feet, as the cardiac axis is measured.
+func (v Vector) rotateFrontal(angle float64) Vector {
+	sin, cos := math.Sincos(angle)
+	return Vector{v[0]*cos - v[1]*sin, v[0]*sin + v[1]*cos, v[2]}
+}
+
+// DefaultQRSAxis is the frontal QRS axis of the default morphology in degrees.
+const DefaultQRSAxis = 60
+
+// Precordial lead coefficients from Dower's VCG to 12-lead transform.
+var dowerPrecordial = map[ecg.Lead]Vector{
+	ecg.LeadV1: {-0.515, 0.157, -0.917},
+	ecg.LeadV2: {0.044, 0.164, -1.387},
+	ecg.LeadV3: {0.882, 0.098, -1.277},
+	ecg.LeadV4: {1.213, 0.127, -0.601},
+	ecg.LeadV5: {1.125, 0.127, -0.086},
+	ecg.LeadV6: {0.831, 0.076, 0.230},
+}
+
+// Angles of the limb leads in the hexaxial reference system, in degrees.
+var hexaxial = map[ecg.Lead]float64{
+	ecg.LeadI:   0,
+	ecg.LeadII:  60,
+	ecg.LeadIII: 120,
+	ecg.LeadAVR: -150,
+	ecg.LeadAVL: -30,
+	ecg.LeadAVF: 90,
+}
+
+// Goldberger's augmented leads measure one limb against the mean of the
+// other two, which is √3/2 of the projection onto their axis: aVF = II - I/2.
+var augmentedGain = math.Sqrt(3) / 2
+
+// projectLead is the voltage the dipole produces in the lead. Limb leads
+// project its frontal component onto the hexaxial system, so the axis
+// computed from them is exactly the dipole's frontal angle; precordial leads
+// use Dower's coefficients.
+func projectLead(v Vector, lead ecg.Lead) float64 {
+	if angle, ok := hexaxial[lead]; ok {
+		sin, cos := math.Sincos(angle * math.Pi / 180)
+		voltage := v[0]*cos + v[1]*sin
+		if lead == ecg.LeadAVR || lead == ecg.LeadAVL || lead == ecg.LeadAVF {
+			voltage *= augmentedGain
+		}
+		return voltage
+	}
+
+	c := dowerPrecordial[lead]
+	return v[0]*c[0] + v[1]*c[1] + v[2]*c[2]
+}
+
+// Dipoles of the atrial fibrillatory baseline and of ventricular fibrillation,
+// oriented so that lead II shows them at the single-lead amplitude.
+var (
+	fibrillatoryVector = Vector{0.4, 0.9, -0.6}
+	fibrillationVector = Vector{0.5, 0.85, 0.4}
+)
@@ -0,0 +1,216 @@
+package simulation
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	DwellExponential = "exponential"
+	DwellFixed       = "fixed"
+	DwellUniform     = "uniform"
+	DwellGamma       = "gamma"
+)
+
+// DwellTime is the distribution of the time spent in a state before moving
+// on. Exponential (the default) and fixed use Mean, uniform draws between Min
+// and Max, and gamma uses Mean and Shape; larger shapes give more regular
+// dwell times.
+type DwellTime struct {
+	Distribution string   `json:"distribution,omitempty"`
+	Mean         Duration `json:"mean,omitempty"`
+	Min          Duration `json:"min,omitempty"`
+	Max          Duration `json:"max,omitempty"`
+	Shape        float64  `json:"shape,omitempty"`
+}
+
+func (d DwellTime) Validate() error {
+	switch d.Distribution {
+	case "", DwellExponential, DwellFixed:
+		if d.Mean <= 0 {
+			return errors.New("mean must be positive")
+		}
+	case DwellUniform:
+		if d.Min < 0 || d.Max <= d.Min {
+			return errors.New("uniform dwell time needs 0 <= min < max")
+		}
+	case DwellGamma:
+		if d.Mean <= 0 {
+			return errors.New("mean must be positive")
+		}
+		if d.Shape <= 0 {
+			return errors.New("gamma dwell time needs a positive shape")
+		}
+	default:
+		return fmt.Errorf("unknown distribution %q (expected exponential, fixed, uniform or gamma)", d.Distribution)
+	}
+	return nil
+}
+
+func (d DwellTime) sample(rng RandomSource) time.Duration {
+	mean := float64(d.Mean)
+
+	switch d.Distribution {
+	case DwellFixed:
+		return time.Duration(d.Mean)
+	case DwellUniform:
+		return time.Duration(float64(d.Min) + rng.Float64()*float64(d.Max-d.Min))
+	case DwellGamma:
+		return time.Duration(gammaSample(rng, d.Shape) * mean / d.Shape)
+	default:
+		return time.Duration(rng.ExpFloat64() * mean)
+	}
+}
+
+// MarkovState is one condition of a Markov chain. Transitions weights the
+// conditions that can follow it; weights need not sum to one. A state without
+// transitions is absorbing.
+type MarkovState struct {
+	Condition   Condition             `json:"condition"`
+	Dwell       DwellTime             `json:"dwell"`
+	Parameters  PatientParameters     `json:"parameters"`
+	Transitions map[Condition]float64 `json:"transitions"`
+}
+
+// MarkovChain drives a controller through conditions at random. The chain
+// starts in Initial, or in the first state when Initial is empty.
+type MarkovChain struct {
+	Initial Condition     `json:"initial,omitempty"`
+	States  []MarkovState `json:"states"`
+}
+
+// DefaultMarkovChain mostly rests in sinus rhythm, with occasional excursions
+// into the other conditions and rare lethal rhythms that usually recover.
+func DefaultMarkovChain() MarkovChain {
+	minute := Duration(time.Minute)
+	return MarkovChain{
+		States: []MarkovState{
+			{
+				Condition: ConditionNormal,
+				Dwell:     DwellTime{Mean: minute},
+				Transitions: map[Condition]float64{
+					ConditionTachycardia:            0.3,
+					ConditionBradycardia:            0.2,
+					ConditionArrhythmia:             0.2,
+					ConditionParoxysmalAF:           0.15,
+					ConditionVentricularTachycardia: 0.05,
+				},
+			},
+			{
+				Condition:   ConditionTachycardia,
+				Dwell:       DwellTime{Distribution: DwellGamma, Mean: minute / 2, Shape: 3},
+				Transitions: map[Condition]float64{ConditionNormal: 0.8, ConditionArrhythmia: 0.2},
+			},
+			{
+				Condition:   ConditionBradycardia,
+				Dwell:       DwellTime{Distribution: DwellGamma, Mean: minute / 2, Shape: 3},
+				Transitions: map[Condition]float64{ConditionNormal: 1},
+			},
+			{
+				Condition:   ConditionArrhythmia,
+				Dwell:       DwellTime{Mean: Duration(20 * time.Second)},
+				Transitions: map[Condition]float64{ConditionNormal: 0.9, ConditionParoxysmalAF: 0.1},
+			},
+			{
+				Condition:   ConditionParoxysmalAF,
+				Dwell:       DwellTime{Distribution: DwellUniform, Min: minute, Max: 3 * minute},
+				Transitions: map[Condition]float64{ConditionNormal: 1},
+			},
+			{
+				Condition:   ConditionVentricularTachycardia,
+				Dwell:       DwellTime{Distribution: DwellUniform, Min: Duration(5 * time.Second), Max: Duration(20 * time.Second)},
+				Transitions: map[Condition]float64{ConditionNormal: 0.7, ConditionVentricularFibrillation: 0.3},
+			},
+			{
+				Condition:   ConditionVentricularFibrillation,
+				Dwell:       DwellTime{Distribution: DwellFixed, Mean: Duration(15 * time.Second)},
+				Transitions: map[Condition]float64{ConditionNormal: 0.7, ConditionAsystole: 0.3},
+			},
+			{
+				Condition:   ConditionAsystole,
+				Dwell:       DwellTime{Distribution: DwellFixed, Mean: Duration(10 * time.Second)},
+				Transitions: map[Condition]float64{ConditionNormal: 1},
+			},
+		},
+	}
+}
+
+func (m MarkovChain) Validate() error {
+	if len(m.States) == 0 {
+		return errors.New("at least one state is required")
+	}
+
+	for i, state := range m.States {
+		if _, err := ParseCondition(string(state.Condition)); err != nil {
+			return fmt.Errorf("states[%d]: %w", i, err)
+		}
+		if m.index(state.Condition) != i {
+			return fmt.Errorf("states[%d]: duplicate state %q", i, state.Condition)
+		}
+	}
+
+	if m.Initial != "" && m.index(m.Initial) < 0 {
+		return fmt.Errorf("initial refers to unknown state %q", m.Initial)
+	}
+
+	for i, state := range m.States {
+		if err := state.Dwell.Validate(); err != nil {
+			return fmt.Errorf("states[%d] (%s): dwell: %w", i, state.Condition, err)
+		}
+		if err := state.Parameters.Validate(); err != nil {
+			return fmt.Errorf("states[%d] (%s): parameters: %w", i, state.Condition, err)
+		}
+		for target, weight := range state.Transitions {
+			if m.index(target) < 0 {
+				return fmt.Errorf("states[%d] (%s): transition to unknown state %q", i, state.Condition, target)
+			}
+			if weight < 0 || math.IsNaN(weight) {
+				return fmt.Errorf("states[%d] (%s): weight for %q must not be negative", i, state.Condition, target)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m MarkovChain) index(condition Condition) int {
+	for i, state := range m.States {
+		if state.Condition == condition {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m MarkovChain) initialIndex() int {
+	if m.Initial == "" {
+		return 0
+	}
+	return m.index(m.Initial)
+}
+
+// next picks the state following state i. Targets are visited in state order
+// so that seeded runs do not depend on map iteration order.
+func (m MarkovChain) next(i int, rng RandomSource) int {
+	transitions := m.States[i].Transitions
+
+	total := 0.0
+	for _, state := range m.States {
+		total += transitions[state.Condition]
+	}
+	if total <= 0 {
+		return i
+	}
+
+	r := rng.Float64() * total
+	for j, state := range m.States {
+		weight := transitions[state.Condition]
+		if weight > 0 && r < weight {
+			return j
+		}
+		r -= weight
+	}
+	return i
+}
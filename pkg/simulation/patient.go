@@ -1,7 +1,8 @@
 package simulation
 
 import (
-	"math/rand"
+	"fmt"
+	"math"
 	"time"
 
 	"arhm/ecg-monitoring/pkg/ecg"
@@ -13,11 +14,53 @@ type SimulatedPatient struct {
 	Variability   int
 	RRVariability float64
 
-	SimulateArrhythmia  bool
-	SimulateTachycardia bool
-	SimulateBradycardia bool
+	SimulateArrhythmia         bool
+	SimulateTachycardia        bool
+	SimulateBradycardia        bool
+	SimulateAtrialFibrillation bool
+
+	SimulateVentricularTachycardia  bool
+	SimulateVentricularFibrillation bool
+	SimulateAsystole                bool
 
 	ArrhythmiaIntensity float64
+
+	// Age group, sex, training and pacing; nil is an adult. They set the
+	// normal range the simulated conditions are placed against.
+	Demographics *ecg.Demographics
+
+	// Frontal QRS axis of the multi-lead waveform in degrees.
+	QRSAxis float64
+
+	AFVentricularRate int     // Mean ventricular response in AF (BPM)
+	AFIrregularity    float64 // Coefficient of variation of AF RR intervals
+	AFEpisodeMean     time.Duration
+	AFSinusMean       time.Duration
+
+	// Replaces the uniform Variability/RRVariability jitter of sinus rhythm
+	// with a spectral HRV model when set.
+	HRV *HRVParameters
+
+	// Premature beats injected into sinus rhythm when set.
+	Ectopy *EctopyParameters
+
+	// Noise and artifacts added to the simulated waveform.
+	Artifacts []ArtifactConfig
+
+	// Device faults in the reported readings, applied by the controller.
+	Faults []FaultConfig
+
+	// SpO2, respiration and blood pressure simulated with the ECG when set.
+	Vitals *VitalsParameters
+
+	// Time-of-day and activity modulation of the baseline, applied by the
+	// controller.
+	Profile          *DailyProfile
+	rateOffset       float64
+	variabilityScale float64
+
+	// Random source for generated values; nil uses the math/rand globals.
+	Rand RandomSource
 }
 
 func NewDefaultPatient() SimulatedPatient {
@@ -30,34 +73,271 @@ func NewDefaultPatient() SimulatedPatient {
 		SimulateTachycardia: false,
 		SimulateBradycardia: false,
 		ArrhythmiaIntensity: 0.7,
+		QRSAxis:             DefaultQRSAxis,
+		AFVentricularRate:   110,
+		AFIrregularity:      0.2,
+		AFEpisodeMean:       30 * time.Second,
+		AFSinusMean:         30 * time.Second,
+	}
+}
+
+// PatientParameters holds optional overrides for a SimulatedPatient. Nil
+// fields leave the patient's value unchanged.
+type PatientParameters struct {
+	Demographics        *ecg.Demographics `json:"demographics,omitempty"`
+	BaseHeartRate       *int              `json:"base_heart_rate,omitempty"`
+	Variability         *int              `json:"variability,omitempty"`
+	RRVariability       *float64          `json:"rr_variability,omitempty"`
+	ArrhythmiaIntensity *float64          `json:"arrhythmia_intensity,omitempty"`
+	QRSAxis             *float64          `json:"qrs_axis,omitempty"`
+	AFVentricularRate   *int              `json:"af_ventricular_rate,omitempty"`
+	AFIrregularity      *float64          `json:"af_irregularity,omitempty"`
+	AFEpisodeMean       *Duration         `json:"af_episode_mean,omitempty"`
+	AFSinusMean         *Duration         `json:"af_sinus_mean,omitempty"`
+	HRV                 *HRVParameters    `json:"hrv,omitempty"`
+	Ectopy              *EctopyParameters `json:"ectopy,omitempty"`
+	Artifacts           []ArtifactConfig  `json:"artifacts,omitempty"`
+	Faults              []FaultConfig     `json:"faults,omitempty"`
+	Profile             *DailyProfile     `json:"profile,omitempty"`
+	Vitals              *VitalsParameters `json:"vitals,omitempty"`
+}
+
+func (p PatientParameters) Validate() error {
+	if p.Demographics != nil {
+		if err := p.Demographics.Validate(); err != nil {
+			return fmt.Errorf("demographics: %w", err)
+		}
+	}
+	if p.BaseHeartRate != nil && (*p.BaseHeartRate < 20 || *p.BaseHeartRate > 250) {
+		return fmt.Errorf("base_heart_rate %d out of range [20, 250]", *p.BaseHeartRate)
+	}
+	if p.Variability != nil && *p.Variability < 1 {
+		return fmt.Errorf("variability must be at least 1, got %d", *p.Variability)
+	}
+	if p.RRVariability != nil && *p.RRVariability < 0 {
+		return fmt.Errorf("rr_variability must not be negative, got %g", *p.RRVariability)
+	}
+	if p.ArrhythmiaIntensity != nil && (*p.ArrhythmiaIntensity < 0 || *p.ArrhythmiaIntensity > 1) {
+		return fmt.Errorf("arrhythmia_intensity %g out of range [0, 1]", *p.ArrhythmiaIntensity)
+	}
+	if p.QRSAxis != nil && (*p.QRSAxis < -180 || *p.QRSAxis > 180) {
+		return fmt.Errorf("qrs_axis %g out of range [-180, 180]", *p.QRSAxis)
+	}
+	if p.AFVentricularRate != nil && (*p.AFVentricularRate < 40 || *p.AFVentricularRate > 200) {
+		return fmt.Errorf("af_ventricular_rate %d out of range [40, 200]", *p.AFVentricularRate)
+	}
+	if p.AFIrregularity != nil && (*p.AFIrregularity < 0 || *p.AFIrregularity > 0.5) {
+		return fmt.Errorf("af_irregularity %g out of range [0, 0.5]", *p.AFIrregularity)
+	}
+	if p.AFEpisodeMean != nil && *p.AFEpisodeMean <= 0 {
+		return fmt.Errorf("af_episode_mean must be positive")
+	}
+	if p.AFSinusMean != nil && *p.AFSinusMean <= 0 {
+		return fmt.Errorf("af_sinus_mean must be positive")
+	}
+	if p.HRV != nil {
+		if err := p.HRV.Validate(); err != nil {
+			return fmt.Errorf("hrv: %w", err)
+		}
+	}
+	if p.Ectopy != nil {
+		if err := p.Ectopy.Validate(); err != nil {
+			return fmt.Errorf("ectopy: %w", err)
+		}
+	}
+	for i, artifact := range p.Artifacts {
+		if err := artifact.Validate(); err != nil {
+			return fmt.Errorf("artifacts[%d]: %w", i, err)
+		}
+	}
+	for i, fault := range p.Faults {
+		if err := fault.Validate(); err != nil {
+			return fmt.Errorf("faults[%d]: %w", i, err)
+		}
+	}
+	if p.Profile != nil {
+		if err := p.Profile.Validate(); err != nil {
+			return fmt.Errorf("profile: %w", err)
+		}
+	}
+	if p.Vitals != nil {
+		if err := p.Vitals.Validate(); err != nil {
+			return fmt.Errorf("vitals: %w", err)
+		}
+	}
+	return nil
+}
+
+// Apply sets the patient's fields from the non-nil parameters. Demographics
+// also move the base heart rate to the age group's resting rate, unless
+// base_heart_rate is given as well.
+func (p PatientParameters) Apply(patient SimulatedPatient) SimulatedPatient {
+	if p.Demographics != nil {
+		demographics := *p.Demographics
+		patient.Demographics = &demographics
+		patient.BaseHeartRate = demographics.ReferenceRange().RestingHeartRate
+	}
+	if p.BaseHeartRate != nil {
+		patient.BaseHeartRate = *p.BaseHeartRate
+	}
+	if p.Variability != nil {
+		patient.Variability = *p.Variability
+	}
+	if p.RRVariability != nil {
+		patient.RRVariability = *p.RRVariability
+	}
+	if p.ArrhythmiaIntensity != nil {
+		patient.ArrhythmiaIntensity = *p.ArrhythmiaIntensity
+	}
+	if p.QRSAxis != nil {
+		patient.QRSAxis = *p.QRSAxis
+	}
+	if p.AFVentricularRate != nil {
+		patient.AFVentricularRate = *p.AFVentricularRate
+	}
+	if p.AFIrregularity != nil {
+		patient.AFIrregularity = *p.AFIrregularity
+	}
+	if p.AFEpisodeMean != nil {
+		patient.AFEpisodeMean = time.Duration(*p.AFEpisodeMean)
+	}
+	if p.AFSinusMean != nil {
+		patient.AFSinusMean = time.Duration(*p.AFSinusMean)
+	}
+	if p.HRV != nil {
+		hrv := *p.HRV
+		patient.HRV = &hrv
+	}
+	if p.Ectopy != nil {
+		ectopy := *p.Ectopy
+		patient.Ectopy = &ectopy
+	}
+	if p.Artifacts != nil {
+		patient.Artifacts = append([]ArtifactConfig(nil), p.Artifacts...)
+	}
+	if p.Faults != nil {
+		patient.Faults = append([]FaultConfig(nil), p.Faults...)
+	}
+	if p.Profile != nil {
+		profile := *p.Profile
+		patient.Profile = &profile
+	}
+	if p.Vitals != nil {
+		vitals := *p.Vitals
+		patient.Vitals = &vitals
+	}
+	return patient
+}
+
+func (p SimulatedPatient) random() RandomSource {
+	if p.Rand == nil {
+		return globalSource{}
+	}
+	return p.Rand
+}
+
+// baselineHeartRate is BaseHeartRate adjusted by the daily profile.
+func (p SimulatedPatient) baselineHeartRate() float64 {
+	return math.Max(float64(p.BaseHeartRate)+p.rateOffset, 20)
+}
+
+func (p SimulatedPatient) variabilityFactor() float64 {
+	if p.variabilityScale == 0 {
+		return 1
+	}
+	return p.variabilityScale
+}
+
+func (p SimulatedPatient) sinusRhythm() bool {
+	return !p.SimulateArrhythmia && !p.SimulateAtrialFibrillation && !p.lethalRhythm()
+}
+
+func (p SimulatedPatient) referenceRange() ecg.ReferenceRange {
+	if p.Demographics == nil {
+		return ecg.Demographics{}.ReferenceRange()
+	}
+	return p.Demographics.ReferenceRange()
+}
+
+func (p SimulatedPatient) pacemakerRate() int {
+	if p.Demographics == nil {
+		return 0
+	}
+	return p.Demographics.PacemakerRate
+}
+
+func (p SimulatedPatient) lethalRhythm() bool {
+	return p.SimulateVentricularTachycardia || p.SimulateVentricularFibrillation || p.SimulateAsystole
+}
+
+// meanHeartRate is the heart rate GenerateECGReading produces on average for
+// the patient's simulated condition.
+func meanHeartRate(patient SimulatedPatient) float64 {
+	switch {
+	case patient.SimulateTachycardia:
+		return float64(patient.referenceRange().MaxHeartRate + 15)
+	case patient.SimulateBradycardia:
+		return float64(patient.referenceRange().MinHeartRate - 10)
+	case patient.SimulateAtrialFibrillation:
+		return float64(patient.AFVentricularRate)
+	default:
+		return patient.baselineHeartRate()
 	}
 }
 
 func GenerateECGReading(patient SimulatedPatient) ecg.ECGReading {
-	heartRate := patient.BaseHeartRate
+	rng := patient.random()
+	reference := patient.referenceRange()
+	heartRate := int(math.Round(patient.baselineHeartRate()))
 	var rrInterval float64
+	var qrsDuration float64
 
-	if patient.SimulateTachycardia {
-		heartRate = ecg.MaxNormalHeartRate + 1 + rand.Intn(29)
+	if patient.SimulateAsystole {
+		heartRate = 0
+		rrInterval = 0
+	} else if patient.SimulateVentricularFibrillation {
+		heartRate = ecg.MinFibrillationRate + rng.Intn(150)
+		rrInterval = 60.0 / float64(heartRate) * (0.7 + 0.6*rng.Float64())
+	} else if patient.SimulateVentricularTachycardia {
+		heartRate = reference.MinVentricularTachyRate + 30 + rng.Intn(71)
+		rrInterval = 60.0 / float64(heartRate)
+		qrsDuration = 0.14 + 0.06*rng.Float64()
+	} else if patient.SimulateTachycardia {
+		heartRate = reference.MaxHeartRate + 1 + rng.Intn(29)
 		rrInterval = 60.0 / float64(heartRate)
 	} else if patient.SimulateBradycardia {
-		heartRate = ecg.MinNormalHeartRate - 1 - rand.Intn(19)
+		heartRate = reference.MinHeartRate - 1 - rng.Intn(19)
 		rrInterval = 60.0 / float64(heartRate)
+	} else if patient.SimulateAtrialFibrillation {
+		rrInterval = atrialFibrillationRR(patient)
+		heartRate = int(math.Round(60.0 / rrInterval))
 	} else if patient.SimulateArrhythmia {
-		heartRate = patient.BaseHeartRate + rand.Intn(20) - 10
+		heartRate += rng.Intn(20) - 10
 
 		intensity := patient.ArrhythmiaIntensity
 		baseRR := 60.0 / float64(heartRate)
-		rrInterval = baseRR + (rand.Float64()*intensity-intensity/2.0)*baseRR
+		rrInterval = baseRR + (rng.Float64()*intensity-intensity/2.0)*baseRR
 	} else {
-		heartRate += rand.Intn(patient.Variability*2) - patient.Variability
-		rrVariation := (rand.Float64() * patient.RRVariability * 2) - patient.RRVariability
+		variability := max(int(math.Round(float64(patient.Variability)*patient.variabilityFactor())), 1)
+		rrVariability := patient.RRVariability * patient.variabilityFactor()
+		heartRate += rng.Intn(variability*2) - variability
+		rrVariation := (rng.Float64() * rrVariability * 2) - rrVariability
 		rrInterval = 60.0/float64(heartRate) + rrVariation
 	}
 
+	// A pacemaker paces whenever the intrinsic rate drops below its lower
+	// rate limit, but cannot help without organised ventricular activity.
+	if pacing := patient.pacemakerRate(); pacing > 0 && rrInterval > 60.0/float64(pacing) &&
+		!patient.SimulateAsystole && !patient.SimulateVentricularFibrillation {
+		heartRate = pacing
+		rrInterval = 60.0 / float64(pacing)
+	}
+
 	return ecg.ECGReading{
-		Timestamp:  time.Now(),
-		HeartRate:  heartRate,
-		RRInterval: rrInterval,
+		PatientID:   patient.ID,
+		Timestamp:   time.Now(),
+		HeartRate:   heartRate,
+		RRInterval:  rrInterval,
+		QRSDuration: qrsDuration,
 	}
 }
@@ -0,0 +1,136 @@
+package simulation
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+type Activity string
+
+const (
+	ActivitySleep    Activity = "sleep"
+	ActivityRest     Activity = "rest"
+	ActivityWalking  Activity = "walking"
+	ActivityExercise Activity = "exercise"
+)
+
+// Heart rate offset (BPM) and variability scale for each activity. Sleep is
+// vagally dominated, so it slows the heart and widens variability; exertion
+// does the opposite.
+var activityEffects = map[Activity]struct {
+	rateOffset       float64
+	variabilityScale float64
+}{
+	ActivitySleep:    {-12, 1.4},
+	ActivityRest:     {0, 1},
+	ActivityWalking:  {25, 0.7},
+	ActivityExercise: {60, 0.4},
+}
+
+// ActivityPeriod is an activity between two times of day. Periods that end
+// before they start wrap past midnight.
+type ActivityPeriod struct {
+	Activity Activity `json:"activity"`
+	Start    Duration `json:"start"`
+	End      Duration `json:"end"`
+}
+
+func (p ActivityPeriod) contains(timeOfDay time.Duration) bool {
+	start, end := time.Duration(p.Start), time.Duration(p.End)
+	if start <= end {
+		return timeOfDay >= start && timeOfDay < end
+	}
+	return timeOfDay >= start || timeOfDay < end
+}
+
+// DailyProfile modulates a patient's baseline heart rate and variability over
+// a simulated day. The circadian rhythm lowers the rate by CircadianAmplitude
+// BPM at Nadir and raises it by as much twelve hours later; activities add
+// their own offset on top. Outside every period the patient is at rest. Start
+// is the time of day of the first reading.
+type DailyProfile struct {
+	Start              Duration         `json:"start"`
+	CircadianAmplitude float64          `json:"circadian_amplitude"`
+	Nadir              Duration         `json:"nadir"`
+	Activities         []ActivityPeriod `json:"activities"`
+}
+
+// NewDefaultDailyProfile sleeps from 23:00 to 07:00, walks around meals and
+// exercises in the early evening.
+func NewDefaultDailyProfile() DailyProfile {
+	at := func(hours, minutes int) Duration {
+		return Duration(time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute)
+	}
+
+	return DailyProfile{
+		Start:              at(8, 0),
+		CircadianAmplitude: 6,
+		Nadir:              at(4, 0),
+		Activities: []ActivityPeriod{
+			{Activity: ActivitySleep, Start: at(23, 0), End: at(7, 0)},
+			{Activity: ActivityWalking, Start: at(7, 30), End: at(8, 0)},
+			{Activity: ActivityWalking, Start: at(12, 30), End: at(13, 0)},
+			{Activity: ActivityExercise, Start: at(18, 0), End: at(18, 45)},
+			{Activity: ActivityWalking, Start: at(18, 45), End: at(19, 0)},
+		},
+	}
+}
+
+func (p DailyProfile) Validate() error {
+	if p.Start < 0 || time.Duration(p.Start) >= day {
+		return errors.New("start must be a time of day in [0s, 24h)")
+	}
+	if p.Nadir < 0 || time.Duration(p.Nadir) >= day {
+		return errors.New("nadir must be a time of day in [0s, 24h)")
+	}
+	if p.CircadianAmplitude < 0 || p.CircadianAmplitude > 30 {
+		return fmt.Errorf("circadian_amplitude %g out of range [0, 30]", p.CircadianAmplitude)
+	}
+	for i, period := range p.Activities {
+		if _, ok := activityEffects[period.Activity]; !ok {
+			return fmt.Errorf("activities[%d]: unknown activity %q (expected sleep, rest, walking or exercise)", i, period.Activity)
+		}
+		if period.Start < 0 || time.Duration(period.Start) >= day || period.End < 0 || time.Duration(period.End) > day {
+			return fmt.Errorf("activities[%d]: start and end must be times of day", i)
+		}
+		if period.Start == period.End {
+			return fmt.Errorf("activities[%d]: start and end must differ", i)
+		}
+	}
+	return nil
+}
+
+// TimeOfDay is the time of day after elapsed time from the profile's start.
+func (p DailyProfile) TimeOfDay(elapsed time.Duration) time.Duration {
+	return (time.Duration(p.Start) + elapsed%day) % day
+}
+
+// Activity is the activity at the given time of day. Later periods take
+// precedence where periods overlap.
+func (p DailyProfile) Activity(timeOfDay time.Duration) Activity {
+	activity := ActivityRest
+	for _, period := range p.Activities {
+		if period.contains(timeOfDay) {
+			activity = period.Activity
+		}
+	}
+	return activity
+}
+
+func (p DailyProfile) circadianOffset(timeOfDay time.Duration) float64 {
+	phase := 2 * math.Pi * float64(timeOfDay-time.Duration(p.Nadir)) / float64(day)
+	return -p.CircadianAmplitude * math.Cos(phase)
+}
+
+// modulate sets the patient's heart rate offset and variability scale for the
+// given time of day.
+func (p DailyProfile) modulate(patient SimulatedPatient, timeOfDay time.Duration) SimulatedPatient {
+	effect := activityEffects[p.Activity(timeOfDay)]
+	patient.rateOffset = effect.rateOffset + p.circadianOffset(timeOfDay)
+	patient.variabilityScale = effect.variabilityScale
+	return patient
+}
@@ -0,0 +1,27 @@
+package simulation
+
+import (
+	"math/rand"
+)
+
+// RandomSource is the subset of *rand.Rand used by the simulation. Injecting a
+// seeded *rand.Rand makes a run reproducible.
+type RandomSource interface {
+	Intn(n int) int
+	Float64() float64
+	NormFloat64() float64
+	ExpFloat64() float64
+}
+
+func NewRandomSource(seed int64) RandomSource {
+	return rand.New(rand.NewSource(seed))
+}
+
+// globalSource delegates to the math/rand package functions, which are safe
+// for concurrent use but not reproducible.
+type globalSource struct{}
+
+func (globalSource) Intn(n int) int       { return rand.Intn(n) }
+func (globalSource) Float64() float64     { return rand.Float64() }
+func (globalSource) NormFloat64() float64 { return rand.NormFloat64() }
+func (globalSource) ExpFloat64() float64  { return rand.ExpFloat64() }
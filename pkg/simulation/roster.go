@@ -0,0 +1,129 @@
+package simulation
+
+import (
+	"fmt"
+)
+
+type Roster struct {
+	controllers map[string]*Controller
+	order       []string
+}
+
+func NewRoster(count int) *Roster {
+	roster := &Roster{
+		controllers: make(map[string]*Controller),
+	}
+
+	for i := 0; i < count; i++ {
+		patient := NewDefaultPatient()
+		patient.ID = fmt.Sprintf("PATIENT-%d", i+1)
+		patient.BaseHeartRate = 70 + (i*7)%20
+
+		controller := NewPatientController(patient)
+		controller.CycleIndex = i % len(controller.SimulationCycle)
+
+		roster.Add(controller)
+	}
+
+	return roster
+}
+
+// Seed seeds every patient's controller. Patients get distinct seeds derived
+// from the roster seed in roster order.
+func (r *Roster) Seed(seed int64) {
+	for i, id := range r.order {
+		r.controllers[id].Seed(seed + int64(i))
+	}
+}
+
+func (r *Roster) SetTransition(transition Transition) {
+	for _, controller := range r.controllers {
+		controller.Transition = transition
+	}
+}
+
+func (r *Roster) SetClock(clock Clock) {
+	for _, controller := range r.controllers {
+		controller.Clock = clock
+	}
+}
+
+func (r *Roster) SetWaveform(sampleRate int) error {
+	for _, controller := range r.controllers {
+		if err := controller.SetWaveform(sampleRate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetBeats attaches individual beats to every patient's readings, or stops
+// doing so.
+func (r *Roster) SetBeats(enabled bool) {
+	for _, controller := range r.controllers {
+		controller.SetBeats(enabled)
+	}
+}
+
+// SetVitals gives every patient a copy of the vitals parameters.
+func (r *Roster) SetVitals(vitals VitalsParameters) {
+	for _, controller := range r.controllers {
+		patientVitals := vitals
+		controller.Patient.Vitals = &patientVitals
+		controller.BasePatient.Vitals = &patientVitals
+	}
+}
+
+// SetMarkov switches every patient to a copy of the Markov chain.
+func (r *Roster) SetMarkov(chain MarkovChain) {
+	for _, controller := range r.controllers {
+		controller.SetMarkov(chain)
+	}
+}
+
+// SetProfile gives every patient a copy of the daily profile.
+func (r *Roster) SetProfile(profile DailyProfile) {
+	for _, controller := range r.controllers {
+		patientProfile := profile
+		controller.Patient.Profile = &patientProfile
+		controller.BasePatient.Profile = &patientProfile
+	}
+}
+
+func (r *Roster) Add(controller *Controller) error {
+	id := controller.Patient.ID
+	if id == "" {
+		return fmt.Errorf("patient ID must not be empty")
+	}
+
+	if _, exists := r.controllers[id]; exists {
+		return fmt.Errorf("duplicate patient ID %q", id)
+	}
+
+	r.controllers[id] = controller
+	r.order = append(r.order, id)
+	return nil
+}
+
+func (r *Roster) Get(patientID string) (*Controller, bool) {
+	controller, ok := r.controllers[patientID]
+	return controller, ok
+}
+
+func (r *Roster) PatientIDs() []string {
+	ids := make([]string, len(r.order))
+	copy(ids, r.order)
+	return ids
+}
+
+func (r *Roster) Controllers() []*Controller {
+	controllers := make([]*Controller, 0, len(r.order))
+	for _, id := range r.order {
+		controllers = append(controllers, r.controllers[id])
+	}
+	return controllers
+}
+
+func (r *Roster) Len() int {
+	return len(r.order)
+}
@@ -0,0 +1,266 @@
+package simulation
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\" or \"2m\"")
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+type Scenario struct {
+	Name     string            `json:"name"`
+	Seed     *int64            `json:"seed,omitempty"`
+	Patients []PatientScenario `json:"patients"`
+}
+
+type PatientScenario struct {
+	ID         string            `json:"id"`
+	Parameters PatientParameters `json:"parameters"`
+	Loop       bool              `json:"loop"`
+	Transition *TransitionConfig `json:"transition,omitempty"`
+	Steps      []ScenarioStep    `json:"steps"`
+	Markov     *MarkovChain      `json:"markov,omitempty"`
+}
+
+// ScenarioStep is one phase of a patient's timeline. After Duration the
+// timeline moves to the step named by Next, or to the following step when Next
+// is empty. The last step loops back to the first or holds, depending on Loop.
+// Transition, when set, replaces the patient's transition while ramping into
+// this step.
+type ScenarioStep struct {
+	Name       string            `json:"name"`
+	Condition  string            `json:"condition"`
+	Duration   Duration          `json:"duration"`
+	Parameters PatientParameters `json:"parameters"`
+	Transition *TransitionConfig `json:"transition,omitempty"`
+	Next       string            `json:"next"`
+}
+
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	scenario, err := ParseScenario(data)
+	if err != nil {
+		return nil, fmt.Errorf("scenario %s: %w", path, err)
+	}
+
+	return scenario, nil
+}
+
+func ParseScenario(data []byte) (*Scenario, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var scenario Scenario
+	if err := decoder.Decode(&scenario); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	if err := scenario.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &scenario, nil
+}
+
+func (s *Scenario) Validate() error {
+	if len(s.Patients) == 0 {
+		return errors.New("scenario must define at least one patient")
+	}
+
+	seen := make(map[string]bool)
+	for i, patient := range s.Patients {
+		if patient.ID == "" {
+			return fmt.Errorf("patients[%d]: id is required", i)
+		}
+		if seen[patient.ID] {
+			return fmt.Errorf("patients[%d]: duplicate id %q", i, patient.ID)
+		}
+		seen[patient.ID] = true
+
+		if err := patient.validate(); err != nil {
+			return fmt.Errorf("patients[%d] (%s): %w", i, patient.ID, err)
+		}
+	}
+
+	return nil
+}
+
+func (p PatientScenario) validate() error {
+	if err := p.Parameters.Validate(); err != nil {
+		return fmt.Errorf("parameters: %w", err)
+	}
+
+	if p.Transition != nil {
+		if err := p.Transition.Validate(); err != nil {
+			return fmt.Errorf("transition: %w", err)
+		}
+	}
+
+	if p.Markov != nil {
+		if len(p.Steps) > 0 {
+			return errors.New("steps and markov are mutually exclusive")
+		}
+		if err := p.Markov.Validate(); err != nil {
+			return fmt.Errorf("markov: %w", err)
+		}
+		return nil
+	}
+
+	if len(p.Steps) == 0 {
+		return errors.New("at least one step or a markov chain is required")
+	}
+
+	names := make(map[string]bool)
+	for i, step := range p.Steps {
+		if step.Name == "" {
+			continue
+		}
+		if names[step.Name] {
+			return fmt.Errorf("steps[%d]: duplicate step name %q", i, step.Name)
+		}
+		names[step.Name] = true
+	}
+
+	for i, step := range p.Steps {
+		if _, err := ParseCondition(step.Condition); err != nil {
+			return fmt.Errorf("steps[%d]: %w", i, err)
+		}
+		if step.Duration <= 0 {
+			return fmt.Errorf("steps[%d]: duration must be positive", i)
+		}
+		if err := step.Parameters.Validate(); err != nil {
+			return fmt.Errorf("steps[%d]: parameters: %w", i, err)
+		}
+		if step.Transition != nil {
+			if err := step.Transition.Validate(); err != nil {
+				return fmt.Errorf("steps[%d]: transition: %w", i, err)
+			}
+		}
+		if step.Next != "" && !names[step.Next] {
+			return fmt.Errorf("steps[%d]: next refers to unknown step %q", i, step.Next)
+		}
+	}
+
+	return nil
+}
+
+// Controller builds a controller for the patient's timeline, converting step
+// durations into ticks of the given interval.
+func (p PatientScenario) Controller(interval time.Duration) (*Controller, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid tick interval %v", interval)
+	}
+
+	patient := NewDefaultPatient()
+	patient.ID = p.ID
+	patient = p.Parameters.Apply(patient)
+
+	var controller *Controller
+	if p.Markov != nil {
+		controller = NewMarkovController(patient, *p.Markov)
+	} else {
+		steps, err := p.steps(interval)
+		if err != nil {
+			return nil, err
+		}
+		controller = NewStepController(patient, steps)
+	}
+
+	controller.Interval = interval
+	if p.Transition != nil {
+		controller.Transition = p.Transition.Transition()
+	}
+
+	return controller, nil
+}
+
+func (p PatientScenario) steps(interval time.Duration) ([]Step, error) {
+	indexByName := make(map[string]int)
+	for i, step := range p.Steps {
+		if step.Name != "" {
+			indexByName[step.Name] = i
+		}
+	}
+
+	steps := make([]Step, len(p.Steps))
+	for i, scenarioStep := range p.Steps {
+		condition, err := ParseCondition(scenarioStep.Condition)
+		if err != nil {
+			return nil, err
+		}
+
+		ticks := int((time.Duration(scenarioStep.Duration) + interval - 1) / interval)
+
+		next := i + 1
+		switch {
+		case scenarioStep.Next != "":
+			next = indexByName[scenarioStep.Next]
+		case next == len(p.Steps) && p.Loop:
+			next = 0
+		case next == len(p.Steps):
+			next = -1
+		}
+
+		steps[i] = Step{
+			Name:       scenarioStep.Name,
+			Condition:  condition,
+			Ticks:      ticks,
+			Parameters: scenarioStep.Parameters,
+			Next:       next,
+		}
+		if scenarioStep.Transition != nil {
+			transition := scenarioStep.Transition.Transition()
+			steps[i].Transition = &transition
+		}
+	}
+
+	return steps, nil
+}
+
+func (s *Scenario) Roster(interval time.Duration) (*Roster, error) {
+	roster := NewRoster(0)
+
+	for _, patientScenario := range s.Patients {
+		controller, err := patientScenario.Controller(interval)
+		if err != nil {
+			return nil, fmt.Errorf("patient %s: %w", patientScenario.ID, err)
+		}
+
+		if err := roster.Add(controller); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.Seed != nil {
+		roster.Seed(*s.Seed)
+	}
+
+	return roster, nil
+}
@@ -0,0 +1,244 @@
+package simulation_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"arhm/ecg-monitoring/pkg/ecg"
+	"arhm/ecg-monitoring/pkg/simulation"
+)
+
+const artifactRate = simulation.SampleRate500
+
+func artifactWaveform(t *testing.T, artifacts []simulation.ArtifactConfig, duration time.Duration) []float64 {
+	patient := simulation.NewDefaultPatient()
+	patient.Rand = simulation.NewRandomSource(21)
+	patient.Artifacts = artifacts
+
+	generator, err := simulation.NewWaveformGenerator(patient, artifactRate)
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+
+	samples, _ := generator.Generate(duration)
+	return samples
+}
+
+func TestDeterministicArtifacts(t *testing.T) {
+	clean := artifactWaveform(t, nil, 10*time.Second)
+
+	powerline := artifactWaveform(t, []simulation.ArtifactConfig{
+		{Type: simulation.ArtifactPowerline, Amplitude: 0.1, Frequency: 60},
+	}, 10*time.Second)
+
+	for i := range clean {
+		ts := float64(i) / artifactRate
+		expected := 0.1 * math.Sin(2*math.Pi*60*ts)
+		if math.Abs(powerline[i]-clean[i]-expected) > 1e-9 {
+			t.Fatalf("Sample %d: Expected 60 Hz interference %f, got %f", i, expected, powerline[i]-clean[i])
+		}
+	}
+
+	wander := artifactWaveform(t, []simulation.ArtifactConfig{
+		{Type: simulation.ArtifactBaselineWander, Start: simulation.Duration(2 * time.Second), Duration: simulation.Duration(2 * time.Second)},
+	}, 10*time.Second)
+
+	for i := range clean {
+		ts := float64(i) / artifactRate
+		inWindow := ts >= 2 && ts < 4
+		if !inWindow && wander[i] != clean[i] {
+			t.Fatalf("Sample %d at %fs: Unexpected baseline wander outside scheduled window", i, ts)
+		}
+	}
+	if maxAbsDiff(wander[2*artifactRate:4*artifactRate], clean[2*artifactRate:4*artifactRate]) < 0.1 {
+		t.Error("Expected baseline wander inside scheduled window")
+	}
+
+	leadOff := artifactWaveform(t, []simulation.ArtifactConfig{
+		{Type: simulation.ArtifactLeadOff, Start: simulation.Duration(time.Second), Duration: simulation.Duration(time.Second), Period: simulation.Duration(4 * time.Second)},
+	}, 10*time.Second)
+
+	for i := range clean {
+		ts := float64(i) / artifactRate
+		off := ts >= 1 && math.Mod(ts-1, 4) < 1
+		if off && leadOff[i] != 0 {
+			t.Fatalf("Sample %d at %fs: Expected flatline during lead-off, got %f", i, ts, leadOff[i])
+		}
+		if !off && leadOff[i] != clean[i] {
+			t.Fatalf("Sample %d at %fs: Expected clean signal between lead-off periods", i, ts)
+		}
+	}
+}
+
+func TestRandomArtifacts(t *testing.T) {
+	dropped := artifactWaveform(t, []simulation.ArtifactConfig{
+		{Type: simulation.ArtifactDroppedSamples, Amplitude: 0.2},
+	}, 10*time.Second)
+
+	missing := 0
+	for _, v := range dropped {
+		if math.IsNaN(v) {
+			missing++
+		}
+	}
+	fraction := float64(missing) / float64(len(dropped))
+	if fraction < 0.15 || fraction > 0.25 {
+		t.Errorf("Expected about 20%% dropped samples, got %f", fraction)
+	}
+
+	bursts := artifactWaveform(t, []simulation.ArtifactConfig{
+		{Type: simulation.ArtifactLeadOff, Rate: 6, Duration: simulation.Duration(500 * time.Millisecond)},
+	}, 60*time.Second)
+
+	runs := 0
+	for i := 1; i < len(bursts); i++ {
+		if bursts[i] == 0 && bursts[i-1] != 0 && i+artifactRate/4 < len(bursts) && bursts[i+artifactRate/4] == 0 {
+			runs++
+		}
+	}
+	if runs < 2 || runs > 15 {
+		t.Errorf("Expected about 6 random lead-off episodes per minute, got %d", runs)
+	}
+
+	clean := artifactWaveform(t, nil, 10*time.Second)
+	muscle := artifactWaveform(t, []simulation.ArtifactConfig{{Type: simulation.ArtifactMuscle, Amplitude: 0.1}}, 10*time.Second)
+	if roughness(muscle) < 10*roughness(clean) {
+		t.Errorf("Expected broadband muscle noise, roughness %f vs clean %f", roughness(muscle), roughness(clean))
+	}
+
+	motion := artifactWaveform(t, []simulation.ArtifactConfig{{Type: simulation.ArtifactMotion, Amplitude: 1}}, 10*time.Second)
+	if spread(motion) < spread(clean)+0.5 {
+		t.Errorf("Expected large motion excursions, range %f vs clean %f", spread(motion), spread(clean))
+	}
+}
+
+// End to end, an artifact must not be taken for the patient's rhythm: the
+// client's QRS detector and analysis, run as the client does, either see
+// sinus rhythm through it or raise a technical alert.
+func TestArtifactsRaiseNoClinicalAlarm(t *testing.T) {
+	episode := func(config simulation.ArtifactConfig) simulation.ArtifactConfig {
+		config.Start = simulation.Duration(20 * time.Second)
+		config.Duration = simulation.Duration(6 * time.Second)
+		config.Period = simulation.Duration(30 * time.Second)
+		return config
+	}
+
+	tests := []struct {
+		config    simulation.ArtifactConfig
+		technical bool
+	}{
+		{simulation.ArtifactConfig{Type: simulation.ArtifactBaselineWander}, false},
+		{simulation.ArtifactConfig{Type: simulation.ArtifactPowerline}, false},
+		{simulation.ArtifactConfig{Type: simulation.ArtifactMuscle}, false},
+		{episode(simulation.ArtifactConfig{Type: simulation.ArtifactMotion}), true},
+		{episode(simulation.ArtifactConfig{Type: simulation.ArtifactLeadOff}), true},
+		{simulation.ArtifactConfig{Type: simulation.ArtifactDroppedSamples, Amplitude: 0.2}, false},
+	}
+
+	for _, tt := range tests {
+		patient := simulation.NewDefaultPatient()
+		patient.Artifacts = []simulation.ArtifactConfig{tt.config}
+		controller := simulation.NewStepController(patient, []simulation.Step{
+			{Condition: simulation.ConditionNormal, Ticks: 1, Next: -1},
+		})
+		controller.Clock = simulation.NewBatchClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+		controller.Seed(5)
+		if err := controller.SetWaveform(artifactRate); err != nil {
+			t.Fatalf("Failed to enable waveform: %v", err)
+		}
+
+		readings := make(chan ecg.ECGReading)
+		stopper := controller.RunWithCallback(time.Second, func(reading ecg.ECGReading, _ simulation.Condition) {
+			readings <- reading
+		})
+
+		detector, _ := ecg.NewQRSDetector(artifactRate)
+		faults := ecg.NewFaultDetector(time.Second)
+		rhythm := ecg.NewRhythmAnalyzer(nil)
+		technical := 0
+		for i := 0; i < 90; i++ {
+			reading, signalLoss := detector.Detect(<-readings)
+			if signalLoss != nil && !errors.Is(signalLoss, ecg.ErrSignalLoss) {
+				t.Fatalf("%s: detection failed: %v", tt.config.Type, signalLoss)
+			}
+
+			condition := rhythm.Analyze(reading)
+			err := faults.Check(reading)
+			if err == nil {
+				err = signalLoss
+			}
+			if err != nil {
+				condition = ecg.TechnicalAlert(reading, err)
+				rhythm.Reset()
+			}
+
+			// Reading i covers second i; episodes cover seconds 20-25 of every 30.
+			inEpisode := i%30 >= 20 && i%30 < 26
+			switch {
+			case condition.Severity == ecg.SeverityTechnical && !inEpisode:
+				t.Errorf("%s: reading %d outside the artifact raised %q", tt.config.Type, i, condition.Description)
+			case condition.Severity == ecg.SeverityTechnical:
+				technical++
+			case condition.Type != ecg.ConditionNormal:
+				t.Errorf("%s: reading %d at HR=%d raised %s (%s)", tt.config.Type, i, reading.HeartRate, condition.Type, condition.Description)
+			}
+		}
+		stopper.Stop()
+
+		if (technical > 0) != tt.technical {
+			t.Errorf("%s: expected technical alerts=%v, got %d", tt.config.Type, tt.technical, technical)
+		}
+	}
+}
+
+func TestArtifactConfigValidate(t *testing.T) {
+	testCase := func(name string, config simulation.ArtifactConfig, valid bool) {
+		t.Run(name, func(t *testing.T) {
+			err := config.Validate()
+			if valid && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if !valid && err == nil {
+				t.Error("Expected validation error")
+			}
+		})
+	}
+
+	testCase("Continuous powerline", simulation.ArtifactConfig{Type: simulation.ArtifactPowerline}, true)
+	testCase("Unknown type", simulation.ArtifactConfig{Type: "static"}, false)
+	testCase("Random without duration", simulation.ArtifactConfig{Type: simulation.ArtifactMotion, Rate: 2}, false)
+	testCase("Period shorter than duration", simulation.ArtifactConfig{
+		Type:     simulation.ArtifactLeadOff,
+		Duration: simulation.Duration(2 * time.Second),
+		Period:   simulation.Duration(time.Second),
+	}, false)
+	testCase("Dropped fraction above one", simulation.ArtifactConfig{Type: simulation.ArtifactDroppedSamples, Amplitude: 2}, false)
+}
+
+func maxAbsDiff(a, b []float64) float64 {
+	m := 0.0
+	for i := range a {
+		m = math.Max(m, math.Abs(a[i]-b[i]))
+	}
+	return m
+}
+
+func roughness(samples []float64) float64 {
+	sum := 0.0
+	for i := 2; i < len(samples); i++ {
+		d := samples[i] - 2*samples[i-1] + samples[i-2]
+		sum += d * d
+	}
+	return sum / float64(len(samples))
+}
+
+func spread(samples []float64) float64 {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, v := range samples {
+		lo = math.Min(lo, v)
+		hi = math.Max(hi, v)
+	}
+	return hi - lo
+}
@@ -0,0 +1,92 @@
+package simulation_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"arhm/ecg-monitoring/pkg/ecg"
+	"arhm/ecg-monitoring/pkg/simulation"
+)
+
+var clockStart = time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+
+func TestBatchClockTicker(t *testing.T) {
+	clock := simulation.NewBatchClock(clockStart)
+	ticker := clock.NewTicker(time.Hour)
+
+	for i := 1; i <= 48; i++ {
+		if got := <-ticker.C(); !got.Equal(clockStart.Add(time.Duration(i) * time.Hour)) {
+			t.Fatalf("Tick %d: expected %v, got %v", i, clockStart.Add(time.Duration(i)*time.Hour), got)
+		}
+	}
+	ticker.Stop()
+
+	// The ticker may already have offered the next tick.
+	if got := clock.Now().Sub(clockStart); got < 48*time.Hour || got > 49*time.Hour {
+		t.Errorf("Expected clock to have reached two days, got %v", got)
+	}
+
+	select {
+	case <-clock.After(time.Hour):
+	default:
+		t.Error("Expected After to fire immediately in batch mode")
+	}
+}
+
+func TestScaledClock(t *testing.T) {
+	clock := simulation.NewScaledClock(clockStart, 600)
+	ticker := clock.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	started := time.Now()
+	for i := 0; i < 5; i++ {
+		<-ticker.C()
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("Expected 5 simulated seconds at 600x to take well under a second, took %v", elapsed)
+	}
+
+	if simulated := clock.Now().Sub(clockStart); simulated < 5*time.Second {
+		t.Errorf("Expected at least 5s of simulated time, got %v", simulated)
+	}
+}
+
+// runBatch collects readings from a seeded roster on a batch clock.
+func runBatch(count int) []ecg.ECGReading {
+	roster := simulation.NewRoster(1)
+	roster.Seed(11)
+	roster.SetClock(simulation.NewBatchClock(clockStart))
+	controller, _ := roster.Get("PATIENT-1")
+
+	readings := make(chan ecg.ECGReading)
+	ticker := controller.RunWithCallback(time.Minute, func(reading ecg.ECGReading, condition simulation.Condition) {
+		readings <- reading
+	})
+	defer ticker.Stop()
+
+	var collected []ecg.ECGReading
+	for len(collected) < count {
+		collected = append(collected, <-readings)
+	}
+	return collected
+}
+
+func TestBatchClockDay(t *testing.T) {
+	readings := runBatch(24 * 60)
+
+	last := readings[len(readings)-1]
+	if want := clockStart.Add(24 * time.Hour); !last.Timestamp.Equal(want) {
+		t.Errorf("Expected a day of readings to end at %v, got %v", want, last.Timestamp)
+	}
+
+	first, err := json.Marshal(readings)
+	if err != nil {
+		t.Fatalf("Failed to marshal readings: %v", err)
+	}
+	second, _ := json.Marshal(runBatch(24 * 60))
+	if !reflect.DeepEqual(first, second) {
+		t.Error("Expected seeded batch runs to produce identical output")
+	}
+}
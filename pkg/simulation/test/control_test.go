@@ -0,0 +1,203 @@
+package simulation_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"arhm/ecg-monitoring/pkg/ecg"
+	"arhm/ecg-monitoring/pkg/simulation"
+)
+
+func TestForceCondition(t *testing.T) {
+	controller := simulation.NewController()
+	controller.Seed(1)
+
+	if err := controller.ForceCondition(simulation.ConditionBradycardia); err != nil {
+		t.Fatalf("Failed to force condition: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, condition := controller.NextReading(); condition != simulation.ConditionBradycardia {
+			t.Fatalf("Reading %d: expected forced bradycardia, got %s", i, condition)
+		}
+	}
+
+	// The timeline kept advancing underneath: 10 ticks of 3 puts it on the
+	// fourth condition of the default cycle.
+	controller.ClearCondition()
+	if _, condition := controller.NextReading(); condition != simulation.ConditionBradycardia {
+		t.Errorf("Expected timeline bradycardia after clearing, got %s", condition)
+	}
+	if status := controller.Status(); status.Forced != "" {
+		t.Errorf("Expected no forced condition, got %q", status.Forced)
+	}
+
+	if err := controller.ForceCondition("sleepy"); err == nil {
+		t.Error("Expected error forcing an unknown condition")
+	}
+}
+
+func TestInjectEpisode(t *testing.T) {
+	controller := simulation.NewStepController(simulation.NewDefaultPatient(), []simulation.Step{
+		{Condition: simulation.ConditionNormal, Ticks: 1, Next: -1},
+	})
+	controller.Seed(2)
+	controller.ForceCondition(simulation.ConditionTachycardia)
+
+	err := controller.InjectEvent(simulation.Event{
+		Type:      simulation.EventEpisode,
+		Condition: simulation.ConditionVentricularTachycardia,
+		Duration:  simulation.Duration(3 * time.Second),
+	})
+	if err != nil {
+		t.Fatalf("Failed to inject episode: %v", err)
+	}
+	if status := controller.Status(); status.Episode != simulation.ConditionVentricularTachycardia {
+		t.Errorf("Expected episode in status, got %+v", status)
+	}
+
+	want := []simulation.Condition{"ventricular_tachycardia", "ventricular_tachycardia", "ventricular_tachycardia", "tachycardia"}
+	for i, expected := range want {
+		if _, condition := controller.NextReading(); condition != expected {
+			t.Errorf("Reading %d: expected %s, got %s", i, expected, condition)
+		}
+	}
+}
+
+func TestInjectPrematureBeat(t *testing.T) {
+	controller := simulation.NewStepController(simulation.NewDefaultPatient(), []simulation.Step{
+		{Condition: simulation.ConditionNormal, Ticks: 1, Next: -1},
+	})
+	controller.Seed(3)
+
+	before, _ := controller.NextReading()
+	if before.BeatType != "" {
+		t.Errorf("Expected no beat label without ectopy, got %q", before.BeatType)
+	}
+
+	if err := controller.InjectEvent(simulation.Event{Type: simulation.EventPVC}); err != nil {
+		t.Fatalf("Failed to inject PVC: %v", err)
+	}
+
+	pvc, _ := controller.NextReading()
+	pause, _ := controller.NextReading()
+	if pvc.BeatType != ecg.BeatPVC {
+		t.Fatalf("Expected injected PVC, got %q", pvc.BeatType)
+	}
+	if pause.BeatType != ecg.BeatNormal || pause.RRInterval <= before.RRInterval {
+		t.Errorf("Expected a compensatory pause after the PVC, got %q with RR %.3f", pause.BeatType, pause.RRInterval)
+	}
+	if pvc.RRInterval >= before.RRInterval {
+		t.Errorf("Expected the PVC to be premature, got RR %.3f vs %.3f", pvc.RRInterval, before.RRInterval)
+	}
+}
+
+func TestSetParameters(t *testing.T) {
+	fast := 95
+	controller := simulation.NewStepController(simulation.NewDefaultPatient(), []simulation.Step{
+		{Condition: simulation.ConditionNormal, Ticks: 1, Next: 1},
+		{Condition: simulation.ConditionNormal, Ticks: 1, Next: 0, Parameters: simulation.PatientParameters{BaseHeartRate: &fast}},
+	})
+
+	slow := 55
+	if err := controller.SetParameters(simulation.PatientParameters{BaseHeartRate: &slow}); err != nil {
+		t.Fatalf("Failed to set parameters: %v", err)
+	}
+
+	controller.NextReading()
+	if controller.Patient.BaseHeartRate != 55 {
+		t.Errorf("Expected new base heart rate 55, got %d", controller.Patient.BaseHeartRate)
+	}
+	controller.NextReading()
+	if controller.Patient.BaseHeartRate != 95 {
+		t.Errorf("Expected step override 95 to win, got %d", controller.Patient.BaseHeartRate)
+	}
+
+	invalid := 500
+	if err := controller.SetParameters(simulation.PatientParameters{BaseHeartRate: &invalid}); err == nil {
+		t.Error("Expected error for out of range base heart rate")
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	controller := simulation.NewController()
+	controller.Clock = simulation.NewBatchClock(clockStart)
+	controller.Pause()
+
+	readings := make(chan ecg.ECGReading, 1)
+	ticker := controller.RunWithCallback(time.Second, func(reading ecg.ECGReading, condition simulation.Condition) {
+		readings <- reading
+	})
+	defer ticker.Stop()
+
+	select {
+	case <-readings:
+		t.Fatal("Expected no readings while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	controller.Resume()
+	select {
+	case reading := <-readings:
+		if !reading.Timestamp.After(clockStart) {
+			t.Errorf("Expected the first reading one interval after the start, got %v", reading.Timestamp)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected readings after resuming")
+	}
+}
+
+func TestPauseBatchClock(t *testing.T) {
+	controller := simulation.NewController()
+	controller.Clock = simulation.NewBatchClock(clockStart)
+
+	readings := make(chan ecg.ECGReading)
+	ticker := controller.RunWithCallback(time.Second, func(reading ecg.ECGReading, condition simulation.Condition) {
+		readings <- reading
+	})
+	defer ticker.Stop()
+
+	var last time.Time
+	for i := 0; i < 5; i++ {
+		last = (<-readings).Timestamp
+	}
+
+	// Simulated time stands still while paused: the batch clock is not
+	// ticked with nothing to deliver.
+	controller.Pause()
+	// The run may already hold the tick after the last reading.
+	select {
+	case reading := <-readings:
+		last = reading.Timestamp
+	case <-time.After(10 * time.Millisecond):
+	}
+	time.Sleep(100 * time.Millisecond)
+	controller.Resume()
+
+	select {
+	case reading := <-readings:
+		if gap := reading.Timestamp.Sub(last); gap > 2*time.Second {
+			t.Errorf("Expected the next reading within two intervals of the last, got %v later", gap)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected readings after resuming")
+	}
+}
+
+func TestEventValidate(t *testing.T) {
+	tests := []struct {
+		event simulation.Event
+		want  string
+	}{
+		{simulation.Event{Type: "stroke"}, "unknown event type"},
+		{simulation.Event{Type: simulation.EventEpisode, Condition: "sleepy", Duration: simulation.Duration(time.Second)}, "unknown condition"},
+		{simulation.Event{Type: simulation.EventEpisode, Condition: simulation.ConditionAsystole}, "duration"},
+	}
+
+	for _, tt := range tests {
+		err := tt.event.Validate()
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%+v: expected error containing %q, got %v", tt.event, tt.want, err)
+		}
+	}
+}
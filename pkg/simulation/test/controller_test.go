@@ -3,6 +3,7 @@ package simulation_test
 import (
 	"arhm/ecg-monitoring/pkg/ecg"
 	"arhm/ecg-monitoring/pkg/simulation"
+	"math"
 	"testing"
 	"time"
 )
@@ -84,25 +85,198 @@ func TestAdvanceCycle(t *testing.T) {
 }
 
 func TestRunWithCallback(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
 	controller := simulation.NewController()
-	callCount := 0
-	conditionsSeen := make(map[simulation.Condition]bool)
+	controller.Clock = simulation.NewBatchClock(start)
 
+	readings := make(chan ecg.ECGReading)
+	conditions := make(chan simulation.Condition, 1)
 	callback := func(reading ecg.ECGReading, condition simulation.Condition) {
-		callCount++
-		conditionsSeen[condition] = true
+		conditions <- condition
+		readings <- reading
 	}
 
 	ticker := controller.RunWithCallback(20*time.Millisecond, callback)
 	defer ticker.Stop()
 
-	time.Sleep(500 * time.Millisecond)
+	conditionsSeen := make(map[simulation.Condition]bool)
+	for i := 1; i <= 10; i++ {
+		conditionsSeen[<-conditions] = true
+		reading := <-readings
 
-	if callCount < 5 {
-		t.Errorf("Expected at least 5 calls to callback, got %d", callCount)
+		if want := start.Add(time.Duration(i) * 20 * time.Millisecond); !reading.Timestamp.Equal(want) {
+			t.Errorf("Reading %d: expected timestamp %v, got %v", i, want, reading.Timestamp)
+		}
 	}
 
 	if len(conditionsSeen) < 2 {
 		t.Errorf("Expected to see at least 2 different conditions, saw %d", len(conditionsSeen))
 	}
 }
+
+// collectBeats runs a controller with beats enabled for n one-second readings.
+func collectBeats(t *testing.T, controller *simulation.Controller, n int) []ecg.Beat {
+	t.Helper()
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	controller.Clock = simulation.NewBatchClock(start)
+	controller.SetBeats(true)
+	controller.Seed(3)
+
+	readings := make(chan ecg.ECGReading)
+	stopper := controller.RunWithCallback(time.Second, func(reading ecg.ECGReading, _ simulation.Condition) {
+		readings <- reading
+	})
+	defer stopper.Stop()
+
+	var beats []ecg.Beat
+	for i := 0; i < n; i++ {
+		reading := <-readings
+		for _, beat := range reading.Beats {
+			if beat.Timestamp.After(reading.Timestamp) || !beat.Timestamp.After(reading.Timestamp.Add(-time.Second)) {
+				t.Fatalf("Reading %d: beat at %v outside the interval ending %v", i, beat.Timestamp, reading.Timestamp)
+			}
+		}
+		beats = append(beats, reading.Beats...)
+	}
+	return beats
+}
+
+func TestControllerGroundTruth(t *testing.T) {
+	controller := simulation.NewStepController(simulation.NewDefaultPatient(), []simulation.Step{
+		{Condition: simulation.ConditionNormal, Ticks: 2, Next: 1},
+		{Condition: simulation.ConditionTachycardia, Ticks: 2, Next: 2},
+		{Condition: simulation.ConditionVentricularTachycardia, Ticks: 1, Next: 3},
+		{Condition: simulation.ConditionVentricularTachycardia, Ticks: 1, Next: 0},
+	})
+
+	want := []struct {
+		condition simulation.Condition
+		expected  string
+		episode   int
+	}{
+		{simulation.ConditionNormal, ecg.ConditionNormal, 1},
+		{simulation.ConditionNormal, ecg.ConditionNormal, 1},
+		{simulation.ConditionTachycardia, ecg.ConditionTachycardia, 2},
+		{simulation.ConditionTachycardia, ecg.ConditionTachycardia, 2},
+		// Consecutive steps of the same rhythm are one episode.
+		{simulation.ConditionVentricularTachycardia, ecg.ConditionVentricularTachycardia, 3},
+		{simulation.ConditionVentricularTachycardia, ecg.ConditionVentricularTachycardia, 3},
+		{simulation.ConditionNormal, ecg.ConditionNormal, 4},
+	}
+
+	for i, w := range want {
+		reading, condition := controller.NextReading()
+		truth := reading.Truth
+		if truth == nil {
+			t.Fatalf("Reading %d: expected a ground truth label", i)
+		}
+		if truth.Condition != string(condition) || condition != w.condition {
+			t.Errorf("Reading %d: expected condition %s, got %s (returned %s)", i, w.condition, truth.Condition, condition)
+		}
+		if truth.Expected != w.expected {
+			t.Errorf("Reading %d: expected %s to be expected, got %s", i, w.expected, truth.Expected)
+		}
+		if truth.EpisodeID != w.episode {
+			t.Errorf("Reading %d: expected episode %d, got %d", i, w.episode, truth.EpisodeID)
+		}
+	}
+}
+
+func TestControllerBeats(t *testing.T) {
+	patient := simulation.NewDefaultPatient()
+	controller := simulation.NewStepController(patient, []simulation.Step{
+		{Condition: simulation.ConditionNormal, Ticks: 1, Next: -1},
+	})
+	beats := collectBeats(t, controller, 120)
+
+	// About 75 BPM for two minutes.
+	if len(beats) < 130 || len(beats) > 170 {
+		t.Fatalf("Expected about 150 beats in two minutes, got %d", len(beats))
+	}
+
+	distinct := make(map[float64]bool)
+	for i := 1; i < len(beats); i++ {
+		gap := beats[i].Timestamp.Sub(beats[i-1].Timestamp).Seconds()
+		if math.Abs(gap-beats[i].RRInterval) > 0.001 {
+			t.Fatalf("Beat %d: %.3fs after the previous beat but RR is %.3fs", i, gap, beats[i].RRInterval)
+		}
+		if beats[i].BeatType != ecg.BeatNormal || beats[i].PatientID != patient.ID {
+			t.Errorf("Beat %d: unexpected %+v", i, beats[i])
+		}
+		distinct[beats[i].RRInterval] = true
+	}
+	if len(distinct) < len(beats)/2 {
+		t.Errorf("Expected beat-to-beat RR variation, got %d distinct intervals in %d beats", len(distinct), len(beats))
+	}
+}
+
+func TestControllerBeatsFollowEctopy(t *testing.T) {
+	patient := simulation.NewDefaultPatient()
+	patient.Ectopy = &simulation.EctopyParameters{
+		PVC: simulation.EctopicFocus{Pattern: simulation.PatternBigeminy},
+	}
+	controller := simulation.NewStepController(patient, []simulation.Step{
+		{Condition: simulation.ConditionNormal, Ticks: 1, Next: -1},
+	})
+	beats := collectBeats(t, controller, 60)
+
+	pvcs := 0
+	for i, beat := range beats {
+		if beat.BeatType != ecg.BeatPVC {
+			continue
+		}
+		pvcs++
+		if i > 0 && beats[i-1].BeatType == ecg.BeatPVC {
+			t.Errorf("Beat %d: consecutive PVCs in bigeminy", i)
+		}
+	}
+	if math.Abs(float64(pvcs)-float64(len(beats))/2) > 2 {
+		t.Errorf("Expected every second beat to be a PVC, got %d of %d", pvcs, len(beats))
+	}
+}
+
+func TestControllerBeatsWithWaveform(t *testing.T) {
+	patient := simulation.NewDefaultPatient()
+	patient.Ectopy = &simulation.EctopyParameters{
+		PVC: simulation.EctopicFocus{Pattern: simulation.PatternBigeminy},
+	}
+	controller := simulation.NewStepController(patient, []simulation.Step{
+		{Condition: simulation.ConditionNormal, Ticks: 1, Next: -1},
+	})
+	if err := controller.SetWaveform(simulation.SampleRate500); err != nil {
+		t.Fatalf("Failed to enable waveform: %v", err)
+	}
+	beats := collectBeats(t, controller, 30)
+
+	// The model's complexes stretch with the cycle, so widths are compared
+	// as a fraction of the RR interval.
+	var normalQRS, pvcQRS []float64
+	for i, beat := range beats {
+		if beat.Morphology == nil {
+			t.Fatalf("Beat %d: expected morphology with a waveform", i)
+		}
+		switch beat.BeatType {
+		case ecg.BeatNormal:
+			normalQRS = append(normalQRS, beat.Morphology.QRSDuration/beat.RRInterval)
+			if beat.Morphology.RAmplitude < 0.5 {
+				t.Errorf("Beat %d: expected an R peak in lead II, got %.2f mV", i, beat.Morphology.RAmplitude)
+			}
+		case ecg.BeatPVC:
+			pvcQRS = append(pvcQRS, beat.Morphology.QRSDuration/beat.RRInterval)
+		}
+	}
+
+	normal, _ := meanStdDev(normalQRS)
+	pvc, _ := meanStdDev(pvcQRS)
+	if len(pvcQRS) == 0 || pvc < 2*normal {
+		t.Errorf("Expected PVC complexes much wider than sinus ones, got %.2f vs %.2f of the cycle (%d PVCs)", pvc, normal, len(pvcQRS))
+	}
+}
+
+func TestControllerBeatsDisabledByDefault(t *testing.T) {
+	controller := simulation.NewController()
+	if reading, _ := controller.NextReading(); reading.Beats != nil {
+		t.Errorf("Expected no beats unless enabled, got %d", len(reading.Beats))
+	}
+}
@@ -0,0 +1,184 @@
+package simulation_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"arhm/ecg-monitoring/pkg/ecg"
+	"arhm/ecg-monitoring/pkg/simulation"
+)
+
+func ectopyController(ectopy simulation.EctopyParameters) *simulation.Controller {
+	patient := simulation.NewDefaultPatient()
+	patient.Variability = 1
+	patient.RRVariability = 0
+	patient.Ectopy = &ectopy
+
+	controller := simulation.NewStepController(patient, []simulation.Step{
+		{Condition: simulation.ConditionNormal, Ticks: 1, Next: -1},
+	})
+	controller.Seed(4)
+
+	return controller
+}
+
+func TestEctopyBigeminy(t *testing.T) {
+	controller := ectopyController(simulation.EctopyParameters{
+		PVC: simulation.EctopicFocus{Pattern: simulation.PatternBigeminy},
+	})
+
+	readings := collectReadings(controller, 40)
+	for i, reading := range readings {
+		expected := ecg.BeatNormal
+		if i%2 == 1 {
+			expected = ecg.BeatPVC
+		}
+		if reading.BeatType != expected {
+			t.Fatalf("Reading %d: Expected %s, got %s", i, expected, reading.BeatType)
+		}
+	}
+
+	for i := 1; i+1 < len(readings); i += 2 {
+		coupling := readings[i].RRInterval
+		pause := readings[i+1].RRInterval
+
+		if coupling > 0.5 {
+			t.Errorf("Beat %d: Expected premature PVC coupling interval, got %f", i, coupling)
+		}
+
+		// Full compensatory pause: coupling + pause spans two sinus cycles.
+		if math.Abs(coupling+pause-2*60.0/80) > 0.05 {
+			t.Errorf("Beat %d: Expected compensatory pause, coupling %f + pause %f", i, coupling, pause)
+		}
+	}
+}
+
+func TestEctopyTrigeminyAndCouplets(t *testing.T) {
+	controller := ectopyController(simulation.EctopyParameters{
+		PAC: simulation.EctopicFocus{Pattern: simulation.PatternTrigeminy},
+	})
+
+	for i, reading := range collectReadings(controller, 30) {
+		expected := ecg.BeatNormal
+		if i%3 == 2 {
+			expected = ecg.BeatPAC
+		}
+		if reading.BeatType != expected {
+			t.Fatalf("Trigeminy reading %d: Expected %s, got %s", i, expected, reading.BeatType)
+		}
+	}
+
+	controller = ectopyController(simulation.EctopyParameters{
+		PVC: simulation.EctopicFocus{Rate: 6, Pattern: simulation.PatternCouplet},
+	})
+
+	readings := collectReadings(controller, 600)
+	couplets := 0
+	for i := 0; i < len(readings); i++ {
+		if readings[i].BeatType != ecg.BeatPVC {
+			continue
+		}
+		if i+2 >= len(readings) {
+			break
+		}
+		if readings[i+1].BeatType != ecg.BeatPVC || readings[i+2].BeatType != ecg.BeatNormal {
+			t.Fatalf("Reading %d: Expected a PVC couplet followed by a sinus beat", i)
+		}
+		couplets++
+		i += 2
+	}
+
+	if couplets < 10 {
+		t.Errorf("Expected couplets at roughly 6 per minute, got %d in %d beats", couplets, len(readings))
+	}
+}
+
+func TestEctopyIsolatedPAC(t *testing.T) {
+	controller := ectopyController(simulation.EctopyParameters{
+		PAC: simulation.EctopicFocus{Rate: 8, Pattern: simulation.PatternIsolated},
+	})
+
+	readings := collectReadings(controller, 1200)
+	count := 0
+	minutes := 0.0
+	for i, reading := range readings {
+		minutes += reading.RRInterval / 60
+		if reading.BeatType != ecg.BeatPAC {
+			continue
+		}
+		count++
+
+		if i+1 < len(readings) {
+			// Non-compensatory pause: the sinus node is reset.
+			total := reading.RRInterval + readings[i+1].RRInterval
+			if total >= 2*60.0/80-0.02 {
+				t.Errorf("Beat %d: Expected non-compensatory pause after PAC, got %f", i, total)
+			}
+		}
+	}
+
+	rate := float64(count) / minutes
+	if rate < 5 || rate > 11 {
+		t.Errorf("Expected about 8 PACs per minute, got %f", rate)
+	}
+}
+
+func TestEctopyWaveform(t *testing.T) {
+	patient := simulation.NewDefaultPatient()
+	patient.Ectopy = &simulation.EctopyParameters{
+		PVC: simulation.EctopicFocus{Pattern: simulation.PatternBigeminy},
+	}
+	patient.Rand = simulation.NewRandomSource(9)
+
+	generator, err := simulation.NewWaveformGenerator(patient, simulation.SampleRate500)
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+
+	samples, beats := generator.Generate(20 * time.Second)
+
+	pvcs := 0
+	for i := 0; i+1 < len(beats); i++ {
+		start := int(beats[i].Time.Seconds()*simulation.SampleRate500) + 1
+		end := int(beats[i+1].Time.Seconds() * simulation.SampleRate500)
+
+		minimum := 0.0
+		for _, v := range samples[start:end] {
+			minimum = math.Min(minimum, v)
+		}
+
+		switch beats[i].Type {
+		case ecg.BeatPVC:
+			pvcs++
+			if minimum > -0.3 {
+				t.Errorf("Beat %d: Expected deep S and inverted T after PVC, minimum was %f", i, minimum)
+			}
+		case ecg.BeatNormal:
+			if minimum < -0.3 {
+				t.Errorf("Beat %d: Unexpected negative deflection %f after sinus beat", i, minimum)
+			}
+		}
+	}
+
+	if pvcs < 5 {
+		t.Errorf("Expected labelled PVC beats in the waveform, got %d", pvcs)
+	}
+}
+
+func TestEctopyParametersValidate(t *testing.T) {
+	valid := simulation.EctopyParameters{PVC: simulation.EctopicFocus{Rate: 2, Pattern: simulation.PatternIsolated}}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	invalid := simulation.EctopyParameters{PVC: simulation.EctopicFocus{Pattern: "quadrigeminy"}}
+	if err := invalid.Validate(); err == nil {
+		t.Error("Expected error for unknown pattern")
+	}
+
+	invalid = simulation.EctopyParameters{PVCCoupling: 1.2}
+	if err := invalid.Validate(); err == nil {
+		t.Error("Expected error for coupling interval out of range")
+	}
+}
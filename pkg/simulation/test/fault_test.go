@@ -0,0 +1,138 @@
+package simulation_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"arhm/ecg-monitoring/pkg/ecg"
+	"arhm/ecg-monitoring/pkg/simulation"
+)
+
+// runFaults delivers the readings of the first n seconds of a sinus rhythm
+// patient with the given faults.
+func runFaults(n int, faults ...simulation.FaultConfig) []ecg.ECGReading {
+	patient := simulation.NewDefaultPatient()
+	patient.Faults = faults
+	controller := simulation.NewStepController(patient, []simulation.Step{
+		{Condition: simulation.ConditionNormal, Ticks: 1, Next: -1},
+	})
+	controller.Seed(4)
+	controller.Clock = simulation.NewBatchClock(clockStart)
+
+	// Ticks arrive one second apart; collect those up to n seconds.
+	readings := make(chan ecg.ECGReading, 4)
+	ticker := controller.RunWithCallback(time.Second, func(reading ecg.ECGReading, _ simulation.Condition) {
+		readings <- reading
+	})
+	defer ticker.Stop()
+
+	end := clockStart.Add(time.Duration(n) * time.Second)
+	var collected []ecg.ECGReading
+	for reading := range readings {
+		if reading.Timestamp.After(end) {
+			break
+		}
+		collected = append(collected, reading)
+	}
+	return collected
+}
+
+func window(start, duration time.Duration) (simulation.Duration, simulation.Duration) {
+	return simulation.Duration(start), simulation.Duration(duration)
+}
+
+func TestFaultValues(t *testing.T) {
+	start, duration := window(5*time.Second, 3*time.Second)
+	zero := 0.0
+
+	tests := []struct {
+		fault simulation.FaultConfig
+		check func(ecg.ECGReading) bool
+	}{
+		{simulation.FaultConfig{Type: simulation.FaultInvalidHeartRate}, func(r ecg.ECGReading) bool { return r.HeartRate == 400 }},
+		{simulation.FaultConfig{Type: simulation.FaultInvalidHeartRate, Value: &zero}, func(r ecg.ECGReading) bool { return r.HeartRate == 0 && r.RRInterval > 0 }},
+		{simulation.FaultConfig{Type: simulation.FaultInvalidRR}, func(r ecg.ECGReading) bool { return math.IsNaN(r.RRInterval) }},
+	}
+
+	for _, tt := range tests {
+		tt.fault.Start, tt.fault.Duration = start, duration
+		readings := runFaults(10, tt.fault)
+		if len(readings) != 10 {
+			t.Fatalf("%s: expected 10 readings, got %d", tt.fault.Type, len(readings))
+		}
+
+		for i, reading := range readings {
+			active := i >= 5 && i < 8 // Schedules count from the first reading
+			if tt.check(reading) != active {
+				t.Errorf("%s: reading %d (active=%v) has HR=%d RR=%v", tt.fault.Type, i, active, reading.HeartRate, reading.RRInterval)
+			}
+			if active && ecg.CheckReading(reading) == nil {
+				t.Errorf("%s: reading %d should fail the sensor checks", tt.fault.Type, i)
+			}
+			if labelled := len(reading.Truth.Faults) == 1 && reading.Truth.Faults[0] == tt.fault.Type; labelled != active {
+				t.Errorf("%s: reading %d (active=%v) labelled with faults %v", tt.fault.Type, i, active, reading.Truth.Faults)
+			}
+		}
+	}
+}
+
+func TestFaultStuckValue(t *testing.T) {
+	start, duration := window(3*time.Second, 5*time.Second)
+	readings := runFaults(10, simulation.FaultConfig{Type: simulation.FaultStuckValue, Start: start, Duration: duration})
+
+	detector := ecg.NewFaultDetector(time.Second)
+	var detected bool
+	for i, reading := range readings[3:8] {
+		if reading.HeartRate != readings[3].HeartRate || reading.RRInterval != readings[3].RRInterval {
+			t.Errorf("Reading %d: expected values stuck at HR=%d, got HR=%d", i+3, readings[3].HeartRate, reading.HeartRate)
+		}
+	}
+	for _, reading := range readings {
+		if detector.Check(reading) != nil {
+			detected = true
+		}
+	}
+	if !detected {
+		t.Error("Expected the stuck values to be detected")
+	}
+	if readings[8].RRInterval == readings[3].RRInterval {
+		t.Error("Expected values to recover after the fault")
+	}
+}
+
+func TestFaultDelivery(t *testing.T) {
+	start, duration := window(3*time.Second, 2*time.Second)
+
+	dropped := runFaults(10, simulation.FaultConfig{Type: simulation.FaultDropout, Start: start, Duration: duration})
+	if len(dropped) != 8 {
+		t.Errorf("Expected 2 of 10 readings dropped, got %d readings", len(dropped))
+	}
+
+	duplicated := runFaults(10, simulation.FaultConfig{Type: simulation.FaultDuplicate, Start: start, Duration: duration})
+	if len(duplicated) != 12 || !duplicated[3].Timestamp.Equal(duplicated[4].Timestamp) {
+		t.Errorf("Expected 2 of 10 readings duplicated, got %d readings", len(duplicated))
+	}
+
+	backwards := runFaults(10, simulation.FaultConfig{Type: simulation.FaultTimestampBackwards, Start: start, Duration: duration})
+	if got := backwards[2].Timestamp.Sub(backwards[3].Timestamp); got != time.Minute-time.Second {
+		t.Errorf("Expected timestamps to jump back a minute, got %v", got)
+	}
+}
+
+func TestFaultConfigValidate(t *testing.T) {
+	valid := simulation.FaultConfig{Type: simulation.FaultDropout, Rate: 1, Duration: simulation.Duration(10 * time.Second)}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Expected %+v to be valid: %v", valid, err)
+	}
+
+	for _, invalid := range []simulation.FaultConfig{
+		{Type: "smoke"},
+		{Type: simulation.FaultDropout, Rate: 1},
+		{Type: simulation.FaultTimestampBackwards, Offset: -1},
+	} {
+		if invalid.Validate() == nil {
+			t.Errorf("Expected a validation error for %+v", invalid)
+		}
+	}
+}
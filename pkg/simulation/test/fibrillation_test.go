@@ -0,0 +1,123 @@
+package simulation_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"arhm/ecg-monitoring/pkg/simulation"
+)
+
+func TestAtrialFibrillationRR(t *testing.T) {
+	patient := simulation.NewDefaultPatient()
+	patient.SimulateAtrialFibrillation = true
+
+	const n = 2000
+	rr := make([]float64, n)
+	for i := range rr {
+		reading := simulation.GenerateECGReading(patient)
+		rr[i] = reading.RRInterval
+
+		if rr[i] < 0.3 {
+			t.Fatalf("RR interval %f shorter than AV refractory period", rr[i])
+		}
+	}
+
+	mean, sd := meanStdDev(rr)
+	expectedMean := 60.0 / float64(patient.AFVentricularRate)
+	if math.Abs(mean-expectedMean) > 0.03 {
+		t.Errorf("Expected mean RR around %f, got %f", expectedMean, mean)
+	}
+
+	cv := sd / mean
+	if cv < 0.12 || cv > 0.3 {
+		t.Errorf("Expected coefficient of variation around %f, got %f", patient.AFIrregularity, cv)
+	}
+
+	// Successive intervals are uncorrelated in AF.
+	var cov float64
+	for i := 1; i < n; i++ {
+		cov += (rr[i] - mean) * (rr[i-1] - mean)
+	}
+	autocorrelation := cov / float64(n-1) / (sd * sd)
+	if math.Abs(autocorrelation) > 0.1 {
+		t.Errorf("Expected lag-1 autocorrelation near 0, got %f", autocorrelation)
+	}
+}
+
+func TestAtrialFibrillationWaveform(t *testing.T) {
+	patient := simulation.NewDefaultPatient()
+	patient.SimulateAtrialFibrillation = true
+
+	generator, err := simulation.NewWaveformGenerator(patient, simulation.SampleRate500)
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+
+	samples, beats := generator.Generate(10 * time.Second)
+	if len(beats) < 10 {
+		t.Fatalf("Expected at least 10 beats, got %d", len(beats))
+	}
+
+	// The P wave sits 70 degrees before the R peak in sinus rhythm; in AF the
+	// signal there is only the low-amplitude fibrillatory baseline.
+	for _, beat := range beats[1:] {
+		pTime := beat.Time.Seconds() - beat.RR*70.0/360.0
+		idx := int(pTime * simulation.SampleRate500)
+		if idx < 0 || idx >= len(samples) {
+			continue
+		}
+		if math.Abs(samples[idx]) > 0.1 {
+			t.Errorf("Expected no P wave before beat at %v, got %f mV", beat.Time, samples[idx])
+		}
+	}
+}
+
+func TestParoxysmalAF(t *testing.T) {
+	patient := simulation.NewDefaultPatient()
+	patient.AFEpisodeMean = 5 * time.Second
+	patient.AFSinusMean = 5 * time.Second
+
+	controller := simulation.NewStepController(patient, []simulation.Step{
+		{Condition: simulation.ConditionParoxysmalAF, Ticks: 1, Next: -1},
+	})
+
+	_, first := controller.NextReading()
+	if first != simulation.ConditionAtrialFibrillation {
+		t.Errorf("Expected paroxysmal AF to start with an AF episode, got %s", first)
+	}
+
+	seen := map[simulation.Condition]int{}
+	transitions := 0
+	previous := first
+	for i := 0; i < 500; i++ {
+		_, condition := controller.NextReading()
+		seen[condition]++
+		if condition != previous {
+			transitions++
+		}
+		previous = condition
+	}
+
+	if seen[simulation.ConditionAtrialFibrillation] == 0 || seen[simulation.ConditionNormal] == 0 {
+		t.Errorf("Expected both AF and sinus episodes, got %v", seen)
+	}
+
+	if transitions < 10 {
+		t.Errorf("Expected repeated onset and offset of AF, got %d transitions", transitions)
+	}
+}
+
+func meanStdDev(values []float64) (float64, float64) {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+
+	var sq float64
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(sq / float64(len(values)))
+}
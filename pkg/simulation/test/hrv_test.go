@@ -0,0 +1,188 @@
+package simulation_test
+
+import (
+	"math"
+	"math/cmplx"
+	"testing"
+	"time"
+
+	"arhm/ecg-monitoring/pkg/simulation"
+)
+
+// bandPowers samples the HRV model at fs Hz and integrates its periodogram
+// over the given frequency bands.
+func bandPowers(parameters simulation.HRVParameters, seed int64, bands [][2]float64) []float64 {
+	const fs = 4.0
+	const n = 8192
+
+	generator := simulation.NewHRVGenerator(parameters, simulation.NewRandomSource(seed))
+	series := make([]float64, n)
+	for i := range series {
+		series[i] = generator.Offset(1 / fs)
+	}
+	return seriesBandPowers(series, fs, bands)
+}
+
+// seriesBandPowers integrates the periodogram of a series sampled at fs Hz
+// over the given frequency bands.
+func seriesBandPowers(series []float64, fs float64, bands [][2]float64) []float64 {
+	n := len(series)
+	twiddle := make([]complex128, n)
+	for i := range twiddle {
+		twiddle[i] = cmplx.Exp(complex(0, -2*math.Pi*float64(i)/float64(n)))
+	}
+
+	powers := make([]float64, len(bands))
+	for k := 1; k < n/2; k++ {
+		f := float64(k) * fs / float64(n)
+		var sum complex128
+		for i, x := range series {
+			sum += complex(x, 0) * twiddle[(k*i)%n]
+		}
+		power := cmplx.Abs(sum) * cmplx.Abs(sum)
+
+		for b, band := range bands {
+			if f >= band[0] && f < band[1] {
+				powers[b] += power
+			}
+		}
+	}
+	return powers
+}
+
+func TestHRVSpectrum(t *testing.T) {
+	lf := [2]float64{0.04, 0.15}
+	hf := [2]float64{0.15, 0.4}
+
+	for _, ratio := range []float64{0.5, 2, 4} {
+		parameters := simulation.NewDefaultHRV()
+		parameters.PinkFraction = 0
+		parameters.LFHFRatio = ratio
+
+		powers := bandPowers(parameters, 3, [][2]float64{lf, hf})
+		measured := powers[0] / powers[1]
+		if measured < ratio/1.6 || measured > ratio*1.6 {
+			t.Errorf("Configured LF/HF ratio %g, measured %g", ratio, measured)
+		}
+	}
+}
+
+func TestHRVRespiratoryPeak(t *testing.T) {
+	parameters := simulation.NewDefaultHRV()
+	parameters.PinkFraction = 0
+	parameters.RespirationRate = 18 // 0.3 Hz
+
+	powers := bandPowers(parameters, 5, [][2]float64{{0.27, 0.33}, {0.2, 0.25}, {0.35, 0.4}})
+	if powers[0] < 5*powers[1] || powers[0] < 5*powers[2] {
+		t.Errorf("Expected HF power concentrated at the respiration frequency, got %v", powers)
+	}
+}
+
+func TestHRVPinkNoise(t *testing.T) {
+	parameters := simulation.NewDefaultHRV()
+	parameters.PinkFraction = 1
+
+	powers := bandPowers(parameters, 7, [][2]float64{{0.01, 0.02}, {0.1, 0.2}, {1, 2}})
+	// Equal power per octave-ish band for 1/f noise means density falls with frequency.
+	if !(powers[0]/0.01 > powers[1]/0.1 && powers[1]/0.1 > powers[2]/1) {
+		t.Errorf("Expected power density decreasing with frequency, got %v", powers)
+	}
+}
+
+func TestHRVInController(t *testing.T) {
+	patient := simulation.NewDefaultPatient()
+	hrv := simulation.NewDefaultHRV()
+	patient.HRV = &hrv
+
+	controller := simulation.NewStepController(patient, []simulation.Step{
+		{Condition: simulation.ConditionNormal, Ticks: 1, Next: -1},
+	})
+	controller.Seed(11)
+
+	rr := make([]float64, 600)
+	for i, reading := range collectReadings(controller, len(rr)) {
+		rr[i] = reading.RRInterval
+
+		if math.Abs(60.0/reading.RRInterval-float64(reading.HeartRate)) > 1 {
+			t.Errorf("Reading %d: HR %d inconsistent with RR %f", i, reading.HeartRate, reading.RRInterval)
+		}
+	}
+
+	mean, sd := meanStdDev(rr)
+	if math.Abs(mean-60.0/80) > 0.03 {
+		t.Errorf("Expected mean RR around 0.75 s, got %f", mean)
+	}
+	if sd < hrv.SDNN/2 || sd > hrv.SDNN*2 {
+		t.Errorf("Expected SDNN around %f, got %f", hrv.SDNN, sd)
+	}
+}
+
+func TestHRVTimeWithBeats(t *testing.T) {
+	// Drawing the beats between readings must not speed up the model: the
+	// respiratory peak stays at the configured frequency.
+	patient := simulation.NewDefaultPatient()
+	hrv := simulation.NewDefaultHRV()
+	hrv.PinkFraction = 0
+	hrv.LFHFRatio = 0.1
+	hrv.RespirationRate = 12 // 0.2 Hz
+	patient.HRV = &hrv
+
+	controller := simulation.NewStepController(patient, []simulation.Step{
+		{Condition: simulation.ConditionNormal, Ticks: 1, Next: -1},
+	})
+	controller.Seed(13)
+	controller.SetBeats(true)
+
+	readings := collectReadings(controller, 1024)
+	rr := make([]float64, len(readings))
+	for i, reading := range readings {
+		rr[i] = reading.RRInterval
+	}
+
+	powers := seriesBandPowers(rr, 1, [][2]float64{{0.18, 0.22}, {0.23, 0.27}})
+	if powers[0] < 5*powers[1] {
+		t.Errorf("Expected HF power at 0.2 Hz with beats enabled, got %v", powers)
+	}
+}
+
+func TestHRVInWaveform(t *testing.T) {
+	patient := simulation.NewDefaultPatient()
+	hrv := simulation.NewDefaultHRV()
+	patient.HRV = &hrv
+	patient.Rand = simulation.NewRandomSource(2)
+
+	generator, err := simulation.NewWaveformGenerator(patient, simulation.SampleRate250)
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+
+	_, beats := generator.Generate(2 * time.Minute)
+	rr := make([]float64, len(beats))
+	for i, beat := range beats {
+		rr[i] = beat.RR
+	}
+
+	_, sd := meanStdDev(rr)
+	if sd < hrv.SDNN/3 || sd > hrv.SDNN*2 {
+		t.Errorf("Expected beat RR standard deviation around %f, got %f", hrv.SDNN, sd)
+	}
+}
+
+func TestHRVParametersValidate(t *testing.T) {
+	valid := simulation.NewDefaultHRV()
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Default HRV parameters should be valid: %v", err)
+	}
+
+	invalid := valid
+	invalid.RespirationRate = 2
+	if err := invalid.Validate(); err == nil {
+		t.Error("Expected error for respiration rate out of range")
+	}
+
+	invalid = valid
+	invalid.LFHFRatio = 0
+	if err := invalid.Validate(); err == nil {
+		t.Error("Expected error for non-positive LF/HF ratio")
+	}
+}
@@ -0,0 +1,155 @@
+package simulation_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"arhm/ecg-monitoring/pkg/ecg"
+	"arhm/ecg-monitoring/pkg/simulation"
+)
+
+func generateLeads(t *testing.T, patient simulation.SimulatedPatient) (map[ecg.Lead][]float64, []simulation.Beat) {
+	t.Helper()
+
+	generator, err := simulation.NewWaveformGenerator(patient, simulation.SampleRate500)
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+
+	samples, beats := generator.GenerateLeads(10*time.Second, ecg.StandardLeads)
+	leads := make(map[ecg.Lead][]float64)
+	for i, lead := range ecg.StandardLeads {
+		leads[lead] = samples[i]
+	}
+	return leads, beats
+}
+
+// qrsArea sums a lead over the QRS complexes, 50 ms either side of each R peak.
+func qrsArea(samples []float64, beats []simulation.Beat) float64 {
+	area := 0.0
+	for _, beat := range beats {
+		peak := int(beat.Time.Seconds() * simulation.SampleRate500)
+		for i := max(peak-25, 0); i <= peak+25 && i < len(samples); i++ {
+			area += samples[i]
+		}
+	}
+	return area
+}
+
+func peakAt(samples []float64, beats []simulation.Beat) float64 {
+	peak := int(beats[len(beats)/2].Time.Seconds() * simulation.SampleRate500)
+	return samples[peak]
+}
+
+func TestLeadRelationships(t *testing.T) {
+	patient := simulation.NewDefaultPatient()
+	patient.Rand = simulation.NewRandomSource(1)
+	leads, _ := generateLeads(t, patient)
+
+	for i := range leads[ecg.LeadII] {
+		if d := leads[ecg.LeadI][i] + leads[ecg.LeadIII][i] - leads[ecg.LeadII][i]; math.Abs(d) > 1e-9 {
+			t.Fatalf("Sample %d: Einthoven's law violated by %g", i, d)
+		}
+		if d := leads[ecg.LeadAVR][i] + leads[ecg.LeadAVL][i] + leads[ecg.LeadAVF][i]; math.Abs(d) > 1e-9 {
+			t.Fatalf("Sample %d: augmented leads sum to %g", i, d)
+		}
+		if d := leads[ecg.LeadAVF][i] - (leads[ecg.LeadII][i] - leads[ecg.LeadI][i]/2); math.Abs(d) > 1e-9 {
+			t.Fatalf("Sample %d: aVF differs from II - I/2 by %g", i, d)
+		}
+		if d := leads[ecg.LeadAVR][i] + (leads[ecg.LeadI][i]+leads[ecg.LeadII][i])/2; math.Abs(d) > 1e-9 {
+			t.Fatalf("Sample %d: aVR differs from -(I + II)/2 by %g", i, d)
+		}
+	}
+}
+
+func TestLeadMorphology(t *testing.T) {
+	patient := simulation.NewDefaultPatient()
+	patient.Rand = simulation.NewRandomSource(2)
+	leads, beats := generateLeads(t, patient)
+
+	if r := peakAt(leads[ecg.LeadII], beats); math.Abs(r-1.1) > 0.15 {
+		t.Errorf("Expected an R wave of about 1.1 mV in lead II, got %.2f", r)
+	}
+	if r := peakAt(leads[ecg.LeadAVR], beats); r >= 0 {
+		t.Errorf("Expected a negative QRS in aVR, got %.2f", r)
+	}
+
+	// R wave progression: a dominant S in V1 turning into a dominant R by V5.
+	v1 := qrsArea(leads[ecg.LeadV1], beats)
+	v5 := qrsArea(leads[ecg.LeadV5], beats)
+	if v1 >= 0 || v5 <= 0 {
+		t.Errorf("Expected negative QRS in V1 and positive in V5, got %.1f and %.1f", v1, v5)
+	}
+	if peakAt(leads[ecg.LeadV2], beats) >= peakAt(leads[ecg.LeadV4], beats) {
+		t.Error("Expected the R wave to grow from V2 to V4")
+	}
+}
+
+func TestLeadAxis(t *testing.T) {
+	for _, axis := range []float64{-45, 0, 60, 110, 170} {
+		patient := simulation.NewDefaultPatient()
+		patient.Rand = simulation.NewRandomSource(3)
+		patient.QRSAxis = axis
+
+		leads, beats := generateLeads(t, patient)
+
+		// Axis from the net QRS deflection in leads I and aVF, undoing the
+		// augmented lead's √3/2 gain.
+		avf := qrsArea(leads[ecg.LeadAVF], beats) * 2 / math.Sqrt(3)
+		got := math.Atan2(avf, qrsArea(leads[ecg.LeadI], beats)) * 180 / math.Pi
+		if d := math.Remainder(got-axis, 360); math.Abs(d) > 10 {
+			t.Errorf("Axis %g: computed %.1f from leads I and aVF", axis, got)
+		}
+	}
+}
+
+func TestLeadsVentricularFibrillation(t *testing.T) {
+	patient := simulation.NewDefaultPatient()
+	patient.SimulateVentricularFibrillation = true
+	leads, beats := generateLeads(t, patient)
+
+	if len(beats) != 0 {
+		t.Errorf("Expected no beats in VF, got %d", len(beats))
+	}
+
+	// Each lead shows a different disorganised waveform.
+	_, stdI := meanStdDev(leads[ecg.LeadI])
+	same := 0
+	for i := range leads[ecg.LeadI] {
+		if math.Abs(leads[ecg.LeadI][i]-leads[ecg.LeadV2][i]) < 0.01*stdI {
+			same++
+		}
+	}
+	if stdI == 0 || same > len(leads[ecg.LeadI])/10 {
+		t.Errorf("Expected distinct VF waveforms per lead (std %.3f, %d matching samples)", stdI, same)
+	}
+}
+
+func TestControllerWaveform(t *testing.T) {
+	controller := simulation.NewController()
+	controller.Seed(4)
+	if err := controller.SetWaveform(simulation.SampleRate250); err != nil {
+		t.Fatalf("Failed to enable waveform: %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		reading, _ := controller.NextReading()
+
+		if reading.SampleRate != simulation.SampleRate250 || len(reading.Leads) != 12 {
+			t.Fatalf("Expected 12 leads at 250 Hz, got %d at %d", len(reading.Leads), reading.SampleRate)
+		}
+		for lead, samples := range reading.Leads {
+			if len(samples) != simulation.SampleRate250 {
+				t.Errorf("Lead %s: expected one second of samples, got %d", lead, len(samples))
+			}
+		}
+	}
+
+	if err := controller.SetWaveform(0); err != nil {
+		t.Fatalf("Failed to disable waveform: %v", err)
+	}
+	if reading, _ := controller.NextReading(); reading.Leads != nil {
+		t.Error("Expected no leads once the waveform is disabled")
+	}
+}
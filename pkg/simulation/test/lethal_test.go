@@ -0,0 +1,84 @@
+package simulation_test
+
+import (
+	"testing"
+	"time"
+
+	"arhm/ecg-monitoring/pkg/ecg"
+	"arhm/ecg-monitoring/pkg/simulation"
+)
+
+func TestLethalRhythmReadings(t *testing.T) {
+	testCase := func(condition simulation.Condition, expectedType string) {
+		t.Run(string(condition), func(t *testing.T) {
+			controller := simulation.NewStepController(simulation.NewDefaultPatient(), []simulation.Step{
+				{Condition: simulation.ConditionNormal, Ticks: 3, Next: 1},
+				{Condition: condition, Ticks: 1, Next: -1},
+			})
+			controller.Transition = simulation.Transition{TimeConstant: 30 * time.Second}
+
+			readings := collectReadings(controller, 20)
+			for i, reading := range readings[3:] {
+				analyzed := ecg.AnalyzeReading(reading)
+				if analyzed.Type != expectedType {
+					t.Errorf("Reading %d: Expected %s, got %s (%+v)", i+3, expectedType, analyzed.Type, reading)
+				}
+				if analyzed.Severity != "critical" {
+					t.Errorf("Reading %d: Expected critical severity, got %s", i+3, analyzed.Severity)
+				}
+			}
+		})
+	}
+
+	testCase(simulation.ConditionVentricularTachycardia, ecg.ConditionVentricularTachycardia)
+	testCase(simulation.ConditionVentricularFibrillation, ecg.ConditionVentricularFibrillation)
+	testCase(simulation.ConditionAsystole, ecg.ConditionAsystole)
+}
+
+func TestLethalRhythmWaveforms(t *testing.T) {
+	patient := simulation.NewDefaultPatient()
+
+	patient.SimulateAsystole = true
+	generator, _ := simulation.NewWaveformGenerator(patient, simulation.SampleRate250)
+	samples, beats := generator.Generate(5 * time.Second)
+	if len(beats) != 0 {
+		t.Errorf("Expected no beats in asystole, got %d", len(beats))
+	}
+	for i, v := range samples {
+		if v != 0 {
+			t.Fatalf("Expected flat line in asystole, sample %d is %f", i, v)
+		}
+	}
+
+	patient.SimulateAsystole = false
+	patient.SimulateVentricularFibrillation = true
+	generator, _ = simulation.NewWaveformGenerator(patient, simulation.SampleRate250)
+	samples, beats = generator.Generate(5 * time.Second)
+	if len(beats) != 0 {
+		t.Errorf("Expected no organised beats in VF, got %d", len(beats))
+	}
+	crossings := 0
+	for i := 1; i < len(samples); i++ {
+		if (samples[i-1] < 0) != (samples[i] < 0) {
+			crossings++
+		}
+	}
+	// 4-7 Hz undulation crosses zero roughly 8-14 times per second.
+	if crossings < 30 || crossings > 100 {
+		t.Errorf("Expected VF undulation of a few Hz, got %d zero crossings in 5s", crossings)
+	}
+
+	patient.SimulateVentricularFibrillation = false
+	patient.SimulateVentricularTachycardia = true
+	patient.Rand = simulation.NewRandomSource(1)
+	generator, _ = simulation.NewWaveformGenerator(patient, simulation.SampleRate250)
+	_, beats = generator.Generate(5 * time.Second)
+	if len(beats) < 12 {
+		t.Errorf("Expected VT rate of at least 150 BPM, got %d beats in 5s", len(beats))
+	}
+	for _, beat := range beats {
+		if beat.Type != ecg.BeatVentricular {
+			t.Errorf("Expected ventricular beats in VT, got %s", beat.Type)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package simulation_test
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"arhm/ecg-monitoring/pkg/simulation"
+)
+
+func fixedDwell(d time.Duration) simulation.DwellTime {
+	return simulation.DwellTime{Distribution: simulation.DwellFixed, Mean: simulation.Duration(d)}
+}
+
+func TestMarkovFixedDwell(t *testing.T) {
+	fastRate := 100
+	chain := simulation.MarkovChain{
+		States: []simulation.MarkovState{
+			{
+				Condition:   simulation.ConditionNormal,
+				Dwell:       fixedDwell(3 * time.Second),
+				Transitions: map[simulation.Condition]float64{simulation.ConditionTachycardia: 1},
+			},
+			{
+				Condition:   simulation.ConditionTachycardia,
+				Dwell:       fixedDwell(2 * time.Second),
+				Parameters:  simulation.PatientParameters{BaseHeartRate: &fastRate},
+				Transitions: map[simulation.Condition]float64{simulation.ConditionNormal: 1},
+			},
+		},
+	}
+
+	controller := simulation.NewMarkovController(simulation.NewDefaultPatient(), chain)
+	controller.Seed(1)
+
+	want := []simulation.Condition{"normal", "normal", "normal", "tachycardia", "tachycardia", "normal", "normal", "normal", "tachycardia"}
+	for i, expected := range want {
+		_, condition := controller.NextReading()
+		if condition != expected {
+			t.Fatalf("Tick %d: expected %s, got %s", i, expected, condition)
+		}
+		if condition == simulation.ConditionTachycardia && controller.Patient.BaseHeartRate != 100 {
+			t.Errorf("Tick %d: expected state parameters to apply, got base HR %d", i, controller.Patient.BaseHeartRate)
+		}
+	}
+}
+
+func TestMarkovOccupancy(t *testing.T) {
+	// Alternating states with mean dwell times of 10s and 5s spend about two
+	// thirds of the time in the first.
+	chain := simulation.MarkovChain{
+		States: []simulation.MarkovState{
+			{
+				Condition:   simulation.ConditionNormal,
+				Dwell:       simulation.DwellTime{Mean: simulation.Duration(10 * time.Second)},
+				Transitions: map[simulation.Condition]float64{simulation.ConditionBradycardia: 1},
+			},
+			{
+				Condition:   simulation.ConditionBradycardia,
+				Dwell:       simulation.DwellTime{Distribution: simulation.DwellGamma, Mean: simulation.Duration(5 * time.Second), Shape: 4},
+				Transitions: map[simulation.Condition]float64{simulation.ConditionNormal: 1},
+			},
+		},
+	}
+
+	controller := simulation.NewMarkovController(simulation.NewDefaultPatient(), chain)
+	controller.Seed(7)
+
+	const ticks = 30000
+	normal := 0
+	for i := 0; i < ticks; i++ {
+		if _, condition := controller.NextReading(); condition == simulation.ConditionNormal {
+			normal++
+		}
+	}
+
+	if fraction := float64(normal) / ticks; math.Abs(fraction-2.0/3.0) > 0.05 {
+		t.Errorf("Expected about 2/3 of the time in normal, got %.3f", fraction)
+	}
+}
+
+func TestMarkovTransitionWeights(t *testing.T) {
+	chain := simulation.MarkovChain{
+		States: []simulation.MarkovState{
+			{
+				Condition: simulation.ConditionNormal,
+				Dwell:     fixedDwell(time.Second),
+				Transitions: map[simulation.Condition]float64{
+					simulation.ConditionTachycardia: 3,
+					simulation.ConditionBradycardia: 1,
+				},
+			},
+			{Condition: simulation.ConditionTachycardia, Dwell: fixedDwell(time.Second), Transitions: map[simulation.Condition]float64{simulation.ConditionNormal: 1}},
+			{Condition: simulation.ConditionBradycardia, Dwell: fixedDwell(time.Second), Transitions: map[simulation.Condition]float64{simulation.ConditionNormal: 1}},
+		},
+	}
+
+	controller := simulation.NewMarkovController(simulation.NewDefaultPatient(), chain)
+	controller.Seed(3)
+
+	counts := make(map[simulation.Condition]int)
+	for i := 0; i < 8000; i++ {
+		_, condition := controller.NextReading()
+		counts[condition]++
+	}
+
+	ratio := float64(counts[simulation.ConditionTachycardia]) / float64(counts[simulation.ConditionBradycardia])
+	if math.Abs(ratio-3) > 0.4 {
+		t.Errorf("Expected tachycardia three times as often as bradycardia, got ratio %.2f (%v)", ratio, counts)
+	}
+}
+
+func TestMarkovAbsorbingState(t *testing.T) {
+	chain := simulation.MarkovChain{
+		Initial: simulation.ConditionBradycardia,
+		States: []simulation.MarkovState{
+			{Condition: simulation.ConditionNormal, Dwell: fixedDwell(time.Second)},
+			{Condition: simulation.ConditionBradycardia, Dwell: fixedDwell(time.Second), Transitions: map[simulation.Condition]float64{simulation.ConditionNormal: 1}},
+		},
+	}
+
+	controller := simulation.NewMarkovController(simulation.NewDefaultPatient(), chain)
+	controller.Seed(1)
+
+	if _, condition := controller.NextReading(); condition != simulation.ConditionBradycardia {
+		t.Fatalf("Expected to start in the initial state, got %s", condition)
+	}
+	for i := 0; i < 20; i++ {
+		if _, condition := controller.NextReading(); condition != simulation.ConditionNormal {
+			t.Fatalf("Expected absorbing normal state, got %s", condition)
+		}
+	}
+}
+
+func TestMarkovReproducible(t *testing.T) {
+	run := func() []simulation.Condition {
+		roster := simulation.NewRoster(2)
+		roster.SetMarkov(simulation.DefaultMarkovChain())
+		roster.Seed(99)
+
+		controller, _ := roster.Get("PATIENT-2")
+		var conditions []simulation.Condition
+		for i := 0; i < 2000; i++ {
+			_, condition := controller.NextReading()
+			conditions = append(conditions, condition)
+		}
+		return conditions
+	}
+
+	first, second := run(), run()
+	changes := 0
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("Tick %d differs between seeded runs: %s vs %s", i, first[i], second[i])
+		}
+		if i > 0 && first[i] != first[i-1] {
+			changes++
+		}
+	}
+	if changes < 5 {
+		t.Errorf("Expected the default chain to change condition regularly, saw %d changes", changes)
+	}
+}
+
+func TestMarkovValidate(t *testing.T) {
+	if err := simulation.DefaultMarkovChain().Validate(); err != nil {
+		t.Fatalf("Default chain is invalid: %v", err)
+	}
+
+	normal := simulation.MarkovState{Condition: simulation.ConditionNormal, Dwell: fixedDwell(time.Second)}
+
+	tests := []struct {
+		name  string
+		chain simulation.MarkovChain
+		want  string
+	}{
+		{"empty", simulation.MarkovChain{}, "at least one state"},
+		{"unknown condition", simulation.MarkovChain{States: []simulation.MarkovState{{Condition: "sleepy", Dwell: fixedDwell(time.Second)}}}, "unknown condition"},
+		{"duplicate", simulation.MarkovChain{States: []simulation.MarkovState{normal, normal}}, "duplicate state"},
+		{"unknown initial", simulation.MarkovChain{Initial: simulation.ConditionAsystole, States: []simulation.MarkovState{normal}}, "initial"},
+		{"no dwell", simulation.MarkovChain{States: []simulation.MarkovState{{Condition: simulation.ConditionNormal}}}, "mean must be positive"},
+		{"bad uniform", simulation.MarkovChain{States: []simulation.MarkovState{{Condition: simulation.ConditionNormal, Dwell: simulation.DwellTime{Distribution: simulation.DwellUniform, Min: 5, Max: 2}}}}, "min < max"},
+		{"unknown target", simulation.MarkovChain{States: []simulation.MarkovState{{Condition: simulation.ConditionNormal, Dwell: fixedDwell(time.Second), Transitions: map[simulation.Condition]float64{simulation.ConditionAsystole: 1}}}}, "unknown state"},
+		{"negative weight", simulation.MarkovChain{States: []simulation.MarkovState{{Condition: simulation.ConditionNormal, Dwell: fixedDwell(time.Second), Transitions: map[simulation.Condition]float64{simulation.ConditionNormal: -1}}}}, "negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.chain.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestMarkovScenario(t *testing.T) {
+	scenario, err := simulation.ParseScenario([]byte(`{
+  "patients": [
+    {
+      "id": "SOAK-1",
+      "markov": {
+        "initial": "tachycardia",
+        "states": [
+          { "condition": "normal", "dwell": { "mean": "30s" }, "transitions": { "tachycardia": 1 } },
+          { "condition": "tachycardia", "dwell": { "distribution": "uniform", "min": "5s", "max": "10s" }, "transitions": { "normal": 1 } }
+        ]
+      }
+    }
+  ]
+}`))
+	if err != nil {
+		t.Fatalf("Failed to parse Markov scenario: %v", err)
+	}
+
+	roster, err := scenario.Roster(time.Second)
+	if err != nil {
+		t.Fatalf("Failed to build roster: %v", err)
+	}
+	controller, _ := roster.Get("SOAK-1")
+	if _, condition := controller.NextReading(); condition != simulation.ConditionTachycardia {
+		t.Errorf("Expected to start in tachycardia, got %s", condition)
+	}
+
+	_, err = simulation.ParseScenario([]byte(`{"patients": [{"id": "X", "steps": [{"condition": "normal", "duration": "1s"}], "markov": {"states": [{"condition": "normal", "dwell": {"mean": "1s"}}]}}]}`))
+	if err == nil || !strings.Contains(err.Error(), "mutually exclusive") {
+		t.Errorf("Expected steps and markov to be rejected together, got %v", err)
+	}
+}
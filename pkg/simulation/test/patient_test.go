@@ -105,3 +105,51 @@ func TestGenerateECGReading(t *testing.T) {
 		}
 	})
 }
+
+func TestDemographicsDriveSimulatedRanges(t *testing.T) {
+	neonate := ecg.Demographics{AgeGroup: ecg.AgeNeonate}
+	patient := simulation.PatientParameters{Demographics: &neonate}.Apply(simulation.NewDefaultPatient())
+	patient.Rand = simulation.NewRandomSource(1)
+
+	if want := neonate.ReferenceRange().RestingHeartRate; patient.BaseHeartRate != want {
+		t.Errorf("Expected a neonatal resting rate of %d, got %d", want, patient.BaseHeartRate)
+	}
+
+	for _, tt := range []struct {
+		set  func(*simulation.SimulatedPatient)
+		want string
+	}{
+		{func(p *simulation.SimulatedPatient) {}, ecg.ConditionNormal},
+		{func(p *simulation.SimulatedPatient) { p.SimulateTachycardia = true }, ecg.ConditionTachycardia},
+		{func(p *simulation.SimulatedPatient) { p.SimulateBradycardia = true }, ecg.ConditionBradycardia},
+	} {
+		p := patient
+		tt.set(&p)
+		for i := 0; i < 20; i++ {
+			reading := simulation.GenerateECGReading(p)
+			if got := ecg.AnalyzeReadingFor(reading, neonate); got.Type != tt.want {
+				t.Errorf("Expected %s for a neonate, got %s (%s)", tt.want, got.Type, got.Description)
+			}
+		}
+	}
+}
+
+func TestPacemakerPacesBradycardia(t *testing.T) {
+	patient := simulation.NewDefaultPatient()
+	patient.Demographics = &ecg.Demographics{PacemakerRate: 60}
+	patient.SimulateBradycardia = true
+	patient.Rand = simulation.NewRandomSource(1)
+
+	for i := 0; i < 20; i++ {
+		reading := simulation.GenerateECGReading(patient)
+		if reading.HeartRate != 60 || reading.RRInterval != 1 {
+			t.Fatalf("Expected bradycardia paced at 60 BPM, got HR=%d RR=%.2f", reading.HeartRate, reading.RRInterval)
+		}
+	}
+
+	patient.SimulateBradycardia = false
+	patient.SimulateAsystole = true
+	if reading := simulation.GenerateECGReading(patient); reading.HeartRate != 0 {
+		t.Errorf("Expected no paced rhythm in asystole, got HR=%d", reading.HeartRate)
+	}
+}
@@ -0,0 +1,126 @@
+package simulation_test
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"arhm/ecg-monitoring/pkg/simulation"
+)
+
+func hours(h float64) time.Duration {
+	return time.Duration(h * float64(time.Hour))
+}
+
+func TestDailyProfileActivity(t *testing.T) {
+	profile := simulation.NewDefaultDailyProfile()
+
+	tests := []struct {
+		at   time.Duration
+		want simulation.Activity
+	}{
+		{hours(2), simulation.ActivitySleep},
+		{hours(23.5), simulation.ActivitySleep},
+		{hours(7), simulation.ActivityRest},
+		{hours(7.75), simulation.ActivityWalking},
+		{hours(10), simulation.ActivityRest},
+		{hours(18.5), simulation.ActivityExercise},
+	}
+
+	for _, tt := range tests {
+		if got := profile.Activity(tt.at); got != tt.want {
+			t.Errorf("At %v: expected %s, got %s", tt.at, tt.want, got)
+		}
+	}
+
+	if got := profile.TimeOfDay(hours(20)); got != hours(4) {
+		t.Errorf("Expected 20h after an 08:00 start to be 04:00, got %v", got)
+	}
+}
+
+// simulateDay records sinus rhythm readings once a minute over a simulated
+// day, grouped by the hour of day each was taken at.
+func simulateDay(t *testing.T, profile simulation.DailyProfile) [24][]float64 {
+	t.Helper()
+
+	patient := simulation.NewDefaultPatient()
+	patient.Profile = &profile
+	controller := simulation.NewStepController(patient, []simulation.Step{
+		{Condition: simulation.ConditionNormal, Ticks: 1, Next: -1},
+	})
+	controller.Interval = time.Minute
+	controller.Seed(5)
+
+	var byHour [24][]float64
+	for i := 0; i < 24*60; i++ {
+		timeOfDay, ok := controller.TimeOfDay()
+		if !ok {
+			t.Fatal("Expected a time of day for a patient with a profile")
+		}
+		reading, _ := controller.NextReading()
+		hour := int(timeOfDay / time.Hour)
+		byHour[hour] = append(byHour[hour], float64(reading.HeartRate))
+	}
+	return byHour
+}
+
+func TestDailyProfileActivityModulation(t *testing.T) {
+	byHour := simulateDay(t, simulation.NewDefaultDailyProfile())
+
+	sleepMean, sleepStd := meanStdDev(byHour[2])
+	restMean, _ := meanStdDev(byHour[10])
+	exerciseMean, exerciseStd := meanStdDev(byHour[18][:45])
+
+	if !(sleepMean < restMean-8 && restMean < exerciseMean-40) {
+		t.Errorf("Expected sleep < rest < exercise heart rates, got %.1f, %.1f, %.1f", sleepMean, restMean, exerciseMean)
+	}
+	if sleepStd <= exerciseStd {
+		t.Errorf("Expected wider variability asleep than exercising, got %.2f vs %.2f", sleepStd, exerciseStd)
+	}
+}
+
+func TestDailyProfileCircadian(t *testing.T) {
+	byHour := simulateDay(t, simulation.DailyProfile{
+		CircadianAmplitude: 10,
+		Nadir:              simulation.Duration(hours(4)),
+	})
+
+	nadir, _ := meanStdDev(byHour[4])
+	peak, _ := meanStdDev(byHour[16])
+
+	if diff := peak - nadir; math.Abs(diff-20) > 4 {
+		t.Errorf("Expected about 20 BPM between nadir and peak, got %.1f (%.1f vs %.1f)", diff, nadir, peak)
+	}
+}
+
+func TestDailyProfileValidate(t *testing.T) {
+	if err := simulation.NewDefaultDailyProfile().Validate(); err != nil {
+		t.Fatalf("Default profile is invalid: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		profile simulation.DailyProfile
+		want    string
+	}{
+		{"start", simulation.DailyProfile{Start: simulation.Duration(25 * time.Hour)}, "start"},
+		{"amplitude", simulation.DailyProfile{CircadianAmplitude: -1}, "circadian_amplitude"},
+		{"activity", simulation.DailyProfile{Activities: []simulation.ActivityPeriod{{Activity: "dancing", End: simulation.Duration(time.Hour)}}}, "unknown activity"},
+		{"empty period", simulation.DailyProfile{Activities: []simulation.ActivityPeriod{{Activity: simulation.ActivitySleep}}}, "must differ"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.profile.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+
+	_, err := simulation.ParseScenario([]byte(`{"patients": [{"id": "HOLTER", "parameters": {"profile": {"start": "8h", "activities": [{"activity": "sleep", "start": "23h", "end": "7h"}]}}, "steps": [{"condition": "normal", "duration": "24h"}]}]}`))
+	if err != nil {
+		t.Errorf("Failed to parse scenario with a profile: %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package simulation_test
+
+import (
+	"testing"
+
+	"arhm/ecg-monitoring/pkg/ecg"
+	"arhm/ecg-monitoring/pkg/simulation"
+)
+
+func collectReadings(controller *simulation.Controller, n int) []ecg.ECGReading {
+	readings := make([]ecg.ECGReading, n)
+	for i := range readings {
+		readings[i], _ = controller.NextReading()
+	}
+	return readings
+}
+
+func TestSeededControllerIsReproducible(t *testing.T) {
+	first := simulation.NewController()
+	first.Seed(7)
+	second := simulation.NewController()
+	second.Seed(7)
+	other := simulation.NewController()
+	other.Seed(8)
+
+	a := collectReadings(first, 36)
+	b := collectReadings(second, 36)
+	c := collectReadings(other, 36)
+
+	differs := false
+	for i := range a {
+		if a[i].HeartRate != b[i].HeartRate || a[i].RRInterval != b[i].RRInterval {
+			t.Errorf("Reading %d differs for the same seed: %+v vs %+v", i, a[i], b[i])
+		}
+		if a[i].HeartRate != c[i].HeartRate || a[i].RRInterval != c[i].RRInterval {
+			differs = true
+		}
+	}
+
+	if !differs {
+		t.Error("Expected different seeds to produce different readings")
+	}
+}
+
+func TestSeededControllerGolden(t *testing.T) {
+	controller := simulation.NewController()
+	controller.Seed(42)
+
+	expected := []struct {
+		heartRate int
+		condition simulation.Condition
+	}{
+		{80, simulation.ConditionNormal},
+		{83, simulation.ConditionNormal},
+		{78, simulation.ConditionNormal},
+		{103, simulation.ConditionTachycardia},
+		{108, simulation.ConditionTachycardia},
+		{129, simulation.ConditionTachycardia},
+	}
+
+	for i, e := range expected {
+		reading, condition := controller.NextReading()
+		if reading.HeartRate != e.heartRate || condition != e.condition {
+			t.Errorf("Reading %d: Expected HR=%d (%s), got HR=%d (%s)",
+				i, e.heartRate, e.condition, reading.HeartRate, condition)
+		}
+	}
+}
+
+func TestRosterSeed(t *testing.T) {
+	first := simulation.NewRoster(2)
+	first.Seed(99)
+	second := simulation.NewRoster(2)
+	second.Seed(99)
+
+	for _, id := range first.PatientIDs() {
+		a, _ := first.Get(id)
+		b, _ := second.Get(id)
+
+		ra := collectReadings(a, 10)
+		rb := collectReadings(b, 10)
+		for i := range ra {
+			if ra[i].HeartRate != rb[i].HeartRate || ra[i].RRInterval != rb[i].RRInterval {
+				t.Errorf("%s reading %d differs for the same roster seed", id, i)
+			}
+		}
+	}
+
+	p1, _ := first.Get("PATIENT-1")
+	p2, _ := first.Get("PATIENT-2")
+	p2.CycleIndex = p1.CycleIndex
+	r1 := collectReadings(p1, 10)
+	r2 := collectReadings(p2, 10)
+	same := true
+	for i := range r1 {
+		if r1[i].RRInterval != r2[i].RRInterval {
+			same = false
+		}
+	}
+	if same {
+		t.Error("Expected patients in a roster to receive distinct seeds")
+	}
+}
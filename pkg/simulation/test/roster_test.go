@@ -0,0 +1,60 @@
+package simulation_test
+
+import (
+	"testing"
+
+	"arhm/ecg-monitoring/pkg/simulation"
+)
+
+func TestNewRoster(t *testing.T) {
+	roster := simulation.NewRoster(3)
+
+	if roster.Len() != 3 {
+		t.Fatalf("Expected 3 patients, got %d", roster.Len())
+	}
+
+	expectedIDs := []string{"PATIENT-1", "PATIENT-2", "PATIENT-3"}
+	for i, id := range roster.PatientIDs() {
+		if id != expectedIDs[i] {
+			t.Errorf("Expected patient %d to be %s, got %s", i, expectedIDs[i], id)
+		}
+
+		controller, ok := roster.Get(id)
+		if !ok {
+			t.Fatalf("Patient %s not found in roster", id)
+		}
+
+		reading, _ := controller.NextReading()
+		if reading.PatientID != id {
+			t.Errorf("Expected reading for %s, got %s", id, reading.PatientID)
+		}
+	}
+
+	first, _ := roster.Get("PATIENT-1")
+	second, _ := roster.Get("PATIENT-2")
+	if first.CycleIndex == second.CycleIndex {
+		t.Error("Expected patients to start at different points of their condition cycle")
+	}
+}
+
+func TestRosterAdd(t *testing.T) {
+	roster := simulation.NewRoster(0)
+
+	if err := roster.Add(simulation.NewController()); err != nil {
+		t.Fatalf("Unexpected error adding controller: %v", err)
+	}
+
+	if err := roster.Add(simulation.NewController()); err == nil {
+		t.Error("Expected error adding duplicate patient ID")
+	}
+
+	unnamed := simulation.NewController()
+	unnamed.Patient.ID = ""
+	if err := roster.Add(unnamed); err == nil {
+		t.Error("Expected error adding patient without ID")
+	}
+
+	if _, ok := roster.Get("UNKNOWN"); ok {
+		t.Error("Expected unknown patient lookup to fail")
+	}
+}
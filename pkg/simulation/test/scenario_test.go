@@ -0,0 +1,163 @@
+package simulation_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"arhm/ecg-monitoring/pkg/simulation"
+)
+
+const testScenario = `{
+  "name": "test",
+  "patients": [
+    {
+      "id": "BED-1",
+      "parameters": { "base_heart_rate": 70 },
+      "loop": true,
+      "steps": [
+        { "name": "rest", "condition": "normal", "duration": "2s" },
+        { "name": "fast", "condition": "tachycardia", "duration": "1s", "parameters": { "base_heart_rate": 95 } },
+        { "name": "slow", "condition": "bradycardia", "duration": "1s", "next": "fast" }
+      ]
+    },
+    {
+      "id": "BED-2",
+      "steps": [
+        { "condition": "normal", "duration": "1s" },
+        { "condition": "arrhythmia", "duration": "1s" }
+      ]
+    }
+  ]
+}`
+
+func TestLoadScenario(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scenario.json")
+	if err := os.WriteFile(path, []byte(testScenario), 0644); err != nil {
+		t.Fatalf("Failed to write scenario: %v", err)
+	}
+
+	scenario, err := simulation.LoadScenario(path)
+	if err != nil {
+		t.Fatalf("Failed to load scenario: %v", err)
+	}
+
+	if scenario.Name != "test" || len(scenario.Patients) != 2 {
+		t.Fatalf("Unexpected scenario contents: %+v", scenario)
+	}
+
+	if time.Duration(scenario.Patients[0].Steps[0].Duration) != 2*time.Second {
+		t.Errorf("Expected first step duration 2s, got %v", time.Duration(scenario.Patients[0].Steps[0].Duration))
+	}
+}
+
+func TestScenarioTimeline(t *testing.T) {
+	scenario, err := simulation.ParseScenario([]byte(testScenario))
+	if err != nil {
+		t.Fatalf("Failed to parse scenario: %v", err)
+	}
+
+	roster, err := scenario.Roster(time.Second)
+	if err != nil {
+		t.Fatalf("Failed to build roster: %v", err)
+	}
+
+	bed1, ok := roster.Get("BED-1")
+	if !ok {
+		t.Fatal("BED-1 missing from roster")
+	}
+
+	expected := []simulation.Condition{
+		simulation.ConditionNormal,
+		simulation.ConditionNormal,
+		simulation.ConditionTachycardia,
+		simulation.ConditionBradycardia,
+		simulation.ConditionTachycardia,
+		simulation.ConditionBradycardia,
+	}
+	for i, expectedCondition := range expected {
+		reading, condition := bed1.NextReading()
+		if condition != expectedCondition {
+			t.Errorf("BED-1 reading %d: Expected %s, got %s", i, expectedCondition, condition)
+		}
+		if reading.PatientID != "BED-1" {
+			t.Errorf("BED-1 reading %d: Expected patient ID BED-1, got %s", i, reading.PatientID)
+		}
+		if condition == simulation.ConditionTachycardia && bed1.Patient.BaseHeartRate != 95 {
+			t.Errorf("Expected step override base heart rate 95, got %d", bed1.Patient.BaseHeartRate)
+		}
+		if condition == simulation.ConditionBradycardia && bed1.Patient.BaseHeartRate != 70 {
+			t.Errorf("Expected patient base heart rate 70 after override step, got %d", bed1.Patient.BaseHeartRate)
+		}
+	}
+
+	bed2, _ := roster.Get("BED-2")
+	expected = []simulation.Condition{
+		simulation.ConditionNormal,
+		simulation.ConditionArrhythmia,
+		simulation.ConditionArrhythmia,
+		simulation.ConditionArrhythmia,
+	}
+	for i, expectedCondition := range expected {
+		if _, condition := bed2.NextReading(); condition != expectedCondition {
+			t.Errorf("BED-2 reading %d: Expected %s, got %s", i, expectedCondition, condition)
+		}
+	}
+}
+
+func TestScenarioValidation(t *testing.T) {
+	testCase := func(name string, data string, expectedError string) {
+		t.Run(name, func(t *testing.T) {
+			_, err := simulation.ParseScenario([]byte(data))
+			if err == nil {
+				t.Fatalf("Expected error containing %q, got nil", expectedError)
+			}
+			if !strings.Contains(err.Error(), expectedError) {
+				t.Errorf("Expected error containing %q, got %q", expectedError, err.Error())
+			}
+		})
+	}
+
+	testCase("No patients", `{"patients": []}`, "at least one patient")
+	testCase("Missing ID", `{"patients": [{"steps": [{"condition": "normal", "duration": "1s"}]}]}`, "id is required")
+	testCase("Duplicate ID", `{"patients": [
+		{"id": "A", "steps": [{"condition": "normal", "duration": "1s"}]},
+		{"id": "A", "steps": [{"condition": "normal", "duration": "1s"}]}]}`, "duplicate id")
+	testCase("No steps", `{"patients": [{"id": "A"}]}`, "at least one step")
+	testCase("Unknown condition", `{"patients": [{"id": "A", "steps": [{"condition": "tachy", "duration": "1s"}]}]}`, `unknown condition "tachy"`)
+	testCase("Bad duration", `{"patients": [{"id": "A", "steps": [{"condition": "normal", "duration": "soon"}]}]}`, "invalid duration")
+	testCase("Zero duration", `{"patients": [{"id": "A", "steps": [{"condition": "normal"}]}]}`, "duration must be positive")
+	testCase("Unknown next", `{"patients": [{"id": "A", "steps": [{"condition": "normal", "duration": "1s", "next": "x"}]}]}`, `unknown step "x"`)
+	testCase("Bad parameter", `{"patients": [{"id": "A", "parameters": {"base_heart_rate": 500}, "steps": [{"condition": "normal", "duration": "1s"}]}]}`, "base_heart_rate 500 out of range")
+	testCase("Unknown field", `{"patients": [{"id": "A", "stepz": []}]}`, "unknown field")
+}
+
+func TestScenarioSeed(t *testing.T) {
+	data := []byte(`{"seed": 5, "patients": [{"id": "A", "steps": [{"condition": "arrhythmia", "duration": "1s"}]}]}`)
+
+	var sequences [2][]float64
+	for i := range sequences {
+		scenario, err := simulation.ParseScenario(data)
+		if err != nil {
+			t.Fatalf("Failed to parse scenario: %v", err)
+		}
+
+		roster, err := scenario.Roster(time.Second)
+		if err != nil {
+			t.Fatalf("Failed to build roster: %v", err)
+		}
+
+		controller, _ := roster.Get("A")
+		for _, reading := range collectReadings(controller, 10) {
+			sequences[i] = append(sequences[i], reading.RRInterval)
+		}
+	}
+
+	for i := range sequences[0] {
+		if sequences[0][i] != sequences[1][i] {
+			t.Errorf("Reading %d differs between runs of a seeded scenario", i)
+		}
+	}
+}
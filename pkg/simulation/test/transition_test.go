@@ -0,0 +1,127 @@
+package simulation_test
+
+import (
+	"testing"
+	"time"
+
+	"arhm/ecg-monitoring/pkg/ecg"
+	"arhm/ecg-monitoring/pkg/simulation"
+)
+
+func rampController(transition simulation.Transition) *simulation.Controller {
+	patient := simulation.NewDefaultPatient()
+	patient.Variability = 1
+	patient.RRVariability = 0
+
+	controller := simulation.NewStepController(patient, []simulation.Step{
+		{Condition: simulation.ConditionNormal, Ticks: 5, Next: 1},
+		{Condition: simulation.ConditionTachycardia, Ticks: 120, Next: -1},
+	})
+	controller.Transition = transition
+	controller.Seed(1)
+
+	return controller
+}
+
+func TestAbruptTransition(t *testing.T) {
+	controller := rampController(simulation.Transition{})
+
+	readings := collectReadings(controller, 6)
+	if readings[5].HeartRate <= 100 {
+		t.Errorf("Expected heart rate above 100 immediately after switching, got %d", readings[5].HeartRate)
+	}
+}
+
+func TestRampTransition(t *testing.T) {
+	controller := rampController(simulation.Transition{TimeConstant: 20 * time.Second})
+
+	readings := collectReadings(controller, 125)
+
+	if readings[5].HeartRate > 100 {
+		t.Errorf("Expected heart rate to ramp gradually, got %d one second after switching", readings[5].HeartRate)
+	}
+
+	if early := meanHeartRate(readings[6:11]); early > 100 {
+		t.Errorf("Expected mean heart rate below 100 shortly after switching, got %f", early)
+	}
+
+	if late := meanHeartRate(readings[100:125]); late < 108 {
+		t.Errorf("Expected mean heart rate near the tachycardia target after settling, got %f", late)
+	}
+
+	for i := 110; i < 125; i++ {
+		if readings[i].HeartRate <= 100 {
+			t.Errorf("Reading %d: Expected heart rate to settle in tachycardia, got %d", i, readings[i].HeartRate)
+		}
+	}
+
+	for i := 6; i < 60; i++ {
+		rr := 60.0 / float64(readings[i].HeartRate)
+		if readings[i].RRInterval < rr*0.9 || readings[i].RRInterval > rr*1.1 {
+			t.Errorf("Reading %d: RR interval %f inconsistent with HR %d", i, readings[i].RRInterval, readings[i].HeartRate)
+		}
+	}
+}
+
+func meanHeartRate(readings []ecg.ECGReading) float64 {
+	sum := 0
+	for _, reading := range readings {
+		sum += reading.HeartRate
+	}
+	return float64(sum) / float64(len(readings))
+}
+
+func TestOvershootTransition(t *testing.T) {
+	controller := rampController(simulation.Transition{TimeConstant: 10 * time.Second, Overshoot: 0.3})
+
+	readings := collectReadings(controller, 125)
+
+	peak := 0
+	for _, reading := range readings[5:60] {
+		if reading.HeartRate > peak {
+			peak = reading.HeartRate
+		}
+	}
+
+	// Target mean is 115 BPM, a 35 BPM step from 80 BPM; 30% overshoot
+	// peaks around 125 BPM before tachycardia noise.
+	if peak < 125 {
+		t.Errorf("Expected heart rate to overshoot the tachycardia target, peak was %d", peak)
+	}
+
+	last := readings[len(readings)-1].HeartRate
+	if last < 101 || last > 129 {
+		t.Errorf("Expected heart rate to settle in the tachycardia range, got %d", last)
+	}
+}
+
+func TestScenarioTransition(t *testing.T) {
+	scenario, err := simulation.ParseScenario([]byte(`{"patients": [{
+		"id": "A",
+		"transition": {"time_constant": "30s", "overshoot": 0.1},
+		"steps": [
+			{"condition": "normal", "duration": "10s"},
+			{"condition": "bradycardia", "duration": "10s", "transition": {"time_constant": "5s"}}
+		]}]}`))
+	if err != nil {
+		t.Fatalf("Failed to parse scenario: %v", err)
+	}
+
+	roster, err := scenario.Roster(time.Second)
+	if err != nil {
+		t.Fatalf("Failed to build roster: %v", err)
+	}
+
+	controller, _ := roster.Get("A")
+	if controller.Transition.TimeConstant != 30*time.Second || controller.Transition.Overshoot != 0.1 {
+		t.Errorf("Unexpected patient transition: %+v", controller.Transition)
+	}
+	if controller.Steps[1].Transition == nil || controller.Steps[1].Transition.TimeConstant != 5*time.Second {
+		t.Errorf("Expected step transition override of 5s, got %+v", controller.Steps[1].Transition)
+	}
+
+	if _, err := simulation.ParseScenario([]byte(`{"patients": [{"id": "A", "transition": {"overshoot": 1.5},
+		"steps": [{"condition": "normal", "duration": "1s"}]}]}`)); err == nil {
+		t.Error("Expected error for overshoot out of range")
+	}
+}
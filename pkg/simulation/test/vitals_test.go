@@ -0,0 +1,150 @@
+package simulation_test
+
+import (
+	"testing"
+	"time"
+
+	"arhm/ecg-monitoring/pkg/ecg"
+	"arhm/ecg-monitoring/pkg/simulation"
+)
+
+// vitalsController runs the patient with vitals through the given steps, one
+// reading per second.
+func vitalsController(vitals simulation.VitalsParameters, steps []simulation.Step) *simulation.Controller {
+	patient := simulation.NewDefaultPatient()
+	patient.Vitals = &vitals
+	controller := simulation.NewStepController(patient, steps)
+	controller.Interval = time.Second
+	controller.Seed(11)
+	return controller
+}
+
+func collectVitals(t *testing.T, controller *simulation.Controller, n int) []*ecg.Vitals {
+	t.Helper()
+
+	vitals := make([]*ecg.Vitals, n)
+	for i := range vitals {
+		reading, _ := controller.NextReading()
+		if reading.Vitals == nil {
+			t.Fatalf("Reading %d has no vitals", i)
+		}
+		vitals[i] = reading.Vitals
+	}
+	return vitals
+}
+
+func meanSpO2(vitals []*ecg.Vitals) float64 {
+	values := make([]float64, len(vitals))
+	for i, v := range vitals {
+		values[i] = float64(v.SpO2)
+	}
+	mean, _ := meanStdDev(values)
+	return mean
+}
+
+func TestVitalsDesaturateDuringBradycardia(t *testing.T) {
+	controller := vitalsController(simulation.NewDefaultVitals(), []simulation.Step{
+		{Condition: simulation.ConditionNormal, Ticks: 60, Next: 1},
+		{Condition: simulation.ConditionBradycardia, Ticks: 120, Next: 1},
+	})
+	vitals := collectVitals(t, controller, 180)
+
+	normal := meanSpO2(vitals[30:60])
+	onset := meanSpO2(vitals[60:63])
+	brady := meanSpO2(vitals[150:180])
+
+	if normal < 97 || normal > 99 {
+		t.Errorf("Expected SpO2 near the 98%% baseline in sinus rhythm, got %.1f", normal)
+	}
+	if normal-brady < 3 {
+		t.Errorf("Expected desaturation during bradycardia, got %.1f vs %.1f", normal, brady)
+	}
+	if normal-onset > 1.5 {
+		t.Errorf("Expected SpO2 to fall gradually, got %.1f at onset vs %.1f before", onset, normal)
+	}
+}
+
+func TestVitalsWithoutCardiacOutput(t *testing.T) {
+	vitals := simulation.NewDefaultVitals()
+	vitals.ABP = true
+	controller := vitalsController(vitals, []simulation.Step{
+		{Condition: simulation.ConditionVentricularFibrillation, Ticks: 1, Next: 0},
+	})
+	frames := collectVitals(t, controller, 60)
+
+	for i, v := range frames {
+		if v.PulseRate != 0 {
+			t.Errorf("Reading %d: expected no pulse in VF, got %d", i, v.PulseRate)
+		}
+		if v.NIBP != nil {
+			t.Errorf("Reading %d: expected the cuff to fail without a pulse, got %s", i, v.NIBP)
+		}
+	}
+	if last := frames[len(frames)-1]; last.ABP == nil || last.ABP.Systolic > 30 {
+		t.Errorf("Expected arterial pressure to collapse, got %v", last.ABP)
+	}
+}
+
+func TestVitalsPulseRateFollowsHeartRate(t *testing.T) {
+	controller := vitalsController(simulation.NewDefaultVitals(), []simulation.Step{
+		{Condition: simulation.ConditionNormal, Ticks: 1, Next: 0},
+	})
+
+	for i := 0; i < 30; i++ {
+		reading, _ := controller.NextReading()
+		if diff := reading.Vitals.PulseRate - reading.HeartRate; diff < -4 || diff > 4 {
+			t.Errorf("Reading %d: pulse rate %d far from heart rate %d", i, reading.Vitals.PulseRate, reading.HeartRate)
+		}
+	}
+}
+
+func TestVitalsNIBPInterval(t *testing.T) {
+	controller := vitalsController(simulation.NewDefaultVitals(), []simulation.Step{
+		{Condition: simulation.ConditionNormal, Ticks: 1, Next: 0},
+	})
+	frames := collectVitals(t, controller, 601)
+
+	var measured []int
+	for i, v := range frames {
+		if v.ABP != nil {
+			t.Fatalf("Reading %d: unexpected arterial pressure without an arterial line", i)
+		}
+		if v.NIBP != nil {
+			measured = append(measured, i)
+		}
+	}
+
+	if len(measured) != 3 || measured[0] != 0 || measured[1] != 300 || measured[2] != 600 {
+		t.Errorf("Expected cuff measurements at 0s, 5m and 10m, got readings %v", measured)
+	}
+	if bp := frames[0].NIBP; bp.Systolic < 105 || bp.Systolic > 135 || bp.Diastolic >= bp.Systolic {
+		t.Errorf("Implausible first measurement %s", bp)
+	}
+}
+
+func TestVitalsDisabledByDefault(t *testing.T) {
+	controller := simulation.NewPatientController(simulation.NewDefaultPatient())
+	if reading, _ := controller.NextReading(); reading.Vitals != nil {
+		t.Errorf("Expected no vitals unless enabled, got %+v", reading.Vitals)
+	}
+}
+
+func TestVitalsParametersValidate(t *testing.T) {
+	if err := simulation.NewDefaultVitals().Validate(); err != nil {
+		t.Fatalf("Default vitals are invalid: %v", err)
+	}
+
+	invalid := []func(*simulation.VitalsParameters){
+		func(p *simulation.VitalsParameters) { p.SpO2 = 101 },
+		func(p *simulation.VitalsParameters) { p.RespirationRate = 2 },
+		func(p *simulation.VitalsParameters) { p.Diastolic = p.Systolic },
+		func(p *simulation.VitalsParameters) { p.NIBPInterval = -1 },
+	}
+	for i, modify := range invalid {
+		p := simulation.NewDefaultVitals()
+		modify(&p)
+		if p.Validate() == nil {
+			t.Errorf("Case %d: expected a validation error for %+v", i, p)
+		}
+	}
+}
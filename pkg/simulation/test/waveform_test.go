@@ -0,0 +1,121 @@
+package simulation_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"arhm/ecg-monitoring/pkg/ecg"
+	"arhm/ecg-monitoring/pkg/simulation"
+)
+
+func TestNewWaveformGenerator(t *testing.T) {
+	if _, err := simulation.NewWaveformGenerator(simulation.NewDefaultPatient(), 0); err == nil {
+		t.Error("Expected error for zero sample rate")
+	}
+
+	generator, err := simulation.NewWaveformGenerator(simulation.NewDefaultPatient(), simulation.SampleRate500)
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+
+	if len(generator.Morphology) != 5 {
+		t.Errorf("Expected 5 wave components, got %d", len(generator.Morphology))
+	}
+}
+
+func TestWaveformGenerate(t *testing.T) {
+	for _, rate := range []int{simulation.SampleRate250, simulation.SampleRate500, simulation.SampleRate1000} {
+		generator, err := simulation.NewWaveformGenerator(simulation.NewDefaultPatient(), rate)
+		if err != nil {
+			t.Fatalf("Failed to create generator: %v", err)
+		}
+
+		samples, beats := generator.Generate(10 * time.Second)
+
+		if len(samples) != 10*rate {
+			t.Errorf("%d Hz: Expected %d samples, got %d", rate, 10*rate, len(samples))
+		}
+
+		if len(beats) < 8 || len(beats) > 16 {
+			t.Errorf("%d Hz: Expected 8-16 beats in 10s, got %d", rate, len(beats))
+		}
+
+		for i := 1; i < len(beats); i++ {
+			gap := (beats[i].Time - beats[i-1].Time).Seconds()
+			if math.Abs(gap-beats[i].RR) > 1e-6 {
+				t.Errorf("%d Hz: Beat %d: R-R gap %f does not match RR %f", rate, i, gap, beats[i].RR)
+			}
+		}
+
+		for _, beat := range beats {
+			idx := int(math.Round(beat.Time.Seconds() * float64(rate)))
+			if idx >= len(samples) {
+				continue
+			}
+			if samples[idx] < 0.8 {
+				t.Errorf("%d Hz: Expected R peak above 0.8 mV at %v, got %f", rate, beat.Time, samples[idx])
+			}
+		}
+	}
+}
+
+// The QRS detector should find the R peaks the waveform places, including
+// the irregular ones of AF.
+func TestWaveformQRSDetection(t *testing.T) {
+	for _, condition := range []simulation.Condition{simulation.ConditionNormal, simulation.ConditionAtrialFibrillation} {
+		controller := simulation.NewStepController(simulation.NewDefaultPatient(), []simulation.Step{
+			{Condition: condition, Ticks: 1, Next: -1},
+		})
+		controller.Seed(3)
+		controller.Clock = simulation.NewBatchClock(clockStart)
+		controller.SetBeats(true)
+		if err := controller.SetWaveform(simulation.SampleRate500); err != nil {
+			t.Fatalf("Failed to enable waveform: %v", err)
+		}
+		detector, err := ecg.NewQRSDetector(simulation.SampleRate500)
+		if err != nil {
+			t.Fatalf("NewQRSDetector failed: %v", err)
+		}
+
+		readings := make(chan ecg.ECGReading, 4)
+		ticker := controller.RunWithCallback(time.Second, func(reading ecg.ECGReading, _ simulation.Condition) {
+			readings <- reading
+		})
+
+		var simulated, detected []ecg.Beat
+		for reading := range readings {
+			if reading.Timestamp.After(clockStart.Add(time.Minute)) {
+				break
+			}
+			simulated = append(simulated, reading.Beats...)
+			reading, err := detector.Detect(reading)
+			if err != nil {
+				t.Fatalf("Detect failed: %v", err)
+			}
+			detected = append(detected, reading.Beats...)
+		}
+		ticker.Stop()
+
+		// Skip the detector's learning period.
+		matched, total := 0, 0
+		for _, beat := range simulated {
+			if beat.Timestamp.Before(clockStart.Add(4 * time.Second)) {
+				continue
+			}
+			total++
+			for _, found := range detected {
+				if math.Abs(found.Timestamp.Sub(beat.Timestamp).Seconds()) < 0.02 {
+					matched++
+					break
+				}
+			}
+		}
+		if matched < total*98/100 {
+			t.Errorf("%s: detected %d of %d simulated beats", condition, matched, total)
+		}
+		if len(detected) > len(simulated) {
+			t.Errorf("%s: detected %d beats where %d were simulated", condition, len(detected), len(simulated))
+		}
+	}
+}
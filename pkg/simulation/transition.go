@@ -0,0 +1,79 @@
+package simulation
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Transition describes how the heart rate baseline moves toward a new
+// condition's target. TimeConstant is the time for the response to settle to
+// within 1/e of the change; Overshoot is the fraction of the change by which
+// the response passes the target before settling (0 for a plain exponential
+// approach). A zero TimeConstant switches abruptly.
+type Transition struct {
+	TimeConstant time.Duration
+	Overshoot    float64
+}
+
+type TransitionConfig struct {
+	TimeConstant Duration `json:"time_constant"`
+	Overshoot    float64  `json:"overshoot"`
+}
+
+func (t TransitionConfig) Validate() error {
+	if t.TimeConstant < 0 {
+		return fmt.Errorf("time_constant must not be negative")
+	}
+	if t.Overshoot < 0 || t.Overshoot >= 1 {
+		return fmt.Errorf("overshoot %g out of range [0, 1)", t.Overshoot)
+	}
+	return nil
+}
+
+func (t TransitionConfig) Transition() Transition {
+	return Transition{
+		TimeConstant: time.Duration(t.TimeConstant),
+		Overshoot:    t.Overshoot,
+	}
+}
+
+// transitionState integrates the baseline heart rate as a first-order lag, or
+// as an underdamped second-order system when overshoot is requested.
+type transitionState struct {
+	initialized bool
+	value       float64
+	velocity    float64
+}
+
+func (s *transitionState) step(target float64, transition Transition, dt time.Duration) float64 {
+	if !s.initialized || transition.TimeConstant <= 0 {
+		s.initialized = true
+		s.value = target
+		s.velocity = 0
+		return s.value
+	}
+
+	tau := transition.TimeConstant.Seconds()
+	seconds := dt.Seconds()
+
+	if transition.Overshoot <= 0 {
+		s.value += (target - s.value) * (1 - math.Exp(-seconds/tau))
+		s.velocity = 0
+		return s.value
+	}
+
+	logOvershoot := math.Log(transition.Overshoot)
+	zeta := -logOvershoot / math.Sqrt(math.Pi*math.Pi+logOvershoot*logOvershoot)
+	omega := 1 / (zeta * tau)
+
+	substeps := int(math.Ceil(seconds*omega*20)) + 1
+	h := seconds / float64(substeps)
+	for i := 0; i < substeps; i++ {
+		acceleration := omega*omega*(target-s.value) - 2*zeta*omega*s.velocity
+		s.velocity += acceleration * h
+		s.value += s.velocity * h
+	}
+
+	return s.value
+}
@@ -0,0 +1,179 @@
+package simulation
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"arhm/ecg-monitoring/pkg/ecg"
+)
+
+// VitalsParameters enable the simulation of SpO2, pleth pulse rate,
+// respiration and blood pressure alongside the ECG. The values are the
+// patient's baseline in sinus rhythm at rest.
+type VitalsParameters struct {
+	SpO2            int      `json:"spo2"`
+	RespirationRate float64  `json:"respiration_rate"`
+	Systolic        int      `json:"systolic"`
+	Diastolic       int      `json:"diastolic"`
+	NIBPInterval    Duration `json:"nibp_interval"` // Cuff cycle; zero disables NIBP
+	ABP             bool     `json:"abp"`           // Continuous arterial pressure
+}
+
+func NewDefaultVitals() VitalsParameters {
+	return VitalsParameters{
+		SpO2:            98,
+		RespirationRate: 15,
+		Systolic:        120,
+		Diastolic:       80,
+		NIBPInterval:    Duration(5 * time.Minute),
+	}
+}
+
+func (p VitalsParameters) Validate() error {
+	if p.SpO2 < 70 || p.SpO2 > 100 {
+		return fmt.Errorf("spo2 %d out of range [70, 100]", p.SpO2)
+	}
+	if p.RespirationRate < 4 || p.RespirationRate > 60 {
+		return fmt.Errorf("respiration_rate %g out of range [4, 60]", p.RespirationRate)
+	}
+	if p.Systolic < 60 || p.Systolic > 250 {
+		return fmt.Errorf("systolic %d out of range [60, 250]", p.Systolic)
+	}
+	if p.Diastolic < 30 || p.Diastolic >= p.Systolic {
+		return fmt.Errorf("diastolic %d must be at least 30 and below systolic", p.Diastolic)
+	}
+	if p.NIBPInterval < 0 {
+		return fmt.Errorf("nibp_interval must not be negative")
+	}
+	return nil
+}
+
+// vitalsTarget is where the vitals settle for the patient's current state.
+// Pulse is the fraction of ECG beats that produce a detectable pulse.
+type vitalsTarget struct {
+	spo2        float64
+	respiration float64
+	systolic    float64
+	diastolic   float64
+	pulse       float64
+}
+
+func targetVitals(patient SimulatedPatient) vitalsTarget {
+	p := *patient.Vitals
+
+	// Activity from the daily profile raises breathing and pressure along
+	// with the heart rate.
+	t := vitalsTarget{
+		spo2:        float64(p.SpO2),
+		respiration: p.RespirationRate + patient.rateOffset/4,
+		systolic:    float64(p.Systolic) + patient.rateOffset*0.6,
+		diastolic:   float64(p.Diastolic) + patient.rateOffset*0.15,
+		pulse:       1,
+	}
+
+	switch {
+	case patient.SimulateAsystole, patient.SimulateVentricularFibrillation:
+		// No cardiac output: saturation falls, breathing stops and the
+		// arterial line flattens.
+		return vitalsTarget{spo2: 60, respiration: 0, systolic: 15, diastolic: 10, pulse: 0}
+	case patient.SimulateVentricularTachycardia:
+		return vitalsTarget{spo2: t.spo2 - 10, respiration: t.respiration + 8, systolic: 70, diastolic: 45, pulse: 0.6}
+	case patient.SimulateTachycardia:
+		t.spo2 -= 1
+		t.respiration += 4
+		t.systolic += 10
+		t.diastolic += 5
+	case patient.SimulateBradycardia:
+		t.spo2 -= 5
+		t.respiration -= 2
+		t.systolic -= 15
+		t.diastolic -= 10
+	case patient.SimulateAtrialFibrillation:
+		// Short filling times leave some beats without a palpable pulse.
+		t.spo2 -= 2
+		t.systolic -= 8
+		t.diastolic -= 2
+		t.pulse = 0.85
+	case patient.SimulateArrhythmia:
+		t.spo2 -= 1
+		t.pulse = 0.95
+	}
+
+	return t
+}
+
+// vitalsState follows each parameter toward its target with a first-order
+// lag, so that for instance desaturation builds up over an episode.
+type vitalsState struct {
+	spo2        float64
+	respiration float64
+	systolic    float64
+	diastolic   float64
+	sinceNIBP   time.Duration
+	measured    bool
+}
+
+const (
+	spo2TimeConstant        = 20 * time.Second
+	respirationTimeConstant = 10 * time.Second
+	pressureTimeConstant    = 8 * time.Second
+)
+
+func newVitalsState(patient SimulatedPatient) *vitalsState {
+	t := targetVitals(patient)
+	return &vitalsState{spo2: t.spo2, respiration: t.respiration, systolic: t.systolic, diastolic: t.diastolic}
+}
+
+func approach(value, target float64, dt, tau time.Duration) float64 {
+	return target + (value-target)*math.Exp(-float64(dt)/float64(tau))
+}
+
+func (s *vitalsState) next(patient SimulatedPatient, heartRate int, dt time.Duration) *ecg.Vitals {
+	rng := patient.random()
+	t := targetVitals(patient)
+
+	s.spo2 = approach(s.spo2, t.spo2, dt, spo2TimeConstant)
+	s.respiration = approach(s.respiration, t.respiration, dt, respirationTimeConstant)
+	s.systolic = approach(s.systolic, t.systolic, dt, pressureTimeConstant)
+	s.diastolic = approach(s.diastolic, t.diastolic, dt, pressureTimeConstant)
+
+	vitals := &ecg.Vitals{
+		SpO2:            int(math.Round(math.Min(s.spo2+0.3*rng.NormFloat64(), 100))),
+		PulseRate:       0,
+		RespirationRate: int(math.Round(math.Max(s.respiration+0.5*rng.NormFloat64(), 0))),
+	}
+	if t.pulse > 0 && heartRate > 0 {
+		vitals.PulseRate = int(math.Round(float64(heartRate)*t.pulse + rng.NormFloat64()))
+	}
+
+	systolic := s.systolic + 1.5*rng.NormFloat64()
+	diastolic := s.diastolic + 1.5*rng.NormFloat64()
+
+	if patient.Vitals.ABP {
+		vitals.ABP = bloodPressure(systolic, diastolic)
+	}
+
+	// The cuff cycles from the first reading on; without a pulse the
+	// measurement fails and is retried on the next cycle.
+	interval := time.Duration(patient.Vitals.NIBPInterval)
+	s.sinceNIBP += dt
+	if interval > 0 && (!s.measured || s.sinceNIBP >= interval) {
+		s.measured = true
+		s.sinceNIBP = 0
+		if vitals.PulseRate > 0 {
+			vitals.NIBP = bloodPressure(systolic+3*rng.NormFloat64(), diastolic+3*rng.NormFloat64())
+		}
+	}
+
+	return vitals
+}
+
+func bloodPressure(systolic, diastolic float64) *ecg.BloodPressure {
+	diastolic = math.Min(diastolic, systolic-5)
+	return &ecg.BloodPressure{
+		Systolic:  int(math.Round(systolic)),
+		Diastolic: int(math.Round(diastolic)),
+		Mean:      int(math.Round(diastolic + (systolic-diastolic)/3)),
+	}
+}
@@ -0,0 +1,379 @@
+package simulation
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"arhm/ecg-monitoring/pkg/ecg"
+)
+
+const (
+	SampleRate250  = 250
+	SampleRate500  = 500
+	SampleRate1000 = 1000
+)
+
+// WaveComponent is one Gaussian event (P, Q, R, S or T) on the limit cycle of
+// the ECGSYN dynamical model. Angle is measured in radians relative to the R
+// peak, Amplitude is the single-lead amplitude in millivolts and Width is the
+// Gaussian width in radians. Vector is the event's cardiac dipole, from which
+// the individual leads are derived.
+type WaveComponent struct {
+	Name      string
+	Angle     float64
+	Amplitude float64
+	Width     float64
+	Vector    Vector
+}
+
+func DefaultMorphology() []WaveComponent {
+	return []WaveComponent{
+		{Name: "P", Angle: -70 * math.Pi / 180, Amplitude: 0.15, Width: 0.25, Vector: Vector{0.08, 0.12, -0.02}},
+		{Name: "Q", Angle: -15 * math.Pi / 180, Amplitude: -0.15, Width: 0.1, Vector: Vector{-0.1, -0.1, -0.2}},
+		{Name: "R", Angle: 0, Amplitude: 1.2, Width: 0.1, Vector: Vector{0.55, 0.95, 0.3}},
+		{Name: "S", Angle: 15 * math.Pi / 180, Amplitude: -0.25, Width: 0.1, Vector: Vector{-0.15, -0.2, 0.35}},
+		{Name: "T", Angle: 100 * math.Pi / 180, Amplitude: 0.3, Width: 0.4, Vector: Vector{0.2, 0.25, -0.1}},
+	}
+}
+
+// Beat marks an R peak in the generated signal. Time is the offset from the
+// first sample and RR is the interval to the previous R peak.
+type Beat struct {
+	Time time.Duration
+	RR   float64
+	Type ecg.BeatType
+}
+
+// WaveformGenerator produces a sampled single-lead or multi-lead ECG in
+// millivolts. One turn around the limit cycle is one beat, so R peaks follow
+// the patient's RR intervals.
+type WaveformGenerator struct {
+	Patient    SimulatedPatient
+	SampleRate int
+	Morphology []WaveComponent
+
+	phase   float64
+	rr      float64
+	samples int64
+
+	// The cycle runs from the R peak of beatType to the R peak of nextType.
+	beatType ecg.BeatType
+	nextType ecg.BeatType
+
+	hrv       *HRVGenerator
+	ectopy    *ectopySequencer
+	artifacts []artifactState
+	quality   ecg.SignalQuality // Worst of the samples last generated
+
+	// Supplies the type and RR interval of each beat instead of the patient
+	// model when set.
+	rhythm func() (ecg.BeatType, float64)
+}
+
+func NewWaveformGenerator(patient SimulatedPatient, sampleRate int) (*WaveformGenerator, error) {
+	if sampleRate <= 0 {
+		return nil, fmt.Errorf("invalid sample rate %d Hz", sampleRate)
+	}
+
+	return &WaveformGenerator{
+		Patient:    patient,
+		SampleRate: sampleRate,
+		Morphology: DefaultMorphology(),
+	}, nil
+}
+
+func (g *WaveformGenerator) Generate(duration time.Duration) ([]float64, []Beat) {
+	n := int(duration.Seconds() * float64(g.SampleRate))
+	g.quality = ecg.SignalGood
+	samples := make([]float64, 0, n)
+	value := make([]float64, 1)
+
+	beats := g.generate(n, func(organized bool) {
+		if organized {
+			value[0] = g.value()
+		} else {
+			value[0] = g.disorganizedValue()
+		}
+		g.corrupt(value)
+		samples = append(samples, value[0])
+	})
+
+	return samples, beats
+}
+
+// GenerateLeads generates the selected leads from the morphology's dipoles.
+// Samples of the i-th lead are in the i-th slice.
+func (g *WaveformGenerator) GenerateLeads(duration time.Duration, leads []ecg.Lead) ([][]float64, []Beat) {
+	n := int(duration.Seconds() * float64(g.SampleRate))
+	g.quality = ecg.SignalGood
+	samples := make([][]float64, len(leads))
+	for i := range samples {
+		samples[i] = make([]float64, 0, n)
+	}
+	values := make([]float64, len(leads))
+
+	beats := g.generate(n, func(organized bool) {
+		var dipole Vector
+		if organized {
+			dipole = g.dipole()
+		} else {
+			dipole = g.disorganizedDipole()
+		}
+		for i, lead := range leads {
+			values[i] = projectLead(dipole, lead)
+		}
+		g.corrupt(values)
+		for i := range leads {
+			samples[i] = append(samples[i], values[i])
+		}
+	})
+
+	return samples, beats
+}
+
+// generate advances the model by n samples, calling sample for each one before
+// the phase moves on, and returns the beats whose R peaks were passed.
+func (g *WaveformGenerator) generate(n int, sample func(organized bool)) []Beat {
+	var beats []Beat
+	dt := 1.0 / float64(g.SampleRate)
+
+	for i := 0; i < n; i++ {
+		// No organised ventricular activity: no beats, and the cycle resumes
+		// where it stopped once the rhythm returns.
+		if g.Patient.SimulateVentricularFibrillation || g.Patient.SimulateAsystole {
+			sample(false)
+			g.samples++
+			continue
+		}
+
+		if g.rr == 0 {
+			g.beatType = ecg.BeatNormal
+			g.nextType, g.rr = g.nextBeat()
+		}
+
+		sample(true)
+
+		g.samples++
+		g.phase += 2 * math.Pi * dt / g.rr
+		if g.phase >= 2*math.Pi {
+			overshoot := (g.phase - 2*math.Pi) / (2 * math.Pi) * g.rr
+			peak := time.Duration((float64(g.samples)*dt - overshoot) * float64(time.Second))
+			beats = append(beats, Beat{Time: peak, RR: g.rr, Type: g.nextType})
+
+			g.beatType = g.nextType
+			g.nextType, g.rr = g.nextBeat()
+			g.phase = overshoot / g.rr * 2 * math.Pi
+		}
+	}
+
+	return beats
+}
+
+func (g *WaveformGenerator) nextBeat() (ecg.BeatType, float64) {
+	if g.rhythm != nil {
+		return g.rhythm()
+	}
+
+	rr := g.nextRR()
+
+	if g.Patient.SimulateVentricularTachycardia {
+		return ecg.BeatVentricular, rr
+	}
+
+	if g.Patient.Ectopy == nil || !g.Patient.sinusRhythm() {
+		return ecg.BeatNormal, rr
+	}
+
+	if g.ectopy == nil || g.ectopy.parameters != *g.Patient.Ectopy {
+		g.ectopy = newEctopySequencer(*g.Patient.Ectopy, g.Patient.random())
+	}
+	return g.ectopy.next(rr)
+}
+
+func (g *WaveformGenerator) nextRR() float64 {
+	if g.Patient.HRV != nil && g.Patient.sinusRhythm() {
+		if g.hrv == nil || g.hrv.Parameters != *g.Patient.HRV {
+			g.hrv = NewHRVGenerator(*g.Patient.HRV, g.Patient.random())
+		}
+		return math.Max(60.0/meanHeartRate(g.Patient)+g.hrv.Offset(g.rr), 0.25)
+	}
+
+	rr := GenerateECGReading(g.Patient).RRInterval
+	if rr <= 0 {
+		rr = 60.0 / float64(g.Patient.BaseHeartRate)
+	}
+	return rr
+}
+
+// Closed form of the ECGSYN z equation, with widths and P/T positions scaled by
+// heart rate as in the original model.
+func (g *WaveformGenerator) value() float64 {
+	z := 0.0
+	g.waves(func(c WaveComponent, weight float64) {
+		z += c.Amplitude * weight
+	})
+
+	if g.Patient.SimulateAtrialFibrillation {
+		z += fibrillatoryWave(float64(g.samples) / float64(g.SampleRate))
+	}
+
+	return z
+}
+
+// dipole is the multi-lead counterpart of value: the sum of the components'
+// dipoles, turned to the patient's QRS axis.
+func (g *WaveformGenerator) dipole() Vector {
+	var v Vector
+	g.waves(func(c WaveComponent, weight float64) {
+		v = v.add(c.Vector.scale(weight))
+	})
+
+	if g.Patient.SimulateAtrialFibrillation {
+		v = v.add(fibrillatoryVector.scale(fibrillatoryWave(float64(g.samples) / float64(g.SampleRate))))
+	}
+
+	return g.orient(v)
+}
+
+func (g *WaveformGenerator) orient(v Vector) Vector {
+	return v.rotateFrontal((g.Patient.QRSAxis - DefaultQRSAxis) * math.Pi / 180)
+}
+
+// waves calls wave with each component adapted to its beat type and the
+// component's Gaussian weight at the current phase.
+func (g *WaveformGenerator) waves(wave func(c WaveComponent, weight float64)) {
+	hrFactor := math.Sqrt(1.0 / g.rr)
+
+	for _, component := range g.Morphology {
+		// Waves before the R peak belong to the beat that ends the cycle.
+		beatType := g.beatType
+		if component.Angle < 0 {
+			beatType = g.nextType
+		}
+
+		c, ok := g.component(component, beatType)
+		if !ok {
+			continue
+		}
+
+		angle := c.Angle
+		if c.Name == "P" || c.Name == "T" {
+			angle *= math.Sqrt(hrFactor)
+		}
+		width := c.Width * hrFactor
+
+		d := math.Remainder(g.phase-angle, 2*math.Pi)
+		wave(c, math.Exp(-d*d/(2*width*width)))
+	}
+}
+
+// qrsDuration estimates the QRS width of a beat from the morphology, from two
+// Gaussian widths before the first QRS wave to two widths after the last.
+func (g *WaveformGenerator) qrsDuration(beatType ecg.BeatType, rr float64) float64 {
+	hrFactor := math.Sqrt(1.0 / rr)
+	start, end := math.Inf(1), math.Inf(-1)
+
+	for _, component := range g.Morphology {
+		if component.Name != "Q" && component.Name != "R" && component.Name != "S" {
+			continue
+		}
+		c, ok := g.component(component, beatType)
+		if !ok {
+			continue
+		}
+		width := c.Width * hrFactor
+		start = math.Min(start, c.Angle-2*width)
+		end = math.Max(end, c.Angle+2*width)
+	}
+
+	if start > end {
+		return 0
+	}
+	return (end - start) / (2 * math.Pi) * rr
+}
+
+func (g *WaveformGenerator) corrupt(values []float64) {
+	if len(g.Patient.Artifacts) == 0 {
+		return
+	}
+
+	if !slices.EqualFunc(g.artifacts, g.Patient.Artifacts, func(s artifactState, c ArtifactConfig) bool { return s.config == c }) {
+		g.artifacts = make([]artifactState, len(g.Patient.Artifacts))
+		for i, config := range g.Patient.Artifacts {
+			g.artifacts[i] = artifactState{config: config}
+		}
+	}
+
+	dt := 1.0 / float64(g.SampleRate)
+	quality := applyArtifacts(g.artifacts, values, float64(g.samples)*dt, dt, g.Patient.random())
+	if quality == ecg.SignalLeadOff || g.quality == ecg.SignalGood {
+		g.quality = quality
+	}
+}
+
+// SignalQuality is the worst quality of the samples last generated.
+func (g *WaveformGenerator) SignalQuality() ecg.SignalQuality {
+	return g.quality
+}
+
+func (g *WaveformGenerator) disorganizedValue() float64 {
+	if g.Patient.SimulateAsystole {
+		return 0
+	}
+	return ventricularFibrillationWave(float64(g.samples) / float64(g.SampleRate))
+}
+
+// disorganizedDipole wanders in all three directions, so that each lead shows
+// a different fibrillation waveform.
+func (g *WaveformGenerator) disorganizedDipole() Vector {
+	if g.Patient.SimulateAsystole {
+		return Vector{}
+	}
+
+	t := float64(g.samples) / float64(g.SampleRate)
+	return g.orient(Vector{
+		fibrillationVector[0] * ventricularFibrillationWave(t),
+		fibrillationVector[1] * ventricularFibrillationWave(t+0.13),
+		fibrillationVector[2] * ventricularFibrillationWave(t+0.29),
+	})
+}
+
+// component adapts a wave of the default morphology to the beat type.
+// Ventricular beats have no P wave, a wide QRS and a discordant T wave; PACs
+// have an abnormal P wave from the ectopic atrial focus.
+func (g *WaveformGenerator) component(c WaveComponent, beatType ecg.BeatType) (WaveComponent, bool) {
+	if c.Name == "P" && g.Patient.SimulateAtrialFibrillation {
+		return c, false
+	}
+
+	switch beatType {
+	case ecg.BeatPVC, ecg.BeatVentricular:
+		switch c.Name {
+		case "P", "Q":
+			return c, false
+		case "R":
+			c.Amplitude *= 1.3
+			c.Vector = c.Vector.scale(1.3)
+			c.Width *= 2.5
+		case "S":
+			c.Amplitude *= 2.5
+			c.Vector = c.Vector.scale(2.5)
+			c.Angle *= 2.5
+			c.Width *= 2.5
+		case "T":
+			c.Amplitude = -1.6 * c.Amplitude
+			c.Vector = c.Vector.scale(-1.6)
+			c.Width *= 1.3
+		}
+	case ecg.BeatPAC:
+		if c.Name == "P" {
+			c.Amplitude = -0.7 * c.Amplitude
+			c.Vector = c.Vector.scale(-0.7)
+			c.Width *= 0.8
+		}
+	}
+
+	return c, true
+}
@@ -2,18 +2,39 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
+	"arhm/ecg-monitoring/pkg/replay"
 	"arhm/ecg-monitoring/pkg/server"
+	"arhm/ecg-monitoring/pkg/simulation"
 )
 
 var addr = flag.String("addr", "localhost:8080", "http service address")
 var logFile = flag.String("logfile", "server/logs/ecg.log", "general log file")
 var alertLogFile = flag.String("alertlog", "server/logs/alerts.log", "alerts-only log file")
+var patientCount = flag.Int("patients", 1, "number of simulated patients")
+var seed = flag.Int64("seed", 0, "random seed for a reproducible simulation (0 uses the scenario seed or a random one)")
+var rampTime = flag.Duration("ramp", 0, "time constant for heart rate transitions between conditions (0 switches abruptly)")
+var overshoot = flag.Float64("overshoot", 0, "fraction of a heart rate change to overshoot during transitions")
+var scenarioFile = flag.String("scenario", "", "scenario file describing each patient's timeline (overrides -patients)")
+var markov = flag.Bool("markov", false, "change conditions at random following the default Markov chain instead of the fixed cycle (not with -scenario)")
+var circadian = flag.Bool("circadian", false, "modulate each patient's heart rate with the default daily sleep and activity profile (not with -scenario)")
+var speed = flag.Float64("speed", 1, "simulated seconds per real second (60 runs an hour a minute, 0 runs as fast as clients read)")
+var waveformRate = flag.Int("waveform", 0, "sample rate in Hz of the 12-lead waveform attached to readings (0 sends none)")
+var vitals = flag.Bool("vitals", false, "simulate SpO2, respiration and blood pressure alongside the ECG (not with -scenario)")
+var replayFile = flag.String("replay", "", "recorded CSV or NDJSON readings to stream instead of simulating")
+var replaySpeed = flag.Float64("replay-speed", 1, "playback speed for -replay (2 plays twice as fast)")
+var replayLoop = flag.Bool("replay-loop", false, "restart -replay from the beginning when it ends")
+var queueSize = flag.Int("queue", server.DefaultQueueSize, "readings queued per client before the slow consumer policy applies")
+var groundTruth = flag.Bool("ground-truth", false, "send the simulator's condition and episode ID with each reading, for validating detectors")
+var slowConsumer = flag.String("slow-consumer", string(server.DropOldest), "what to do with a client whose queue is full: drop_oldest or disconnect")
 
 func main() {
 	flag.Parse()
@@ -24,8 +45,59 @@ func main() {
 	}
 	defer loggers.Close()
 
-	ecgHandler := server.NewECGHandler(loggers)
+	if *speed < 0 {
+		log.Fatalf("Invalid speed: %g", *speed)
+	}
+	clock := simulation.NewClock(*speed)
+
+	if *queueSize < 1 {
+		log.Fatalf("Invalid queue size: %d", *queueSize)
+	}
+	policy, err := server.ParseSlowConsumerPolicy(*slowConsumer)
+	if err != nil {
+		log.Fatalf("Invalid slow consumer policy: %v", err)
+	}
+
+	var ecgHandler *server.ECGHandler
+	if *replayFile != "" {
+		sources, err := buildReplaySources(clock)
+		if err != nil {
+			log.Fatalf("Failed to setup replay: %v", err)
+		}
+
+		var ids []string
+		for _, source := range sources {
+			ids = append(ids, source.PatientID())
+		}
+		loggers.General.Printf("Replaying %s at %gx: %s", *replayFile, *replaySpeed, strings.Join(ids, ", "))
+
+		ecgHandler = server.NewSourceECGHandler(loggers, sources...)
+	} else {
+		roster, simulationSeed, err := buildRoster()
+		if err != nil {
+			log.Fatalf("Failed to setup simulation: %v", err)
+		}
+		loggers.General.Printf("Simulation seed: %d (pass -seed %d to replay this run)", simulationSeed, simulationSeed)
+		loggers.General.Printf("Simulating patients: %s", strings.Join(roster.PatientIDs(), ", "))
+		roster.SetClock(clock)
+		// Clients may subscribe to beats at any time.
+		roster.SetBeats(true)
+		if *waveformRate != 0 {
+			if err := roster.SetWaveform(*waveformRate); err != nil {
+				log.Fatalf("Failed to setup waveform: %v", err)
+			}
+		}
+
+		ecgHandler = server.NewRosterECGHandler(loggers, roster)
+		ecgHandler.Control.Register(http.DefaultServeMux)
+	}
+	ecgHandler.Hub.QueueSize = *queueSize
+	ecgHandler.Hub.Policy = policy
+	// In batch mode clients set the pace, so nothing is dropped.
+	_, ecgHandler.Hub.Blocking = clock.(*simulation.BatchClock)
+	ecgHandler.GroundTruth = *groundTruth
 	http.Handle("/ecg", ecgHandler)
+	http.Handle("/ecg/{patientID}", ecgHandler)
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
@@ -41,3 +113,82 @@ func main() {
 	<-stop
 	loggers.General.Println("Shutting down server...")
 }
+
+func buildRoster() (*simulation.Roster, int64, error) {
+	var roster *simulation.Roster
+	var scenarioSeed *int64
+
+	if *scenarioFile != "" {
+		// Scenario files set these per patient, under markov and parameters.
+		for _, f := range []struct {
+			name string
+			set  bool
+		}{{"-markov", *markov}, {"-circadian", *circadian}, {"-vitals", *vitals}} {
+			if f.set {
+				return nil, 0, fmt.Errorf("%s conflicts with -scenario; set it for each patient in the scenario file", f.name)
+			}
+		}
+
+		scenario, err := simulation.LoadScenario(*scenarioFile)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		roster, err = scenario.Roster(server.ReadingInterval)
+		if err != nil {
+			return nil, 0, err
+		}
+		scenarioSeed = scenario.Seed
+	} else {
+		if *patientCount < 1 {
+			return nil, 0, fmt.Errorf("invalid patient count: %d", *patientCount)
+		}
+		roster = simulation.NewRoster(*patientCount)
+		if *markov {
+			roster.SetMarkov(simulation.DefaultMarkovChain())
+		}
+		if *circadian {
+			roster.SetProfile(simulation.NewDefaultDailyProfile())
+		}
+		if *vitals {
+			roster.SetVitals(simulation.NewDefaultVitals())
+		}
+	}
+
+	if *rampTime > 0 {
+		if *overshoot < 0 || *overshoot >= 1 {
+			return nil, 0, fmt.Errorf("invalid overshoot: %g", *overshoot)
+		}
+		roster.SetTransition(simulation.Transition{TimeConstant: *rampTime, Overshoot: *overshoot})
+	}
+
+	switch {
+	case *seed != 0:
+		roster.Seed(*seed)
+		return roster, *seed, nil
+	case scenarioSeed != nil:
+		return roster, *scenarioSeed, nil
+	default:
+		randomSeed := time.Now().UnixNano()
+		roster.Seed(randomSeed)
+		return roster, randomSeed, nil
+	}
+}
+
+func buildReplaySources(clock simulation.Clock) ([]server.ReadingSource, error) {
+	if *replaySpeed <= 0 {
+		return nil, fmt.Errorf("invalid replay speed: %g", *replaySpeed)
+	}
+
+	records, err := replay.Load(*replayFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []server.ReadingSource
+	for _, source := range replay.Sources(records, *replaySpeed, *replayLoop) {
+		source.Clock = clock
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
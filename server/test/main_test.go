@@ -5,7 +5,9 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"arhm/ecg-monitoring/pkg/replay"
 	"arhm/ecg-monitoring/pkg/server"
+	"arhm/ecg-monitoring/pkg/simulation"
 )
 
 func TestServerHandlers(t *testing.T) {
@@ -39,3 +41,25 @@ func TestServerHandlers(t *testing.T) {
 		t.Errorf("Expected status 400 for non-WebSocket request, got %d", resp.StatusCode)
 	}
 }
+
+func TestDemoScenario(t *testing.T) {
+	scenario, err := simulation.LoadScenario("../scenarios/demo.json")
+	if err != nil {
+		t.Fatalf("Failed to load demo scenario: %v", err)
+	}
+
+	if _, err := scenario.Roster(server.ReadingInterval); err != nil {
+		t.Fatalf("Failed to build roster from demo scenario: %v", err)
+	}
+}
+
+func TestSampleRecording(t *testing.T) {
+	records, err := replay.Load("../recordings/sample.csv")
+	if err != nil {
+		t.Fatalf("Failed to load sample recording: %v", err)
+	}
+
+	if sources := replay.Sources(records, 1, false); len(sources) != 1 {
+		t.Errorf("Expected one patient in sample recording, got %d", len(sources))
+	}
+}